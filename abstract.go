@@ -0,0 +1,31 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Socket directory selecting the abstract namespace of Linux.  Socket names starting
+// with "@" are abstract, so plugins built with older versions of this package, which
+// join the directory and the name, use it as well.
+const abstractDir = "@"
+
+// Return the socket directory of p if none is set.  On Linux, sockets are created in the
+// abstract namespace: they have no file that could be left over or opened by others, and
+// go away with the plugin.  Sandboxed plugins might not share the network namespace of
+// the host, where abstract sockets live, so they use files.
+func (p *Plugin) defaultSocketDir() string {
+	if runtime.GOOS == "linux" && p.proto == "unix" && p.sandbox == nil {
+		return abstractDir
+	}
+	return os.TempDir()
+}
+
+func isAbstractSocket(addr string) bool {
+	return strings.HasPrefix(addr, abstractDir)
+}
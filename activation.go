@@ -0,0 +1,108 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// Plugins can receive their listener already open, following the convention of
+// systemd socket activation: the listener is file descriptor 3 and LISTEN_FDS is
+// set.  LISTEN_PID must be the pid of the plugin; as a host cannot know the pid
+// before starting the plugin, it sets LISTEN_FDNAMES to "pingo" instead.
+
+const (
+	listenFdsStart    = 3
+	activationFdsName = "pingo"
+)
+
+// SetSocketActivation makes the host open the listener of the plugin and pass it
+// to the plugin process, instead of letting the plugin listen by itself.  This allows
+// running plugins through launchers like "systemd-run --scope" (see SetSandbox),
+// as the socket is ready before the plugin starts.
+//
+// Not supported on Windows.  Panics if called after Start.
+func (p *Plugin) SetSocketActivation(enabled bool) {
+	if p.running {
+		panic("Cannot call SetSocketActivation after Start")
+	}
+	p.activate = enabled
+}
+
+// Open a listener for a plugin and return it as a file to be inherited.
+func activationFile(proto, dir string) (*os.File, string, error) {
+	switch proto {
+	case "tcp":
+		l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return nil, "", err
+		}
+		defer l.Close()
+		f, err := l.File()
+		return f, "", err
+	default:
+		u := unix(dir)
+		path := u.addr()
+		l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		if err != nil {
+			return nil, "", err
+		}
+		// The socket is removed once the host is connected
+		l.SetUnlinkOnClose(false)
+		defer l.Close()
+		f, err := l.File()
+		if err != nil {
+			os.Remove(path)
+			return nil, "", err
+		}
+		return f, path, nil
+	}
+}
+
+// Pass f to cmd as its activation listener.
+func setActivation(cmd *exec.Cmd, f *os.File) {
+	cmd.ExtraFiles = []*os.File{f}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "LISTEN_FDS=1", "LISTEN_FDNAMES="+activationFdsName)
+}
+
+// Return the listener passed by the activator, or nil if none was passed.
+func activationListener() (net.Listener, error) {
+	fds := os.Getenv("LISTEN_FDS")
+	if fds == "" {
+		return nil, nil
+	}
+	if pid := os.Getenv("LISTEN_PID"); pid != "" {
+		if pid != strconv.Itoa(os.Getpid()) {
+			return nil, nil
+		}
+	} else if os.Getenv("LISTEN_FDNAMES") != activationFdsName {
+		return nil, nil
+	}
+
+	// Not for processes started by the plugin
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("Invalid LISTEN_FDS: %q", fds)
+	}
+	if n > 1 {
+		return nil, errors.New("Only one activation socket is supported")
+	}
+
+	f := os.NewFile(listenFdsStart, "listen-fd")
+	defer f.Close()
+	return net.FileListener(f)
+}
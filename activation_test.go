@@ -0,0 +1,56 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+func TestSetActivationKeepsEnv(t *testing.T) {
+	cmd := exec.Command("true")
+	cmd.Env = []string{"PINGO_TEST=1"}
+	setActivation(cmd, os.Stdin)
+	if len(cmd.Env) != 3 || cmd.Env[0] != "PINGO_TEST=1" {
+		t.Fatalf("environment of %d variables replaced", len(cmd.Env))
+	}
+}
+
+// Number of open file descriptors of the process.
+func openFiles(t *testing.T) int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count open files:", err)
+	}
+	return len(fds)
+}
+
+func TestActivationFailedStart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket activation not supported")
+	}
+	start := func() {
+		p := newFixture(t, "unix", "pingo-hello-world")
+		p.SetSocketActivation(true)
+		p.SetChecksum("0000")
+		p.Start()
+		if err := sayHello(p); err == nil {
+			t.Fatal("plugin started with a wrong checksum")
+		}
+		p.Stop()
+	}
+	// Files opened once by the package
+	start()
+
+	before := openFiles(t)
+	for i := 0; i < 5; i++ {
+		start()
+	}
+	if after := openFiles(t); after > before {
+		t.Fatalf("%d files left open by failed starts", after-before)
+	}
+}
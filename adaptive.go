@@ -0,0 +1,124 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// Startup times remembered for each plugin
+	maxStartupSamples = 20
+	// Startup times needed before the timeout is adapted
+	minStartupSamples = 5
+)
+
+type adaptiveTimeout struct {
+	factor float64
+	min    time.Duration
+}
+
+// SetAdaptiveTimeout makes the manager learn how long each plugin takes to register and
+// set the registration timeout of plugins added later to factor times the 95th percentile
+// of the startup times observed for the same name, but at least min.  The timeout is only
+// adapted once a few startups have been observed; until then the plugin keeps its own.
+//
+// Startup times are only remembered while the manager exists: use SaveState and LoadState
+// to keep them across runs of the host.  A factor of zero disables the adaptive timeout.
+func (m *Manager) SetAdaptiveTimeout(factor float64, min time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if factor <= 0 {
+		m.adaptive = nil
+		return
+	}
+	m.adaptive = &adaptiveTimeout{factor: factor, min: min}
+}
+
+// Set the registration timeout of p from the startup times of name and record the
+// startup time of p when it gets ready.  Must be called with m.mux held.
+func (m *Manager) adaptTimeout(name string, p *Plugin) {
+	// Attached plugins are not started by the host
+	if p.running || p.attachTo != "" {
+		return
+	}
+	if m.adaptive != nil && len(m.startups[name]) >= minStartupSamples {
+		t := time.Duration(float64(percentile(m.startups[name], 0.95)) * m.adaptive.factor)
+		if t < m.adaptive.min {
+			t = m.adaptive.min
+		}
+		p.initTimeout = t
+	}
+	p.readyFn = func(t StartupTimings) {
+		m.recordStartup(name, t.Ready+t.Dial)
+	}
+}
+
+func (m *Manager) recordStartup(name string, d time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	samples := append(m.startups[name], d)
+	if len(samples) > maxStartupSamples {
+		samples = samples[len(samples)-maxStartupSamples:]
+	}
+	m.startups[name] = samples
+}
+
+// Return the p-th percentile of samples, by the nearest rank.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// State of a manager that is worth keeping across runs of the host.
+type managerState struct {
+	// Recent startup times by plugin name
+	Startups map[string][]time.Duration `json:"startups"`
+}
+
+// SaveState writes the startup times observed by the manager to w, to be loaded with
+// LoadState by a later run of the host.
+func (m *Manager) SaveState(w io.Writer) error {
+	m.mux.Lock()
+	state := managerState{Startups: make(map[string][]time.Duration, len(m.startups))}
+	for name, samples := range m.startups {
+		state.Startups[name] = append([]time.Duration(nil), samples...)
+	}
+	m.mux.Unlock()
+
+	return json.NewEncoder(w).Encode(&state)
+}
+
+// LoadState reads startup times saved with SaveState, replacing the ones observed for the
+// same plugin names.  Load the state before adding plugins for it to affect their timeout.
+func (m *Manager) LoadState(r io.Reader) error {
+	var state managerState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	for name, samples := range state.Startups {
+		if len(samples) > maxStartupSamples {
+			samples = samples[len(samples)-maxStartupSamples:]
+		}
+		m.startups[name] = samples
+	}
+	return nil
+}
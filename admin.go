@@ -0,0 +1,226 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SetRestartFunc sets the function creating the plugin that replaces old, the plugin
+// added as name, when restarted through Handler.  Like RestartOpts.New, it returns a
+// plugin configured but not started.  Restarts are refused until it is set.
+func (m *Manager) SetRestartFunc(fn func(name string, old *Plugin) *Plugin) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.restartFn = fn
+}
+
+// Status of a plugin reported by the handler.
+type pluginStatus struct {
+	Name   string
+	Stats  Stats
+	Output []string `json:",omitempty"`
+}
+
+// Result of a stop through the handler.
+type stopStatus struct {
+	Result StopResult
+	Error  string `json:",omitempty"`
+}
+
+// Handler returns an HTTP handler exposing the plugins of the manager to operators, as
+// JSON:
+//
+//	GET  /                list of plugins, with their state and statistics
+//	GET  /{name}          state, statistics and recent output of a plugin, see OutputTail
+//	GET  /{name}/health   health of a plugin, see Plugin.Health
+//	GET  /{name}/output   recent and new output of a plugin, as server-sent events
+//	POST /{name}/start    start a plugin that was not started yet
+//	POST /{name}/stop     stop a plugin that was started, reporting how it ended
+//	POST /{name}/restart  replace a plugin with a new one, see SetRestartFunc
+//
+// Each line of output is sent as the data of a server-sent event, not in JSON.  Once
+// the plugin is stopped, a "stopped" event ends the stream.  Lines are dropped for clients that do not
+// keep up.
+//
+// Errors are reported as an object with an "Error" field.  Use http.StripPrefix to
+// mount the handler under a path of another mux.  The handler does no authentication:
+// only make it reachable by operators.
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(m.serveAdmin)
+}
+
+func (m *Manager) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "" {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		m.serveList(w)
+		return
+	}
+	name, action, _ := strings.Cut(path, "/")
+	p := m.Plugin(name)
+	if p == nil {
+		writeError(w, http.StatusNotFound, "Unknown plugin "+name)
+		return
+	}
+
+	switch action {
+	case "":
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, pluginStatus{Name: name, Stats: p.Stats(), Output: p.OutputTail()})
+		}
+	case "health":
+		if allowMethod(w, r, http.MethodGet) {
+			m.serveHealth(w, r, p)
+		}
+	case "output":
+		if allowMethod(w, r, http.MethodGet) {
+			m.serveOutput(w, r, p)
+		}
+	case "start":
+		if allowMethod(w, r, http.MethodPost) {
+			m.serveStart(w, name, p)
+		}
+	case "stop":
+		if allowMethod(w, r, http.MethodPost) {
+			m.serveStop(w, name, p)
+		}
+	case "restart":
+		if allowMethod(w, r, http.MethodPost) {
+			m.serveRestart(w, r, name)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "Unknown action "+action)
+	}
+}
+
+func (m *Manager) serveList(w http.ResponseWriter) {
+	list := make([]pluginStatus, 0)
+	for _, name := range m.Names() {
+		if p := m.Plugin(name); p != nil {
+			list = append(list, pluginStatus{Name: name, Stats: p.Stats()})
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (m *Manager) serveHealth(w http.ResponseWriter, r *http.Request, p *Plugin) {
+	h := &Health{}
+	if err := p.callContext(r.Context(), internalObject+".Health", 0, h); err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if !h.Healthy() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, h)
+}
+
+func (m *Manager) serveOutput(w http.ResponseWriter, r *http.Request, p *Plugin) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+	tail, lines, cancel := p.tail.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, line := range tail {
+		writeEvent(w, line)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case line := <-lines:
+			writeEvent(w, line)
+			flusher.Flush()
+		case <-p.Done():
+			io.WriteString(w, "event: stopped\ndata:\n\n")
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (m *Manager) serveStart(w http.ResponseWriter, name string, p *Plugin) {
+	if p.State() != StateNew {
+		writeError(w, http.StatusConflict, "Plugin "+name+" has already been started")
+		return
+	}
+	p.Start()
+	writeJSON(w, http.StatusOK, pluginStatus{Name: name, Stats: p.Stats()})
+}
+
+func (m *Manager) serveStop(w http.ResponseWriter, name string, p *Plugin) {
+	if p.State() == StateNew {
+		writeError(w, http.StatusConflict, "Plugin "+name+" has not been started")
+		return
+	}
+	var res stopStatus
+	var err error
+	if res.Result, err = p.Stop(); err != nil {
+		res.Error = err.Error()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (m *Manager) serveRestart(w http.ResponseWriter, r *http.Request, name string) {
+	m.mux.Lock()
+	fn := m.restartFn
+	m.mux.Unlock()
+	if fn == nil {
+		writeError(w, http.StatusNotImplemented, "Restart is not configured, see SetRestartFunc")
+		return
+	}
+	opts := RestartOpts{New: fn, Names: []string{name}}
+	if err := m.RollingRestart(r.Context(), opts); err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	p := m.Plugin(name)
+	if p == nil {
+		writeError(w, http.StatusNotFound, "Plugin "+name+" has been removed")
+		return
+	}
+	writeJSON(w, http.StatusOK, pluginStatus{Name: name, Stats: p.Stats()})
+}
+
+// Write an error unless the request uses method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "Method "+r.Method+" not allowed")
+	return false
+}
+
+// Write a server-sent event.  Carriage returns would end its data.
+func writeEvent(w http.ResponseWriter, data string) {
+	fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(data, "\r", ""))
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, struct{ Error string }{msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import "strings"
+
+// SetANSIPassthrough sets whether the output of the plugin is passed to the ErrorHandler
+// with its ANSI escape sequences, like colors, for hosts rendering it on a terminal.  By
+// default, they are removed.  Output kept by OutputTail, reported as events or written
+// to the output file is never changed.
+//
+// Panics if called after Start.
+func (p *Plugin) SetANSIPassthrough(pass bool) {
+	if p.running {
+		panic("Cannot call SetANSIPassthrough after Start")
+	}
+	p.ansiPass = pass
+}
+
+// Return a line of output as passed to the ErrorHandler.
+func (p *Plugin) forwardedLine(line string) string {
+	if p.ansiPass {
+		return line
+	}
+	return stripANSI(line)
+}
+
+// Remove the ANSI escape sequences from s.
+func stripANSI(s string) string {
+	const esc = 0x1b
+	if strings.IndexByte(s, esc) < 0 {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != esc {
+			sb.WriteByte(s[i])
+			i++
+			continue
+		}
+		i++
+		if i == len(s) {
+			break
+		}
+		switch s[i] {
+		case '[':
+			// Control sequence: parameters and intermediate bytes, then a final byte
+			i++
+			for i < len(s) && s[i] >= 0x20 && s[i] <= 0x3f {
+				i++
+			}
+			if i < len(s) && s[i] >= 0x40 && s[i] <= 0x7e {
+				i++
+			}
+		case ']':
+			// Operating system command, ended by BEL or by ESC \
+			i++
+			for i < len(s) && s[i] != 0x07 && s[i] != esc {
+				i++
+			}
+			if i < len(s) && s[i] == 0x07 {
+				i++
+			} else if i+1 < len(s) && s[i+1] == '\\' {
+				i += 2
+			}
+		default:
+			// Other sequences: intermediate bytes, then a final byte
+			for i < len(s) && s[i] >= 0x20 && s[i] <= 0x2f {
+				i++
+			}
+			if i < len(s) && s[i] >= 0x30 && s[i] <= 0x7e {
+				i++
+			}
+		}
+	}
+	return sb.String()
+}
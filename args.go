@@ -0,0 +1,26 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import "flag"
+
+// Args returns the parameters passed to the plugin by the host, see NewPlugin, without
+// the flags used by this package.  Plugins with flags of their own can parse them from
+// Args with a flag.FlagSet.
+func Args() []string {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	return flag.Args()
+}
+
+// Append the parameters of the plugin to the flags in args, after a separator so that
+// they are not parsed as flags by the plugin.
+func (p *Plugin) withParams(args []string) []string {
+	if len(p.params) == 0 {
+		return args
+	}
+	return append(append(args, "--"), p.params...)
+}
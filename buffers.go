@@ -0,0 +1,83 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"sync"
+)
+
+// Buffers larger than this are left to the garbage collector.
+const maxPooledBuffer = 64 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// Writer keeping the size of the last message written by a gob encoder, which writes
+// each message, type definitions included, with a single call.
+type lastWriter int
+
+func (l *lastWriter) Write(data []byte) (int, error) {
+	*l = lastWriter(len(data))
+	return len(data), nil
+}
+
+// Encoder measuring the messages it would write.
+type sizeEncoder struct {
+	enc *gob.Encoder
+	n   lastWriter
+}
+
+// Pools of size encoders by type of the encoded value.  An encoder only sends
+// type information the first time it sees a type, so encoders are reused only
+// for the same type.
+var sizeEncoders sync.Map
+
+// Size of v once encoded, without the type information sent before it.  Size
+// limits apply to each message, and type information is sent in messages of its
+// own: the size is the same whether the encoder has seen the type before or not.
+func encodedSize(v interface{}) (int, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		var n lastWriter
+		err := gob.NewEncoder(&n).Encode(v)
+		return int(n), err
+	}
+
+	pi, _ := sizeEncoders.LoadOrStore(t, &sync.Pool{})
+	pool := pi.(*sync.Pool)
+
+	se, _ := pool.Get().(*sizeEncoder)
+	if se == nil {
+		se = &sizeEncoder{}
+		se.enc = gob.NewEncoder(&se.n)
+	}
+	se.n = 0
+	if err := se.enc.Encode(v); err != nil {
+		// The state of the encoder is unknown, do not reuse it
+		return 0, err
+	}
+	n := int(se.n)
+	pool.Put(se)
+	return n, nil
+}
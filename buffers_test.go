@@ -0,0 +1,95 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+)
+
+type sizedArgs struct {
+	Name  string
+	Items []int
+	Meta  map[string]string
+}
+
+func newSizedArgs() sizedArgs {
+	return sizedArgs{
+		Name:  strings.Repeat("x", 100),
+		Items: make([]int, 100),
+		Meta:  map[string]string{"a": "b"},
+	}
+}
+
+func TestEncodedSizeIgnoresTypeInfo(t *testing.T) {
+	v := newSizedArgs()
+
+	// Size of the second message on a stream, once the type has been sent
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	first := buf.Len()
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	want := buf.Len() - first
+
+	// Whether or not a pooled encoder has seen the type before
+	for i := 0; i < 3; i++ {
+		n, err := encodedSize(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Fatalf("encoded size %d at call %d, expected %d", n, i, want)
+		}
+	}
+}
+
+func BenchmarkEncodedSize(b *testing.B) {
+	v := newSizedArgs()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := encodedSize(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Measuring with a new encoder each time, as without the pool.
+func BenchmarkEncodedSizeUnpooled(b *testing.B) {
+	v := newSizedArgs()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var n lastWriter
+		if err := gob.NewEncoder(&n).Encode(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCallLimited(b *testing.B) {
+	p := newFixture(b, "unix", "pingo-hello-world")
+	p.SetMessageLimits(1<<20, 1<<20)
+	p.Start()
+	defer p.Stop()
+
+	name := strings.Repeat("x", 1024)
+	var resp string
+	if err := p.Call("Plugin.SayHello", name, &resp); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Call("Plugin.SayHello", name, &resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+)
+
+// Caller performs calls to the objects of a plugin.  It is implemented by Plugin and by
+// callers combining plugins, like the one returned by Fallback.
+type Caller interface {
+	Call(name string, args interface{}, resp interface{}) error
+	CallContext(ctx context.Context, name string, args interface{}, resp interface{}) error
+}
+
+// Caller serving calls with objects of the host.
+type inProcess struct {
+	client *rpc.Client
+}
+
+// NewInProcess returns a caller serving calls with objects of the host instead of a
+// plugin, for example as default implementation for Fallback.  The objects obey the same
+// rules as those passed to Register, and calls encode their arguments and responses
+// like calls to plugins.
+//
+// Returns an error if an object cannot be registered.
+func NewInProcess(objs ...interface{}) (Caller, error) {
+	server := rpc.NewServer()
+	for _, obj := range objs {
+		if err := server.Register(obj); err != nil {
+			return nil, err
+		}
+	}
+	c1, c2 := net.Pipe()
+	go server.ServeConn(c1)
+	return &inProcess{client: rpc.NewClient(c2)}, nil
+}
+
+func (c *inProcess) Call(name string, args interface{}, resp interface{}) error {
+	return c.client.Call(name, args, resp)
+}
+
+func (c *inProcess) CallContext(ctx context.Context, name string, args interface{}, resp interface{}) error {
+	call := c.client.Go(name, args, resp, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
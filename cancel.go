@@ -0,0 +1,221 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// CallID identifies a call started with Go, to be passed to Cancel.
+type CallID uint64
+
+// IDs start at a random number, so that calls from different hosts do not collide.
+func (p *Plugin) nextCallID() CallID {
+	id := CallID(p.callSeq.Add(1))
+	if id == 0 {
+		return p.nextCallID()
+	}
+	return id
+}
+
+// CallContext is like Call, but gives up waiting for initialization or for the response
+// when ctx is done and returns the error of ctx.  A call already sent is then cancelled
+// in the plugin, see Cancelable.  resp is only set if the call succeeds, so an answer
+// arriving after CallContext returned is discarded.
+//
+// Requires the plugin to be built with a version of this package supporting cancellation.
+func (p *Plugin) CallContext(ctx context.Context, name string, args interface{}, resp interface{}) error {
+	return p.callWith(ctx, name, args, resp, CallOpts{id: p.nextCallID()})
+}
+
+// SetMethodTimeouts sets the maximum duration of calls to some methods, by method name
+// (like "Index.Rebuild").  Calls exceeding it return context.DeadlineExceeded and are
+// cancelled in the plugin, where the deadline is also set on the context of the call
+// (see Cancelable).  Waiting for the plugin to be initialized does not count.
+//
+// Calls with a timeout require the plugin to be built with a version of this package
+// supporting cancellation.  Panics if called after Start.
+func (p *Plugin) SetMethodTimeouts(timeouts map[string]time.Duration) {
+	if p.running {
+		panic("Cannot call SetMethodTimeouts after Start")
+	}
+	p.timeouts = make(map[string]time.Duration, len(timeouts))
+	for name, t := range timeouts {
+		if t > 0 {
+			p.timeouts[name] = t
+		}
+	}
+}
+
+// Go invokes the function asynchronously, like Go of the rpc package, and returns the
+// ID of the call to be used with Cancel.  Go waits for the plugin to be initialized;
+// errors happened on initialization are reported via the returned call.
+//
+// Requires the plugin to be built with a version of this package supporting cancellation.
+func (p *Plugin) Go(name string, args interface{}, resp interface{}, done chan *rpc.Call) (CallID, *rpc.Call) {
+	if done == nil {
+		done = make(chan *rpc.Call, 10)
+	} else if cap(done) == 0 {
+		panic("pingo: done channel is unbuffered")
+	}
+	name = p.route(name)
+	call := &rpc.Call{ServiceMethod: name, Args: args, Reply: resp, Done: done}
+	opts := CallOpts{id: p.nextCallID(), timeout: p.timeouts[name]}
+
+	start := time.Now()
+	finish := func(err error) {
+		call.Error = err
+		p.callDone(name, args, resp, start, err)
+		call.Done <- call
+	}
+
+	if err := checkEncodable(name, args, resp); err != nil {
+		finish(err)
+		return opts.id, call
+	}
+	conn, err := p.connect(context.Background())
+	if err == nil {
+		err = p.checkSchema(name, args, resp)
+	}
+	if err == nil {
+		err = p.checkRequestSize(args)
+	}
+	if err == nil {
+		err = p.chaosCall(context.Background(), name)
+	}
+	if err != nil {
+		finish(err)
+		return opts.id, call
+	}
+
+	started := conn.dc.begin()
+	sent := p.clock.Now()
+	c := conn.client.Go(opts.method(name), args, resp, make(chan *rpc.Call, 1))
+	go func() {
+		var expired <-chan time.Time
+		if opts.timeout > 0 {
+			t := p.clock.NewTimer(opts.timeout)
+			defer t.Stop()
+			expired = t.C()
+		}
+		select {
+		case <-c.Done:
+			conn.dc.end(started)
+			// The plugin may answer as soon as it sees the deadline
+			if opts.timeout > 0 && p.clock.Now().Sub(sent) >= opts.timeout {
+				finish(context.DeadlineExceeded)
+				return
+			}
+			finish(p.callFailed(parseCallError(name, wrapIOError(c.Error))))
+		case <-expired:
+			conn.cancel(opts.id)
+			conn.dc.end(started)
+			finish(context.DeadlineExceeded)
+		}
+	}()
+	return opts.id, call
+}
+
+// Cancel asks the plugin to cancel the call with the given ID, if it is still running.
+// The call will return whatever the plugin method returns after observing the
+// cancellation, see Cancelable.
+func (p *Plugin) Cancel(id CallID) error {
+	return p.Call(internalObject+".Cancel", uint64(id), nil)
+}
+
+// Send a cancel for a call without waiting for the result.
+func (c *conn) cancel(id CallID) {
+	c.client.Go(internalObject+".Cancel", uint64(id), nil, make(chan *rpc.Call, 1))
+}
+
+// Cancelable can be embedded in the arguments of a plugin method, so that the method
+// can observe the cancellation of the call by the host:
+//
+//	type Args struct {
+//		pingo.Cancelable
+//		Query string
+//	}
+//
+//	func (o *Obj) Search(args Args, resp *[]string) error {
+//		ctx := args.Context()
+//		...
+//	}
+//
+// Cancelable is not transmitted, the host may or may not include it in its arguments.
+type Cancelable func() context.Context
+
+// Context returns a context done when the host cancels the call, or when the call
+// has returned.  Calls not cancelable by the host get a context never done.
+func (c Cancelable) Context() context.Context {
+	if c == nil {
+		return context.Background()
+	}
+	return c()
+}
+
+func (c *Cancelable) setContext(ctx context.Context) {
+	*c = func() context.Context { return ctx }
+}
+
+// Calls running in the plugin that can be cancelled by the host, by client and ID.
+var cancelable = struct {
+	mux   sync.Mutex
+	calls map[clientCall]context.CancelFunc
+}{calls: make(map[clientCall]context.CancelFunc)}
+
+// Give body a context for the request being read, if the host can cancel it.
+func (c *serverCodec) bindContext(body interface{}) {
+	b, ok := body.(interface{ setContext(context.Context) })
+	if !ok || c.params.id == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if c.params.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), c.params.timeout)
+	}
+	b.setContext(ctx)
+
+	cancelable.mux.Lock()
+	cancelable.calls[clientCall{client: c.client, id: c.params.id}] = cancel
+	cancelable.mux.Unlock()
+
+	c.pendingMux.Lock()
+	if c.pending == nil {
+		c.pending = make(map[uint64]CallID)
+	}
+	c.pending[c.seq] = c.params.id
+	c.pendingMux.Unlock()
+}
+
+// Release the context of the request with seq, once answered.
+func (c *serverCodec) releaseContext(seq uint64) {
+	c.pendingMux.Lock()
+	id, ok := c.pending[seq]
+	delete(c.pending, seq)
+	c.pendingMux.Unlock()
+	if ok {
+		cancelCall(clientCall{client: c.client, id: id})
+	}
+}
+
+// Cancel a call, only if it was made by the same client.
+func cancelCall(id clientCall) {
+	cancelable.mux.Lock()
+	cancel, ok := cancelable.calls[id]
+	delete(cancelable.calls, id)
+	cancelable.mux.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// Internal RPC call to cancel a running call. Do not call manually.
+func (s *PingoRpc) Cancel(id uint64, unused *int) error {
+	cancelCall(clientCall{client: s.client, id: CallID(id)})
+	return nil
+}
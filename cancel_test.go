@@ -0,0 +1,33 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"testing"
+)
+
+func TestCancelPerClient(t *testing.T) {
+	const id = CallID(42)
+
+	// The same ID from two clients
+	bind := func(client string) (*serverCodec, *struct{ Cancelable }) {
+		c := &serverCodec{client: client, seq: 1, params: methodParams{id: id}}
+		args := &struct{ Cancelable }{}
+		c.bindContext(args)
+		return c, args
+	}
+	ca, a := bind("a")
+	cb, b := bind("b")
+	defer ca.releaseContext(1)
+	defer cb.releaseContext(1)
+
+	(&PingoRpc{client: "b"}).Cancel(uint64(id), nil)
+	if err := a.Context().Err(); err != nil {
+		t.Fatalf("call of a cancelled by b: %v", err)
+	}
+	if b.Context().Err() == nil {
+		t.Fatal("call of b not cancelled")
+	}
+}
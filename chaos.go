@@ -0,0 +1,91 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// ChaosConfig sets the faults injected in a plugin, see SetChaos.  Rates are fractions
+// between 0 and 1.
+type ChaosConfig struct {
+	// Rate of calls failing with ErrChaos without being sent
+	CallErrorRate float64
+	// Calls are delayed by a random duration up to this before being sent
+	LatencyJitter time.Duration
+	// Rate of calls after which the plugin process is killed, as if it crashed
+	RandomKill float64
+	// Rate of starts failing the handshake with ErrChaos
+	HandshakeFailRate float64
+}
+
+// SetChaos makes the host inject faults in the use of the plugin, to verify how it copes
+// with plugins failing to start, slow calls and crashes in staging, without building
+// broken plugins.  Only calls to the objects of the plugin are affected.  Never set it in
+// production.
+//
+// Panics if called after Start.
+func (p *Plugin) SetChaos(cfg ChaosConfig) {
+	if p.running {
+		panic("Cannot call SetChaos after Start")
+	}
+	p.chaos = &cfg
+}
+
+// Return true with probability rate.
+func chance(rate float64) bool {
+	return rate > 0 && float64(randUint64()>>11)/(1<<53) < rate
+}
+
+// Delay or fail a call to method before it is sent.
+func (p *Plugin) chaosCall(ctx context.Context, method string) error {
+	if p.chaos == nil {
+		return nil
+	}
+	if obj, _, _ := strings.Cut(method, "."); isInternalObject(obj) {
+		return nil
+	}
+	if p.chaos.LatencyJitter > 0 {
+		t := time.NewTimer(time.Duration(randUint64() % uint64(p.chaos.LatencyJitter)))
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if chance(p.chaos.CallErrorRate) {
+		return ErrChaos(errors.New("Injected failure of call to " + method))
+	}
+	return nil
+}
+
+// Kill the plugin process after a call to method, if chosen to.
+func (p *Plugin) chaosKill(method string) {
+	if p.chaos == nil || !chance(p.chaos.RandomKill) {
+		return
+	}
+	if obj, _, _ := strings.Cut(method, "."); isInternalObject(obj) {
+		return
+	}
+	s := &signalReq{sig: os.Kill, wr: newWaiter()}
+	select {
+	case p.sigCh <- s:
+		s.wr.wait()
+	case <-p.done:
+	}
+}
+
+// Fail the handshake of the plugin, if chosen to.
+func (c *ctrl) chaosHandshake() error {
+	if c.p.chaos == nil || !chance(c.p.chaos.HandshakeFailRate) {
+		return nil
+	}
+	return ErrChaos(errors.New("Injected handshake failure"))
+}
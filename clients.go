@@ -0,0 +1,130 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Host connecting to the plugin with its own token, see AddClient.
+type hostClient struct {
+	name  string
+	token string
+	// Serves the connections of the client, created once all objects are registered
+	server     *rpc.Server
+	serverOnce sync.Once
+	// Frees the state of the client when its lease expires
+	leaseTimer *time.Timer
+}
+
+// AddClient lets the host called name connect to the plugin with token, besides the host
+// that started the plugin or that uses the token of a persistent plugin.  Adding clients
+// makes a single plugin process serve many hosts, for example to share a resource that
+// is expensive to load; the hosts connect with Attach, using the address of the plugin
+// and their token.
+//
+// Calls are isolated between clients: the sessions and transactions opened by a client
+// cannot be used by other clients, a client can only cancel its own calls, and calls run
+// once (see AtLeastOnce) are only answered with results of the same client.  Exit calls
+// from clients are ignored, and when the lease of a client expires (see SetLease) its
+// sessions are closed and its transactions rolled back, instead of the plugin exiting.
+// Methods can tell clients apart with InClient.
+//
+// Requests acting on the whole plugin process are not isolated: signals forwarded by a
+// host, Reload, the release of WaitHostReady and profiling are honored from any client.
+//
+// AddClient will panic if called after Run, if name or token are empty, or if either
+// has already been added.
+func AddClient(name, token string) {
+	r := defaultServer
+	if r.running {
+		panic("Do not call AddClient after Run")
+	}
+	if name == "" || token == "" {
+		panic("AddClient requires a name and a token")
+	}
+	if r.clients == nil {
+		r.clients = make(map[string]*hostClient)
+	}
+	for _, cl := range r.clients {
+		if cl.name == name || cl.token == token {
+			panic("Client " + name + " or its token already added")
+		}
+	}
+	r.clients[name] = &hostClient{name: name, token: token}
+}
+
+// New RPC server with the internal objects, serving the client with name.
+func newInternalServer(name string) *rpc.Server {
+	s := rpc.NewServer()
+	// Internal objects are not reported to the host.  The unversioned names
+	// are still served to hosts built with older versions of this package.
+	s.RegisterName(internalObject, &PingoRpc{client: name})
+	s.Register(&PingoRpc{client: name})
+	s.Register(&PingoHealth{})
+	return s
+}
+
+// Server for the connections of cl, serving the same objects as the main server.
+func (r *rpcServer) clientServer(cl *hostClient) *rpc.Server {
+	cl.serverOnce.Do(func() {
+		cl.server = newInternalServer(cl.name)
+		for _, name := range r.objs {
+			cl.server.Register(r.impls[name])
+		}
+	})
+	return cl.server
+}
+
+// Hold the lease of the client with name for d, freeing its state when it expires.
+func (r *rpcServer) renewClientLease(name string, d time.Duration) {
+	cl := r.clients[name]
+
+	r.leaseMux.Lock()
+	defer r.leaseMux.Unlock()
+
+	if cl.leaseTimer != nil {
+		cl.leaseTimer.Reset(d)
+		return
+	}
+	cl.leaseTimer = time.AfterFunc(d, func() {
+		r.leaseMux.Lock()
+		cl.leaseTimer = nil
+		r.leaseMux.Unlock()
+
+		meta(r.conf.prefix).output("error", fmt.Sprintf("Lease of client %s expired, closing its sessions and transactions", name))
+		closeClientSessions(name)
+		rollbackClientTransactions(name)
+	})
+}
+
+// InClient can be embedded in the arguments of a plugin method, so that the method gets
+// the client calling it, see AddClient.  InClient is not transmitted, the host may or may
+// not include it in its arguments.
+type InClient func() string
+
+// Name returns the name of the client of the call, as passed to AddClient.  Calls from
+// the host that started the plugin, or that uses the token of a persistent plugin, have
+// an empty name.
+func (c InClient) Name() string {
+	if c == nil {
+		return ""
+	}
+	return c()
+}
+
+func (c *InClient) setClient(name string) {
+	*c = func() string { return name }
+}
+
+// Give body the client of the connection.
+func (c *serverCodec) bindClient(body interface{}) {
+	if b, ok := body.(interface{ setClient(string) }); ok {
+		b.setClient(c.client)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Object served in the test process to hosts attached with AttachConn.
+type ClientTest struct {
+	mux   sync.Mutex
+	count int
+}
+
+type ClientTestArgs struct {
+	Cancelable
+	N int
+}
+
+// Wait returns N once the call is cancelled.
+func (o *ClientTest) Wait(args ClientTestArgs, resp *int) error {
+	<-args.Context().Done()
+	*resp = args.N
+	return nil
+}
+
+// Count returns the number of times it has run.
+func (o *ClientTest) Count(unused int, resp *int) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.count++
+	*resp = o.count
+	return nil
+}
+
+const (
+	clientTestToken      = "client-test-main"
+	clientTestOtherToken = "client-test-other"
+)
+
+var clientTestOnce sync.Once
+
+// Attach a host with token to the objects served in the test process.
+func attachClientTest(t *testing.T, token string) *Plugin {
+	clientTestOnce.Do(func() {
+		Register(&ClientTest{})
+		AddClient("other", clientTestOtherToken)
+	})
+	hostConn, pluginConn := net.Pipe()
+	go ServeConn(pluginConn, clientTestToken)
+	p, err := AttachConn(hostConn, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	// Both hosts number their calls the same way
+	p.callSeq.Store(1000)
+	return p
+}
+
+func TestClientsIsolated(t *testing.T) {
+	main := attachClientTest(t, clientTestToken)
+	other := attachClientTest(t, clientTestOtherToken)
+
+	t.Run("AtLeastOnce", func(t *testing.T) {
+		var first, second int
+		if err := main.CallWithOpts("ClientTest.Count", 0, &first, CallOpts{Semantics: AtLeastOnce}); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.CallWithOpts("ClientTest.Count", 0, &second, CallOpts{Semantics: AtLeastOnce}); err != nil {
+			t.Fatal(err)
+		}
+		if second != first+1 {
+			t.Fatalf("call of other client answered with %d, after %d", second, first)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		var mainResp, otherResp int
+		mainID, mainCall := main.Go("ClientTest.Wait", ClientTestArgs{N: 1}, &mainResp, nil)
+		otherID, otherCall := other.Go("ClientTest.Wait", ClientTestArgs{N: 2}, &otherResp, nil)
+		if mainID != otherID {
+			t.Fatalf("calls with different IDs %d and %d", mainID, otherID)
+		}
+		// Let both calls start
+		time.Sleep(100 * time.Millisecond)
+
+		if err := other.Cancel(otherID); err != nil {
+			t.Fatal(err)
+		}
+		waitCall(t, otherCall)
+		select {
+		case <-mainCall.Done:
+			t.Fatal("call of the main client cancelled by the other client")
+		case <-time.After(200 * time.Millisecond):
+		}
+		if err := main.Cancel(mainID); err != nil {
+			t.Fatal(err)
+		}
+		waitCall(t, mainCall)
+		if mainResp != 1 || otherResp != 2 {
+			t.Fatalf("unexpected responses %d and %d", mainResp, otherResp)
+		}
+	})
+}
+
+func waitCall(t *testing.T, call *rpc.Call) {
+	t.Helper()
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			t.Fatal(call.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not cancelled")
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and makes the timers used by a plugin for its registration and
+// exit timeouts, call timeouts and retries, keepalive and lease renewals.  Tests can set
+// a ManualClock with SetClock to trigger timeouts without waiting for them.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a timer sending the time on its channel once, after d
+	NewTimer(d time.Duration) Timer
+	// NewTicker returns a ticker sending the time on its channel every d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a timer made by a Clock, like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a ticker made by a Clock, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Clock of the time package.
+type realClock struct{}
+
+type realTimer struct{ *time.Timer }
+
+type realTicker struct{ *time.Ticker }
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// SetClock sets the clock of the plugin.  By default, the time package is used.
+//
+// Panics if called after Start.
+func (p *Plugin) SetClock(c Clock) {
+	if p.running {
+		panic("Cannot call SetClock after Start")
+	}
+	p.clock = c
+	if p.output != nil {
+		p.output.setClock(c)
+	}
+}
+
+// ManualClock is a Clock whose time only changes when Advance is called.  Its timers and
+// tickers fire as the time passes them.
+type ManualClock struct {
+	mux    sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManualClock returns a ManualClock set to now.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Timer or ticker of a ManualClock.
+type manualTimer struct {
+	clock *ManualClock
+	ch    chan time.Time
+	when  time.Time
+	// Interval of tickers, zero for timers
+	period time.Duration
+	active bool
+}
+
+type manualTicker struct{ *manualTimer }
+
+// Now returns the current time of the clock.
+func (c *ManualClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer firing when the clock is advanced by d or more.
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, 0)
+}
+
+// NewTicker returns a ticker firing each time the clock is advanced past another d.
+// Panics if d is not positive.
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("Non-positive interval for NewTicker")
+	}
+	return manualTicker{c.add(d, d)}
+}
+
+func (c *ManualClock) add(d, period time.Duration) *manualTimer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	t := &manualTimer{clock: c, ch: make(chan time.Time, 1), period: period}
+	c.schedule(t, d)
+	return t
+}
+
+// Make t fire after d.  Must be called with c.mux held.
+func (c *ManualClock) schedule(t *manualTimer, d time.Duration) {
+	t.when = c.now.Add(d)
+	if !t.active {
+		t.active = true
+		c.timers = append(c.timers, t)
+	}
+}
+
+// Advance moves the time of the clock forward by d, firing the timers and tickers due
+// in order.  Like those of the time package, a ticker drops ticks that are not read.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		var next *manualTimer
+		for _, t := range c.timers {
+			if !t.when.After(end) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		c.now = next.when
+		select {
+		case next.ch <- c.now:
+		default:
+		}
+		if next.period > 0 {
+			next.when = next.when.Add(next.period)
+		} else {
+			next.stop()
+		}
+	}
+	c.now = end
+}
+
+// Remove t from the active timers.  Must be called with the clock mutex held.
+func (t *manualTimer) stop() bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	timers := t.clock.timers
+	for i := range timers {
+		if timers[i] == t {
+			t.clock.timers = append(timers[:i], timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	return t.stop()
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	active := t.active
+	t.clock.schedule(t, d)
+	return active
+}
+
+func (t manualTicker) Stop() {
+	t.manualTimer.Stop()
+}
@@ -0,0 +1,24 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import "os/exec"
+
+// SetCmdModifier sets a function called with the command of the plugin process just
+// before it is started, after all other configuration has been applied.  Use it to set
+// fields of exec.Cmd that have no option in this package, like Stdin, SysProcAttr or
+// additional ExtraFiles.
+//
+// The function must not replace Stdout, Stderr or the ExtraFiles already set, which are
+// used to talk to the plugin.  ExtraFiles it adds are not closed by the host.  It is
+// also called for the command run by SelfCheck.
+//
+// Panics if called after Start.
+func (p *Plugin) SetCmdModifier(fn func(*exec.Cmd)) {
+	if p.running {
+		panic("Cannot call SetCmdModifier after Start")
+	}
+	p.cmdMod = fn
+}
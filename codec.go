@@ -0,0 +1,328 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+// Gob codec for the plugin side, the same as the one in net/rpc but allowing
+// to inspect requests before they are dispatched.
+type serverCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+	// Method, sequence number and parameters of the request being read
+	method      string
+	seq         uint64
+	params      methodParams
+	maxResponse int
+	// Requests with a context, by sequence number
+	pending    map[uint64]CallID
+	pendingMux sync.Mutex
+	// Requests run once for all retries, by sequence number
+	onces map[uint64]onceRequest
+	// Sessions of requests, by sequence number
+	sessions map[uint64]SessionID
+	// Name of the client of the connection, see AddClient
+	client string
+	// Set if the request being read is a retry of one already run
+	replay bool
+	// Set if requests can be decoded by the fast path
+	br *bufio.Reader
+	// Set if the host asked for compressed responses
+	compress   *compression
+	maxRequest int
+}
+
+func newServerCodec(conn *bufReadWriteCloser, maxRequest, maxResponse int) *serverCodec {
+	buf := bufio.NewWriter(conn)
+	c := &serverCodec{
+		rwc:         conn,
+		dec:         gob.NewDecoder(newLimitReader(conn, maxRequest)),
+		enc:         gob.NewEncoder(buf),
+		encBuf:      buf,
+		maxResponse: maxResponse,
+		maxRequest:  maxRequest,
+	}
+	// The decoder reads directly from conn, without buffering ahead
+	if maxRequest <= 0 {
+		c.br = conn.Reader
+	}
+	return c
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	r.ServiceMethod, c.params = parseMethod(r.ServiceMethod)
+	c.method = r.ServiceMethod
+	c.seq = r.Seq
+	if c.params.once && c.params.id != 0 {
+		c.deduplicate(r)
+	}
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	if c.replay {
+		return c.readReplay(body)
+	}
+	if err := c.decodeBody(body); err != nil {
+		if typ, ok := unregisteredType(err); ok {
+			return rpc.ServerError(errorCodeUnregisteredType + ": " + c.method + ": " + typ)
+		}
+		return err
+	}
+	// A nil body means the request is being discarded
+	if body == nil {
+		return nil
+	}
+	if err := validate(c.method, body); err != nil {
+		return err
+	}
+	if err := c.bindSession(body); err != nil {
+		return err
+	}
+	if err := c.bindTransaction(body); err != nil {
+		return err
+	}
+	c.bindClient(body)
+	c.bindContext(body)
+	return nil
+}
+
+func (c *serverCodec) decodeBody(body interface{}) error {
+	if c.params.compressor != "" && body != nil {
+		var data []byte
+		if err := c.decodeMessage(&data); err != nil {
+			return err
+		}
+		return decodeCompressed(c.params.compressor, data, body, c.maxRequest)
+	}
+	return c.decodeMessage(body)
+}
+
+func (c *serverCodec) decodeMessage(body interface{}) error {
+	if c.br != nil {
+		if ok, err := readFastMessage(c.br, body); ok {
+			return err
+		}
+	}
+	return c.dec.Decode(body)
+}
+
+func (c *serverCodec) encodeBody(body interface{}) error {
+	if c.maxResponse <= 0 {
+		if ok, err := writeFastMessage(c.encBuf, body); ok {
+			return err
+		}
+	}
+	return c.enc.Encode(body)
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	c.releaseContext(r.Seq)
+	c.releaseSession(r.Seq)
+	body = c.completeOnce(r, body)
+	// Report values that cannot be encoded before anything is written
+	if r.Error == "" {
+		if typ, ok := unregisteredValue(body); ok {
+			r.Error = errorCodeUnregisteredType + ": " + r.ServiceMethod + ": " + typ
+			body = struct{}{}
+		}
+	}
+	if c.maxResponse > 0 && r.Error == "" {
+		if n, err := encodedSize(body); err == nil && n > c.maxResponse {
+			r.Error = fmt.Sprintf("%s: Response of %d bytes exceeds limit of %d", errorCodeMessageTooLarge, n, c.maxResponse)
+			body = struct{}{}
+		}
+	}
+	if c.compress != nil && r.Error == "" {
+		if data, ok, err := c.compress.encode(body); err == nil && ok {
+			r.ServiceMethod = c.compress.method(r.ServiceMethod)
+			body = data
+		}
+	}
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// Gob couldn't encode the header. Should not happen, so if it does,
+			// shut down the connection to signal that the connection is broken.
+			c.Close()
+		}
+		return
+	}
+	if err = c.encodeBody(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			// Was a gob problem encoding the body but the header has been written.
+			// Shut down the connection to signal that the connection is broken.
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		// Only call c.rwc.Close once; otherwise the semantics are undefined.
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// Gob codec for the host side, the same as the one in net/rpc but limiting
+// the size of responses and measuring the size of messages.
+type clientCodec struct {
+	rwc    *countConn
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	// Set if responses can be decoded by the fast path
+	br    *bufio.Reader
+	stats *callStats
+	// Method of the response being read and bytes read before it
+	method string
+	offset int64
+	// Compressor of the response being read, if compressed
+	compressed  string
+	maxResponse int
+	// Set if requests are compressed
+	compress *compression
+}
+
+func newClientCodec(conn io.ReadWriteCloser, maxResponse int, stats *callStats) *clientCodec {
+	cc := &countConn{ReadWriteCloser: conn}
+	buf := bufio.NewWriter(cc)
+	c := &clientCodec{
+		rwc:         cc,
+		enc:         gob.NewEncoder(buf),
+		encBuf:      buf,
+		stats:       stats,
+		maxResponse: maxResponse,
+	}
+	if maxResponse > 0 {
+		// Never reads past the current message
+		c.dec = gob.NewDecoder(newLimitReader(cc, maxResponse))
+	} else {
+		// The decoder reads directly from br, without buffering ahead
+		c.br = bufio.NewReader(cc)
+		c.dec = gob.NewDecoder(c.br)
+	}
+	return c
+}
+
+// Bytes of the responses read so far.
+func (c *clientCodec) consumed() int64 {
+	if c.br != nil {
+		return c.rwc.read - int64(c.br.Buffered())
+	}
+	return c.rwc.read
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) (err error) {
+	// Requests are flushed as they are written
+	offset := c.rwc.written
+	defer func() {
+		if err == nil {
+			c.stats.recordRequest(r.ServiceMethod, c.rwc.written-offset)
+		}
+	}()
+	if typ, ok := unregisteredValue(body); ok {
+		name, _, _ := strings.Cut(r.ServiceMethod, ";")
+		return &UnregisteredTypeError{Method: name, Type: typ}
+	}
+	if c.compress != nil {
+		if data, ok, err := c.compress.encode(body); err == nil && ok {
+			req := *r
+			req.ServiceMethod = c.compress.method(r.ServiceMethod)
+			r, body = &req, data
+		}
+	}
+	if err = c.enc.Encode(r); err != nil {
+		return
+	}
+	if ok, err := writeFastMessage(c.encBuf, body); ok {
+		if err != nil {
+			return err
+		}
+		return c.encBuf.Flush()
+	}
+	if err = c.enc.Encode(body); err != nil {
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	c.offset = c.consumed()
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	var params methodParams
+	r.ServiceMethod, params = parseMethod(r.ServiceMethod)
+	c.method = r.ServiceMethod
+	c.compressed = params.compressor
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) (err error) {
+	defer func() {
+		if err == nil {
+			c.stats.recordResponse(c.method, c.consumed()-c.offset)
+		}
+	}()
+	if c.compressed != "" && body != nil {
+		var data []byte
+		if err := c.decodeMessage(&data); err != nil {
+			return err
+		}
+		return decodeCompressed(c.compressed, data, body, c.maxResponse)
+	}
+	return c.decodeMessage(body)
+}
+
+func (c *clientCodec) decodeMessage(body interface{}) error {
+	if c.br != nil {
+		if ok, err := readFastMessage(c.br, body); ok {
+			return err
+		}
+	}
+	return c.dec.Decode(body)
+}
+
+func (c *clientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+// Connection counting the bytes read and written.  Reads and writes each
+// happen from a single goroutine at a time.
+type countConn struct {
+	io.ReadWriteCloser
+	read    int64
+	written int64
+}
+
+func (c *countConn) Read(data []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(data)
+	c.read += int64(n)
+	return n, err
+}
+
+func (c *countConn) Write(data []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(data)
+	c.written += int64(n)
+	return n, err
+}
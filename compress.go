@@ -0,0 +1,177 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Compressed bodies are sent as a byte slice holding a compressed gob stream with the
+// body, marked by the "compress" parameter of the method name.  The host asks the
+// plugin to compress responses with the Compress-Responses connection header, carrying
+// the name of the compressor and the minimum size of compressed bodies.
+
+const (
+	compressParam  = "compress"
+	compressHeader = "Compress-Responses"
+)
+
+// Compressor compresses the messages exchanged by host and plugin.  A compressor must
+// be registered under the same name in the host and in the plugin.
+type Compressor interface {
+	Compress(w io.Writer) io.WriteCloser
+	Decompress(r io.Reader) (io.ReadCloser, error)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+func (gzipCompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+var compressors = struct {
+	mux    sync.Mutex
+	byName map[string]Compressor
+}{byName: map[string]Compressor{"gzip": gzipCompressor{}}}
+
+// RegisterCompressor makes c available under name, both to hosts with SetCompression
+// and to plugins.  The "gzip" compressor is always available.  The name can only
+// contain letters, digits, "-" and "_".
+func RegisterCompressor(name string, c Compressor) {
+	if !isFieldName(name) {
+		panic("Invalid compressor name " + strconv.Quote(name))
+	}
+	compressors.mux.Lock()
+	defer compressors.mux.Unlock()
+	compressors.byName[name] = c
+}
+
+func lookupCompressor(name string) Compressor {
+	compressors.mux.Lock()
+	defer compressors.mux.Unlock()
+	return compressors.byName[name]
+}
+
+// Compression sets which messages between host and plugin are compressed.
+type Compression struct {
+	// Name of a registered compressor, "gzip" if empty
+	Compressor string
+	// Compress requests sent to the plugin
+	Requests bool
+	// Compress responses sent by the plugin
+	Responses bool
+	// Only compress bodies of at least this size in bytes, once encoded
+	MinSize int
+}
+
+// SetCompression compresses the bodies of requests, responses or both, for example
+// only the responses of plugins returning large results for small requests.  Bodies
+// smaller than MinSize or that do not get smaller are sent as they are.
+//
+// Compressing requests requires the plugin to be built with a version of this package
+// supporting compression; older plugins send their responses uncompressed.
+//
+// Panics if called after Start or if the compressor is not registered.
+func (p *Plugin) SetCompression(c Compression) {
+	if p.running {
+		panic("Cannot call SetCompression after Start")
+	}
+	if c.Compressor == "" {
+		c.Compressor = "gzip"
+	}
+	if lookupCompressor(c.Compressor) == nil {
+		panic("Unknown compressor " + strconv.Quote(c.Compressor))
+	}
+	p.compress = &c
+}
+
+// Connection headers asking the plugin to compress its responses.
+func (c *Compression) headers() []string {
+	if c == nil || !c.Responses {
+		return nil
+	}
+	return []string{compressHeader + ": " + c.Compressor + " " + strconv.Itoa(c.MinSize)}
+}
+
+// Compression of the messages sent in one direction.
+type compression struct {
+	name string
+	c    Compressor
+	min  int
+}
+
+func newCompression(name string, min int) *compression {
+	c := lookupCompressor(name)
+	if c == nil {
+		return nil
+	}
+	return &compression{name: name, c: c, min: min}
+}
+
+// Parse the value of the header sent by the host, nil if not usable.
+func parseCompression(val string) *compression {
+	name, size, _ := strings.Cut(val, " ")
+	min, err := strconv.Atoi(size)
+	if err != nil {
+		return nil
+	}
+	return newCompression(name, min)
+}
+
+// Append the parameter marking a compressed body to a method name.
+func (c *compression) method(name string) string {
+	return name + ";" + compressParam + "=" + c.name
+}
+
+// Return body compressed, or false if it is not worth compressing.
+func (c *compression) encode(body interface{}) ([]byte, bool, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := gob.NewEncoder(buf).Encode(body); err != nil {
+		return nil, false, err
+	}
+	if buf.Len() < c.min {
+		return nil, false, nil
+	}
+	out := new(bytes.Buffer)
+	w := c.c.Compress(out)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return nil, false, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, false, err
+	}
+	if out.Len() >= buf.Len() {
+		return nil, false, nil
+	}
+	return out.Bytes(), true, nil
+}
+
+// Decode body from data compressed with the compressor called name.  Decompressed
+// messages larger than max are rejected, unless max is zero.
+func decodeCompressed(name string, data []byte, body interface{}, max int) error {
+	c := lookupCompressor(name)
+	if c == nil {
+		return ErrInvalidMessage(fmt.Errorf("Unknown compressor %q", name))
+	}
+	r, err := c.Decompress(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return gob.NewDecoder(newLimitReader(r, max)).Decode(body)
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+)
+
+// Streams of output of a plugin process.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// StreamPrinter is implemented by error handlers that get each line of output of the
+// plugin with the stream it was printed on, StreamStdout or StreamStderr, in place of
+// calls to Print.
+type StreamPrinter interface {
+	PrintStream(stream, line string)
+}
+
+// Pass a line of output printed on stream to the ErrorHandler.
+func (p *Plugin) printOutput(line, stream string) {
+	raw := line
+	line = p.forwardedLine(line)
+	switch h := p.errorHandler().(type) {
+	case *contextHandler:
+		ctx := p.handlerContext()
+		ctx.Stream = stream
+		ctx.Line = raw
+		h.h.PrintContext(ctx, line)
+	case StreamPrinter:
+		h.PrintStream(stream, line)
+	default:
+		h.Print(line)
+	}
+}
+
+// Colors of plugin names, as ANSI codes
+var consoleColors = [...]int{36, 32, 33, 35, 34, 31}
+
+// ConsoleHandler is an ErrorHandler for hosts printing the output of several plugins
+// on a console.  Each line is prefixed with the name of the plugin and the stream it
+// was printed on, like "[resize:stderr]"; errors are prefixed with "[resize:error]".
+type ConsoleHandler struct {
+	w     io.Writer
+	name  string
+	color int
+}
+
+// NewConsoleHandler returns a ConsoleHandler writing the output of the plugin called
+// name to w, for example os.Stderr.  If color is true, the prefix is colored the same
+// for all lines of a plugin, with a different color for each name if possible.
+func NewConsoleHandler(w io.Writer, name string, color bool) *ConsoleHandler {
+	h := &ConsoleHandler{w: w, name: name}
+	if color {
+		sum := fnv.New32a()
+		io.WriteString(sum, name)
+		h.color = consoleColors[sum.Sum32()%uint32(len(consoleColors))]
+	}
+	return h
+}
+
+func (h *ConsoleHandler) write(tag, line string) {
+	if h.color != 0 {
+		fmt.Fprintf(h.w, "\x1b[%dm[%s:%s]\x1b[0m %s\n", h.color, h.name, tag, line)
+		return
+	}
+	fmt.Fprintf(h.w, "[%s:%s] %s\n", h.name, tag, line)
+}
+
+// Error writes err, tagged as error.
+func (h *ConsoleHandler) Error(err error) {
+	h.write("error", err.Error())
+}
+
+// Print writes output whose stream is unknown, tagged as output.
+func (h *ConsoleHandler) Print(v interface{}) {
+	h.write("output", fmt.Sprint(v))
+}
+
+// PrintStream writes a line of output, tagged with its stream.
+func (h *ConsoleHandler) PrintStream(stream, line string) {
+	h.write(stream, line)
+}
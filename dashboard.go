@@ -0,0 +1,156 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Dashboard returns an HTTP handler serving a web page to watch the plugins of the
+// manager during development: a table of their state and statistics, refreshed every
+// few seconds, with buttons to start, stop and restart them, and the live output of the
+// selected plugin.  The endpoints of Handler are served under "api/", which the page
+// uses.
+//
+// Like Handler, the dashboard does no authentication.  Mount it under a path ending with
+// a slash, for example:
+//
+//	mux.Handle("/debug/pingo/", http.StripPrefix("/debug/pingo", m.Dashboard()))
+func (m *Manager) Dashboard() http.Handler {
+	api := http.StripPrefix("/api", m.Handler())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := strings.TrimPrefix(r.URL.Path, "/"); {
+		case path == "api" || strings.HasPrefix(path, "api/"):
+			api.ServeHTTP(w, r)
+		case path == "":
+			if allowMethod(w, r, http.MethodGet) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				io.WriteString(w, dashboardPage)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pingo plugins</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+tr.selected { background: #eef; }
+td.name { cursor: pointer; text-decoration: underline; }
+.failed { color: #b00; }
+#error { color: #b00; }
+#output { background: #111; color: #ddd; padding: 0.5em; height: 25em; overflow: auto; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Plugins</h1>
+<p id="error"></p>
+<table>
+<thead><tr><th>Name</th><th>State</th><th>Calls</th><th>Errors</th><th>Time in calls</th><th></th></tr></thead>
+<tbody id="plugins"></tbody>
+</table>
+<h2 id="title">Output</h2>
+<div id="output"></div>
+<script>
+var selected = null, source = null;
+
+function api(path, method) {
+	return fetch("api/" + path, {method: method || "GET"}).then(function(resp) {
+		return resp.json().then(function(body) {
+			if (!resp.ok) {
+				throw new Error(body.Error || resp.statusText);
+			}
+			return body;
+		});
+	});
+}
+
+function showError(err) {
+	document.getElementById("error").textContent = err ? err.message : "";
+}
+
+function action(name, act) {
+	api(encodeURIComponent(name) + "/" + act, "POST").then(function() {
+		showError(null);
+		refresh();
+		if (name === selected) {
+			tail(name);
+		}
+	}, showError);
+}
+
+function cell(row, text, cls) {
+	var td = document.createElement("td");
+	td.textContent = text;
+	if (cls) {
+		td.className = cls;
+	}
+	row.appendChild(td);
+	return td;
+}
+
+function button(td, name, act) {
+	var b = document.createElement("button");
+	b.textContent = act;
+	b.onclick = function() { action(name, act); };
+	td.appendChild(b);
+}
+
+function refresh() {
+	api("").then(function(list) {
+		var body = document.getElementById("plugins");
+		body.textContent = "";
+		list.forEach(function(p) {
+			var row = document.createElement("tr");
+			if (p.Name === selected) {
+				row.className = "selected";
+			}
+			cell(row, p.Name, "name").onclick = function() { tail(p.Name); refresh(); };
+			cell(row, p.Stats.State, p.Stats.State === "failed" ? "failed" : "");
+			cell(row, p.Stats.Calls);
+			cell(row, p.Stats.Errors);
+			cell(row, (p.Stats.Duration / 1e6).toFixed(1) + " ms");
+			var td = cell(row, "");
+			["start", "stop", "restart"].forEach(function(act) { button(td, p.Name, act); });
+			body.appendChild(row);
+		});
+	}, showError);
+}
+
+function tail(name) {
+	if (source) {
+		source.close();
+	}
+	selected = name;
+	document.getElementById("title").textContent = "Output of " + name;
+	var out = document.getElementById("output");
+	out.textContent = "";
+	source = new EventSource("api/" + encodeURIComponent(name) + "/output");
+	source.onopen = function() { out.textContent = ""; };
+	source.addEventListener("stopped", function() { this.close(); });
+	source.onmessage = function(e) {
+		var follow = out.scrollTop + out.clientHeight >= out.scrollHeight - 5;
+		out.appendChild(document.createTextNode(e.data + "\n"));
+		if (follow) {
+			out.scrollTop = out.scrollHeight;
+		}
+	};
+}
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,144 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Connection applying read and write deadlines.
+//
+// On the host side, the read deadline only applies while calls are pending:
+// the plugin must keep sending data for them.  On the plugin side, the read
+// deadline applies to every read once the connection is active: the host must
+// keep sending requests.
+type deadlineConn struct {
+	net.Conn
+	idle, write time.Duration
+	// Host side: deadline only applies to pending calls
+	perCall bool
+	mux     sync.Mutex
+	pending int
+	// Number of calls being performed by start time, in nanoseconds
+	started map[int64]int
+	active  bool
+	// Closed when a read fails: the RPC client or server stops using the connection
+	broken    chan struct{}
+	breakOnce sync.Once
+	breakErr  error
+}
+
+func newDeadlineConn(conn net.Conn, idle, write time.Duration, perCall bool) *deadlineConn {
+	return &deadlineConn{Conn: conn, idle: idle, write: write, perCall: perCall, broken: make(chan struct{})}
+}
+
+// Start applying the idle deadline on the plugin side.
+func (d *deadlineConn) activate() {
+	d.mux.Lock()
+	d.active = true
+	d.mux.Unlock()
+}
+
+// A call is being performed.  Returns the start time to pass to end.
+func (d *deadlineConn) begin() int64 {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	start := time.Now().UnixNano()
+	if d.started == nil {
+		d.started = make(map[int64]int)
+	}
+	d.started[start]++
+	d.pending++
+	if d.pending == 1 && d.idle > 0 {
+		d.Conn.SetReadDeadline(time.Now().Add(d.idle))
+	}
+	return start
+}
+
+// The call started at start has been completed.
+func (d *deadlineConn) end(start int64) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if d.started[start]--; d.started[start] == 0 {
+		delete(d.started, start)
+	}
+	d.pending--
+	if d.pending == 0 && d.idle > 0 {
+		d.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// Number of calls being performed.
+func (d *deadlineConn) calls() int {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.pending
+}
+
+// Time the oldest call being performed has been running for, zero if none.
+func (d *deadlineConn) longest() time.Duration {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	var oldest int64
+	for start := range d.started {
+		if oldest == 0 || start < oldest {
+			oldest = start
+		}
+	}
+	if oldest == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, oldest))
+}
+
+func (d *deadlineConn) Read(b []byte) (int, error) {
+	if d.idle > 0 && !d.perCall {
+		d.mux.Lock()
+		if d.active {
+			d.Conn.SetReadDeadline(time.Now().Add(d.idle))
+		}
+		d.mux.Unlock()
+	}
+
+	n, err := d.Conn.Read(b)
+
+	// Progress on pending calls, extend the deadline
+	if n > 0 && d.idle > 0 && d.perCall {
+		d.mux.Lock()
+		if d.pending > 0 {
+			d.Conn.SetReadDeadline(time.Now().Add(d.idle))
+		}
+		d.mux.Unlock()
+	}
+	if err != nil {
+		d.breakOnce.Do(func() {
+			d.breakErr = err
+			close(d.broken)
+		})
+	}
+	return n, err
+}
+
+func (d *deadlineConn) Write(b []byte) (int, error) {
+	if d.write > 0 {
+		d.Conn.SetWriteDeadline(time.Now().Add(d.write))
+	}
+	return d.Conn.Write(b)
+}
+
+// Translate deadline errors into ErrIOTimeout.
+func wrapIOError(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ErrIOTimeout(err)
+	}
+	return err
+}
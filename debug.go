@@ -0,0 +1,172 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// Duration of CPU profiles when the context has no deadline.
+const defaultCPUProfileDuration = 30 * time.Second
+
+// ProfileArgs are the arguments of the internal profiling call.
+type ProfileArgs struct {
+	Name string
+	// Format, as in runtime/pprof: 0 for the binary format
+	Debug int
+	// Duration of CPU profiles
+	Duration time.Duration
+}
+
+// Profile returns the profile with the given name from the plugin process, in the
+// format of "runtime/pprof".  Any name known to pprof.Lookup is accepted, plus "cpu".
+//
+// The CPU profile is collected until shortly before the ctx deadline, or for thirty
+// seconds if ctx has no deadline.
+func (p *Plugin) Profile(ctx context.Context, name string) ([]byte, error) {
+	args := &ProfileArgs{Name: name}
+	if name == "cpu" {
+		args.Duration = defaultCPUProfileDuration
+		if deadline, ok := ctx.Deadline(); ok {
+			args.Duration = time.Until(deadline) * 9 / 10
+		}
+	}
+	var data []byte
+	if err := p.callContext(ctx, internalObject+".Profile", args, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Goroutines returns the stack traces of all goroutines in the plugin process, in
+// the same text format used for unrecovered panics.
+func (p *Plugin) Goroutines(ctx context.Context) ([]byte, error) {
+	var data []byte
+	args := &ProfileArgs{Name: "goroutine", Debug: 2}
+	if err := p.callContext(ctx, internalObject+".Profile", args, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Internal RPC call to collect a profile. Do not call manually.
+func (s *PingoRpc) Profile(args *ProfileArgs, data *[]byte) error {
+	var buf bytes.Buffer
+
+	if args.Name == "cpu" {
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return err
+		}
+		<-time.After(args.Duration)
+		pprof.StopCPUProfile()
+		*data = buf.Bytes()
+		return nil
+	}
+
+	prof := pprof.Lookup(args.Name)
+	if prof == nil {
+		return errors.New("Unknown profile " + args.Name)
+	}
+	if err := prof.WriteTo(&buf, args.Debug); err != nil {
+		return err
+	}
+	*data = buf.Bytes()
+	return nil
+}
+
+// DebugInfo describes the internal state of a plugin process.
+type DebugInfo struct {
+	Pid int
+	// Stack traces of all goroutines
+	Goroutines   []byte
+	NumGoroutine int
+	MemStats     runtime.MemStats
+	GCStats      debug.GCStats
+	// Number of open RPC connections to the plugin
+	Connections int
+	// Number of sessions open, and of sessions closed because of their TTL
+	Sessions        int
+	SessionsExpired int
+	// Objects registered by the plugin, including internal ones
+	Objects []string
+}
+
+// DebugDump collects information about the internal state of the plugin process,
+// useful to diagnose a plugin that hangs or misbehaves.
+func (p *Plugin) DebugDump(ctx context.Context) (DebugInfo, error) {
+	var info DebugInfo
+	err := p.callContext(ctx, internalObject+".DebugDump", 0, &info)
+	return info, err
+}
+
+// DumpAll writes to w a zip archive containing the debug dump of each plugin in the
+// manager.  Plugins that cannot be dumped are recorded with their error.
+func (m *Manager) DumpAll(ctx context.Context, w io.Writer) error {
+	z := zip.NewWriter(w)
+
+	for _, name := range m.Names() {
+		p := m.Plugin(name)
+		if p == nil {
+			continue
+		}
+		info, err := p.DebugDump(ctx)
+		if err != nil {
+			if err := writeZipFile(z, name+"/error.txt", []byte(err.Error())); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeZipFile(z, name+"/goroutines.txt", info.Goroutines); err != nil {
+			return err
+		}
+		info.Goroutines = nil
+		data, err := json.MarshalIndent(&info, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeZipFile(z, name+"/info.json", data); err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+func writeZipFile(z *zip.Writer, name string, data []byte) error {
+	f, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// Internal RPC call to collect debug information. Do not call manually.
+func (s *PingoRpc) DebugDump(unused int, info *DebugInfo) error {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		return err
+	}
+	info.Pid = os.Getpid()
+	info.Goroutines = buf.Bytes()
+	info.NumGoroutine = runtime.NumGoroutine()
+	runtime.ReadMemStats(&info.MemStats)
+	debug.ReadGCStats(&info.GCStats)
+	info.Connections = int(atomic.LoadInt64(&defaultServer.conns))
+	info.Sessions, info.SessionsExpired = sessionCounts()
+	info.Objects = append([]string(nil), defaultServer.objs...)
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDelegate(t *testing.T) {
+	p := newFixture(t, "unix", "pingo-delegate")
+	p.Start()
+	defer p.Stop()
+
+	if err := sayHello(p); err != nil {
+		t.Fatal(err)
+	}
+	pid := int(p.pid.Load())
+
+	// Still served once the started process has exited
+	time.Sleep(time.Second)
+	if err := sayHello(p); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Stop()
+	if processAlive(pid) {
+		t.Fatalf("delegate %d still running after Stop", pid)
+	}
+}
+
+func TestDelegateNotDescendant(t *testing.T) {
+	// A process the plugin did not start
+	other := exec.Command(fixturePath("pingo-never-exit"))
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		other.Wait()
+		close(exited)
+	}()
+	defer other.Process.Kill()
+
+	p := newFixture(t, "unix", "pingo-delegate")
+	p.SetCmdModifier(func(cmd *exec.Cmd) {
+		cmd.Env = append(os.Environ(), "PINGO_DELEGATE_TO="+strconv.Itoa(other.Process.Pid))
+	})
+	p.Start()
+	defer p.Stop()
+
+	if err := sayHello(p); err == nil {
+		t.Fatal("call succeeded with a delegate not started by the plugin")
+	}
+	p.Stop()
+	select {
+	case <-exited:
+		t.Fatal("Stop killed a process not started by the plugin")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"os/exec"
+	"syscall"
+)
+
+// Errors of calls whose connection to the plugin broke.
+func connectionLost(err error) bool {
+	return err == rpc.ErrShutdown || err == io.EOF || err == io.ErrUnexpectedEOF ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// Called by the control loop when the connection to the plugin breaks, usually
+// because its process died.  Calls are no longer handed the broken client: they wait
+// for the process to exit, or fail once it has not exited within the exit timeout.
+func (c *ctrl) connBroken() {
+	c.brokenCh = nil
+	if c.over != nil || c.isFatal() {
+		return
+	}
+	c.p.readyConn.Store(nil)
+	c.connCh = nil
+	c.brokenTimer = c.p.clock.NewTimer(c.p.exitTimeout)
+	c.brokenTimeoutCh = c.brokenTimer.C()
+}
+
+// Error of the plugin when its connection broke with err and its process kept running.
+func lostConnection(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ErrIOTimeout(err)
+	}
+	return ErrConnectionFailed(errors.New("Lost connection to plugin: " + err.Error()))
+}
+
+// Return the error of calls running when the process exited with err, nil if
+// successfully, without being stopped.
+func (c *ctrl) diedMidCall(err error) error {
+	msg := "Plugin exited during the call"
+	switch e := err.(type) {
+	case nil:
+		msg += " with status 0"
+	case *exec.ExitError:
+		msg += " (" + e.Error() + ")"
+	default:
+		msg += ": " + err.Error()
+	}
+	return ErrPluginDiedMidCall(errors.New(c.withOutput(msg)))
+}
@@ -0,0 +1,234 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var errNoPluginFound = errors.New("No matching plugin found")
+
+// DiscoverOption configures the selection made by Discover.
+type DiscoverOption func(*discoverOptions)
+
+type discoverOptions struct {
+	constraint string
+}
+
+// WithVersionConstraint restricts Discover to versions matching c.  The constraint
+// is a comma separated list of comparisons that must all hold, for example
+// ">=1.2, <2".  Supported operators are =, !=, <, <=, > and >=; a version without
+// operator must match exactly.  Missing minor and patch numbers are zero.
+func WithVersionConstraint(c string) DiscoverOption {
+	return func(o *discoverOptions) {
+		o.constraint = c
+	}
+}
+
+// Discover looks in dir for executables of the plugin called name and returns the path
+// of the best one.  Versioned executables are named after the plugin and the version,
+// as in "myplugin-1.2.3", and the highest version matching the options is selected.
+// Without constraints, an unversioned "myplugin" is used if no versioned one exists.
+//
+// On Windows, executables are expected to have the ".exe" extension.
+func Discover(dir, name string, opts ...DiscoverOption) (string, error) {
+	var o discoverOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cons, err := parseConstraint(o.constraint)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		best     string
+		bestVer  version
+		fallback string
+	)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		file := e.Name()
+		if runtime.GOOS == "windows" {
+			var ok bool
+			if file, ok = strings.CutSuffix(file, ".exe"); !ok {
+				continue
+			}
+		}
+		if file == name {
+			fallback = e.Name()
+			continue
+		}
+		vs, ok := strings.CutPrefix(file, name+"-")
+		if !ok {
+			continue
+		}
+		v, err := parseVersion(vs)
+		if err != nil || !cons.matches(v) {
+			continue
+		}
+		if best == "" || v.compare(bestVer) > 0 {
+			best, bestVer = e.Name(), v
+		}
+	}
+
+	if best == "" && o.constraint == "" {
+		best = fallback
+	}
+	if best == "" {
+		return "", fmt.Errorf("%w: %s in %s", errNoPluginFound, name, dir)
+	}
+	return filepath.Join(dir, best), nil
+}
+
+// Semantic version. Build metadata is ignored.
+type version struct {
+	major, minor, patch int
+	pre                 string
+}
+
+func parseVersion(s string) (version, error) {
+	var v version
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, v.pre, _ = strings.Cut(s, "-")
+
+	nums := strings.Split(s, ".")
+	if len(nums) > 3 {
+		return v, fmt.Errorf("Invalid version %q", s)
+	}
+	parts := []*int{&v.major, &v.minor, &v.patch}
+	for i, n := range nums {
+		x, err := strconv.Atoi(n)
+		if err != nil || x < 0 {
+			return v, fmt.Errorf("Invalid version %q", s)
+		}
+		*parts[i] = x
+	}
+	return v, nil
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Compare following semver precedence: pre-releases come before the release.
+func (v version) compare(w version) int {
+	if c := cmpInt(v.major, w.major); c != 0 {
+		return c
+	}
+	if c := cmpInt(v.minor, w.minor); c != 0 {
+		return c
+	}
+	if c := cmpInt(v.patch, w.patch); c != 0 {
+		return c
+	}
+	switch {
+	case v.pre == w.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case w.pre == "":
+		return -1
+	}
+	return comparePre(v.pre, w.pre)
+}
+
+func comparePre(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if c := cmpInt(an, bn); c != 0 {
+				return c
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmpInt(len(as), len(bs))
+}
+
+type versionCheck struct {
+	op string
+	v  version
+}
+
+type constraint []versionCheck
+
+func parseConstraint(s string) (constraint, error) {
+	var c constraint
+	if strings.TrimSpace(s) == "" {
+		return c, nil
+	}
+	for _, clause := range strings.Split(s, ",") {
+		clause = strings.TrimSpace(clause)
+		op := "="
+		for _, o := range []string{">=", "<=", "!=", ">", "<", "="} {
+			if rest, ok := strings.CutPrefix(clause, o); ok {
+				op, clause = o, strings.TrimSpace(rest)
+				break
+			}
+		}
+		v, err := parseVersion(clause)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid version constraint %q: %s", s, err)
+		}
+		c = append(c, versionCheck{op, v})
+	}
+	return c, nil
+}
+
+func (c constraint) matches(v version) bool {
+	for _, check := range c {
+		r := v.compare(check.v)
+		var ok bool
+		switch check.op {
+		case "=":
+			ok = r == 0
+		case "!=":
+			ok = r != 0
+		case "<":
+			ok = r < 0
+		case "<=":
+			ok = r <= 0
+		case ">":
+			ok = r > 0
+		case ">=":
+			ok = r >= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// NewEmbeddedPlugin creates a plugin from an executable embedded in the host, for example
+// a file of an embed.FS.  The executable is extracted to a private temporary directory
+// and removed when the plugin is stopped.  Plugins created from the same content share
+// the extracted file.
+//
+// Returns an error if f cannot be read or extracted.  See NewPlugin for proto and params.
+func NewEmbeddedPlugin(proto string, f fs.File, params ...string) (*Plugin, error) {
+	path, err := extractPlugin(f)
+	if err != nil {
+		return nil, err
+	}
+	p := NewPlugin(proto, path, params...)
+	p.cleanup = func() { releaseExtracted(path) }
+	// The extracted copy could be replaced while it is not in use
+	if sum, err := ExecutableChecksum(path); err == nil {
+		p.SetChecksum(sum)
+	}
+	return p, nil
+}
+
+// Extracted executables and the number of plugins using each of them.
+var extracted = struct {
+	mux  sync.Mutex
+	dir  string
+	refs map[string]int
+}{refs: make(map[string]int)}
+
+func extractPlugin(f fs.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", errors.New("Cannot extract a directory as plugin")
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+
+	name := hex.EncodeToString(sum[:8]) + "-" + info.Name()
+	if runtime.GOOS == "windows" && !strings.HasSuffix(strings.ToLower(name), ".exe") {
+		name += ".exe"
+	}
+
+	extracted.mux.Lock()
+	defer extracted.mux.Unlock()
+
+	// Only readable and writable by the current user
+	if extracted.dir == "" {
+		dir, err := os.MkdirTemp("", "pingo-embed-")
+		if err != nil {
+			return "", err
+		}
+		extracted.dir = dir
+	}
+	path := filepath.Join(extracted.dir, name)
+
+	if extracted.refs[path] == 0 {
+		if err := WriteExecutable(path, data); err != nil {
+			return "", err
+		}
+	}
+	extracted.refs[path]++
+	return path, nil
+}
+
+// WriteExecutable writes data to path as an executable only accessible by the current
+// user.  The data is written to a temporary file in the same directory first, so that
+// a partial executable is never run.
+func WriteExecutable(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pingo-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0700); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func releaseExtracted(path string) {
+	extracted.mux.Lock()
+	defer extracted.mux.Unlock()
+
+	extracted.refs[path]--
+	if extracted.refs[path] > 0 {
+		return
+	}
+	delete(extracted.refs, path)
+	os.Remove(path)
+	if len(extracted.refs) == 0 {
+		os.Remove(extracted.dir)
+		extracted.dir = ""
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SetOutputEncoding sets the function converting each line the plugin prints to UTF-8,
+// for plugins known to print in a legacy encoding, like DecodeLatin1.  Any decoder can
+// be adapted, for example one of golang.org/x/text:
+//
+//	p.SetOutputEncoding(func(line []byte) string {
+//		s, _ := charmap.CodePage850.NewDecoder().Bytes(line)
+//		return string(s)
+//	})
+//
+// By default, bytes that are not valid UTF-8 are escaped as "\xNN", so that they cannot
+// corrupt the terminal or the logs of the host.  Output copied to the file set with
+// SetOutputFile from standard error is not converted.
+//
+// Panics if called after Start.
+func (p *Plugin) SetOutputEncoding(decode func(line []byte) string) {
+	if p.running {
+		panic("Cannot call SetOutputEncoding after Start")
+	}
+	p.outputDec = decode
+}
+
+// DecodeLatin1 converts a line in ISO 8859-1 to UTF-8.
+func DecodeLatin1(line []byte) string {
+	runes := make([]rune, len(line))
+	for i, b := range line {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// Characters of Windows-1252 that differ from ISO 8859-1, from 0x80 to 0x9f.  Unused
+// codes are mapped to the same control characters as in ISO 8859-1.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// DecodeWindows1252 converts a line in Windows-1252, the default encoding of Windows
+// programs in western languages, to UTF-8.
+func DecodeWindows1252(line []byte) string {
+	runes := make([]rune, len(line))
+	for i, b := range line {
+		runes[i] = rune(b)
+		if b >= 0x80 && b < 0xa0 {
+			runes[i] = windows1252[b-0x80]
+		}
+	}
+	return string(runes)
+}
+
+// Convert a line printed by the plugin to valid UTF-8.
+func (p *Plugin) decodeOutput(line []byte) string {
+	if p.outputDec != nil {
+		return p.outputDec(line)
+	}
+	if utf8.Valid(line) {
+		return string(line)
+	}
+	var sb strings.Builder
+	for len(line) > 0 {
+		r, size := utf8.DecodeRune(line)
+		if r == utf8.RuneError && size == 1 {
+			fmt.Fprintf(&sb, `\x%02x`, line[0])
+		} else {
+			sb.Write(line[:size])
+		}
+		line = line[size:]
+	}
+	return sb.String()
+}
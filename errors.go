@@ -6,12 +6,14 @@ package pingo
 
 import (
 	"errors"
+	"net/rpc"
 	"strings"
 )
 
 const (
-	errorCodeConnFailed = "err-connection-failed"
-	errorCodeHttpServe  = "err-http-serve"
+	errorCodeConnFailed      = "err-connection-failed"
+	errorCodeHttpServe       = "err-http-serve"
+	errorCodeMessageTooLarge = "err-message-too-large"
 )
 
 // Error reported when connection to the external plugin has failed.
@@ -24,23 +26,122 @@ type ErrHttpServe error
 type ErrInvalidMessage error
 
 // Error reported when the plugin fails to register before the registration
-// timeout expires.
+// timeout expires.  The message includes the last lines the plugin printed, if any.
 type ErrRegistrationTimeout error
 
+// Error reported when the plugin is used before calling Start.
+type ErrNotStarted error
+
+// Error reported when an operation requires a running plugin process.
+type ErrNotRunning error
+
+// Error reported when a read or write on the RPC connection exceeds its deadline.
+type ErrIOTimeout error
+
+// Error reported when a message exceeds the size limits set on the host or the plugin.
+type ErrMessageTooLarge error
+
+// Error reported when the arguments or the reply of a call have types that cannot be
+// sent between host and plugin, like channels, functions or structs without exported
+// fields.
+type ErrUnencodableArgument error
+
+// Error reported when the executable of a plugin does not match its expected checksum.
+type ErrIntegrity error
+
+// Error reported when a plugin has been killed by a watchdog because it stopped
+// answering.
+type ErrHung error
+
+// Error reported by a replayed plugin for calls that were not recorded.
+type ErrNotRecorded error
+
+// Error reported for faults injected with SetChaos.
+type ErrChaos error
+
+// Error reported when the host or the plugin ran out of file descriptors.
+type ErrFDExhausted error
+
+// Error reported when the executable of the plugin, or the interpreter of a script,
+// cannot be found.
+type ErrExecutableNotFound error
+
+// Error reported when the host is not allowed to execute the plugin.
+type ErrPermissionDenied error
+
+// Error reported when the executable of the plugin is not a program for this operating
+// system and architecture.
+type ErrExecFormat error
+
+// Error reported when the plugin exits before completing the handshake.  The message
+// includes the last lines the plugin printed, if any.
+type ErrCrashedOnStartup error
+
+// Error returned by calls running when the plugin process exits by itself.  The message
+// includes the exit status and the last lines the plugin printed, if any.
+type ErrPluginDiedMidCall error
+
+// Error returned by calls in a session that is closed, or that the plugin does not know.
+type ErrSessionClosed error
+
+// Error returned by calls in a transaction that is over, or that the plugin does not know.
+type ErrTransactionDone error
+
 func parseError(line string) error {
-	parts := strings.SplitN(line, ": ", 2)
-	if parts[0] == "" {
+	code, msg, ok := parseField(line)
+	if !ok {
 		return nil
 	}
 
-	err := errors.New(parts[1])
+	err := errors.New(msg)
 
-	switch parts[0] {
+	switch code {
 	case errorCodeConnFailed:
 		return ErrConnectionFailed(err)
 	case errorCodeHttpServe:
 		return ErrHttpServe(err)
+	case errorCodeFDExhausted:
+		return ErrFDExhausted(err)
 	}
 
 	return err
 }
+
+// Convert errors returned by the plugin for a call to method into the matching types.
+func parseCallError(method string, err error) error {
+	serr, ok := err.(rpc.ServerError)
+	if !ok {
+		// Failed to decode the response
+		if err != nil && strings.HasPrefix(err.Error(), "reading body ") {
+			if typ, ok := unregisteredType(err); ok {
+				return &UnregisteredTypeError{Method: method, Type: typ}
+			}
+		}
+		return err
+	}
+	code, rest, ok := parseField(string(serr))
+	if !ok {
+		return err
+	}
+	switch code {
+	case errorCodeValidation:
+		method, msg, ok := strings.Cut(rest, ": ")
+		if !ok {
+			return err
+		}
+		return &ValidationError{Method: method, Message: msg}
+	case errorCodeMessageTooLarge:
+		return ErrMessageTooLarge(errors.New(rest))
+	case errorCodeUnregisteredType:
+		method, typ, ok := strings.Cut(rest, ": ")
+		if !ok {
+			return err
+		}
+		return &UnregisteredTypeError{Method: method, Type: typ, InPlugin: true}
+	case errorCodeSessionClosed:
+		return ErrSessionClosed(errors.New(rest))
+	case errorCodeTransactionDone:
+		return ErrTransactionDone(errors.New(rest))
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Number of events buffered by a Manager
+const eventsBuffer = 256
+
+// EventType tells what happened to a plugin.
+type EventType int
+
+const (
+	// The plugin completed its handshake and accepts calls
+	EventPluginStarted EventType = iota
+	// The plugin failed before completing its handshake
+	EventHandshakeFailed
+	// A call to the plugin returned an error
+	EventCallErrored
+	// The plugin replaced another one, for example in a rolling restart
+	EventRestarted
+	// The plugin printed a line other than a control message
+	EventOutputLine
+)
+
+var eventTypeNames = [...]string{"plugin-started", "handshake-failed", "call-errored", "restarted", "output-line"}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return "unknown"
+	}
+	return eventTypeNames[t]
+}
+
+// MarshalText represents the event type by its name.
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Event is something that happened to a plugin of a Manager, see Manager.Events.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Name the plugin was added with
+	Name   string
+	Plugin *Plugin
+	// Why the handshake or the call failed
+	Err error
+	// Method of a call that failed
+	Method string
+	// Line of output
+	Line string
+	// Plugin replaced by a restart
+	Replaced *Plugin
+}
+
+// EventsPolicy tells what happens to an event when the channel of Manager.Events is full.
+type EventsPolicy int
+
+const (
+	// The new event is dropped.  This is the default
+	DropNewest EventsPolicy = iota
+	// The oldest event in the channel is dropped to make room for the new one
+	DropOldest
+	// The plugin waits until the event is read
+	BlockProducer
+)
+
+// Events of a manager, sent to its channel according to a policy.
+type eventQueue struct {
+	ch      chan Event
+	policy  atomic.Int32
+	dropped atomic.Uint64
+}
+
+// Send e to the channel, or drop an event if it is full.
+func (q *eventQueue) send(e Event) {
+	switch EventsPolicy(q.policy.Load()) {
+	case BlockProducer:
+		q.ch <- e
+	case DropOldest:
+		for {
+			select {
+			case q.ch <- e:
+				return
+			default:
+			}
+			select {
+			case <-q.ch:
+				q.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.ch <- e:
+		default:
+			q.dropped.Add(1)
+		}
+	}
+}
+
+// Where a plugin reports its events.
+type eventSink struct {
+	name  string
+	queue *eventQueue
+}
+
+// Events returns the channel on which the events of all plugins in the manager are
+// reported.  Plugins report events while they are in the manager.  When the channel is
+// full, events are handled according to the policy set with SetEventsPolicy.
+func (m *Manager) Events() <-chan Event {
+	return m.events.ch
+}
+
+// SetEventsPolicy sets what happens to events when the channel returned by Events is
+// full because it is not read fast enough.  By default, new events are dropped.
+//
+// With BlockProducer no event is lost, but plugins stop reading their output and
+// callers wait for failed calls to return until the channel is read again: only use it
+// if the channel is always read.
+func (m *Manager) SetEventsPolicy(policy EventsPolicy) {
+	m.events.policy.Store(int32(policy))
+}
+
+// EventsDropped returns the number of events dropped because the channel returned by
+// Events was full.
+func (m *Manager) EventsDropped() uint64 {
+	return m.events.dropped.Load()
+}
+
+// Make p report its events to the manager as name.
+func (m *Manager) reportEvents(name string, p *Plugin) {
+	p.sink.Store(&eventSink{name: name, queue: m.events})
+}
+
+// Stop p reporting its events to the manager.
+func (m *Manager) ignoreEvents(p *Plugin) {
+	if s := p.sink.Load(); s != nil && s.queue == m.events {
+		p.sink.CompareAndSwap(s, nil)
+	}
+}
+
+// Report an event to the manager of the plugin, if any.
+func (p *Plugin) emit(e Event) {
+	s := p.sink.Load()
+	if s == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.Name = s.name
+	e.Plugin = p
+	s.queue.send(e)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dullgiulio/pingo"
+)
+
+type Plugin struct{}
+
+// Block keeps the only call slot of the plugin for ms milliseconds, then returns ms.
+func (p *Plugin) Block(ms int, slept *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*slept = ms
+	return nil
+}
+
+func main() {
+	plugin := &Plugin{}
+
+	pingo.SetMaxConcurrentCalls(1)
+	pingo.Register(plugin)
+	pingo.Run()
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+
+	"github.com/dullgiulio/pingo"
+)
+
+type Plugin struct{}
+
+// Crash terminates the plugin process while the call is in progress.
+func (p *Plugin) Crash(code int, unused *int) error {
+	os.Exit(code)
+	return nil
+}
+
+func main() {
+	plugin := &Plugin{}
+
+	pingo.Register(plugin)
+	pingo.Run()
+}
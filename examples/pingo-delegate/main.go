@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/dullgiulio/pingo"
+)
+
+type Plugin struct{}
+
+func (p *Plugin) SayHello(name string, msg *string) error {
+	*msg = fmt.Sprintf("Hello %s", name)
+	return nil
+}
+
+// Hand over to a copy of this process, then exit.  With PINGO_DELEGATE_TO set, hand over
+// to the process with that pid instead.
+func main() {
+	if os.Getenv("PINGO_DELEGATE_CHILD") == "" {
+		if pid := os.Getenv("PINGO_DELEGATE_TO"); pid != "" {
+			var n int
+			fmt.Sscan(pid, &n)
+			pingo.Delegate(n)
+			time.Sleep(10 * time.Second)
+			return
+		}
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
+		cmd.Env = append(os.Environ(), "PINGO_DELEGATE_CHILD=1")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			panic(err)
+		}
+		time.Sleep(500 * time.Millisecond)
+		return
+	}
+
+	pingo.Delegate(os.Getpid())
+
+	plugin := &Plugin{}
+
+	pingo.Register(plugin)
+	pingo.Run()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dullgiulio/pingo"
+)
+
+type Plugin struct{}
+
+func (p *Plugin) SayHello(name string, msg *string) error {
+	*msg = fmt.Sprintf("Hello %s", name)
+	return nil
+}
+
+// Print output that resembles control messages before registering.
+func garbage() {
+	prefix := "pingo"
+	for _, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "-pingo:prefix=") {
+			prefix = arg[len("-pingo:prefix="):]
+		}
+	}
+	fmt.Println(prefix)
+	fmt.Println(prefix + ":")
+	fmt.Println(prefix + ": ")
+	fmt.Println(prefix + ": error")
+	fmt.Println(prefix + ": error: no code")
+	fmt.Println(prefix + ": bogus key: value")
+	fmt.Println("\x00\xff\xfe not even text")
+}
+
+func main() {
+	garbage()
+
+	plugin := &Plugin{}
+
+	pingo.Register(plugin)
+	pingo.Run()
+}
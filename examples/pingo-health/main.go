@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dullgiulio/pingo"
+)
+
+type Plugin struct {
+	mux      sync.Mutex
+	greeting string
+	reloads  int
+}
+
+func (p *Plugin) SayHello(name string, msg *string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	*msg = fmt.Sprintf("%s %s", p.greeting, name)
+	return nil
+}
+
+// Called each time the host asks for a reload.
+func (p *Plugin) reload() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.reloads++
+	p.greeting = fmt.Sprintf("Hello (config version %d)", p.reloads)
+	return nil
+}
+
+// A check that fails after too many reloads.
+func (p *Plugin) check() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.reloads > 2 {
+		return errors.New("reloaded too many times")
+	}
+	return nil
+}
+
+func main() {
+	plugin := &Plugin{greeting: "Hello"}
+
+	pingo.AddHealthCheck("reloads", plugin.check)
+	pingo.OnReload(plugin.reload)
+
+	pingo.Register(plugin)
+	pingo.Run()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/dullgiulio/pingo"
+)
+
+func main() {
+	m := pingo.NewManager()
+	m.Add("hello", pingo.NewPlugin("unix", "bin/plugins/pingo-hello-world"))
+	m.Add("health", pingo.NewPlugin("tcp", "bin/plugins/pingo-health"))
+
+	// Broadcast a reload to all plugins when we receive SIGHUP
+	m.ReloadOn(syscall.SIGHUP)
+
+	for _, name := range m.Names() {
+		m.Plugin(name).Start()
+		defer m.Plugin(name).Stop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	health := m.Plugin("health")
+	var resp string
+	for i := 0; i < 4; i++ {
+		if err := health.Call("Plugin.SayHello", "from the manager", &resp); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(resp)
+
+		h, err := health.Health()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Ready: %t, checks: %+v\n", h.Ready, h.Checks)
+
+		if err := m.Reload(ctx); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	stats, err := m.StatsJSON()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Stats: %s\n", stats)
+
+	f, err := os.Create("bin/support-bundle.zip")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer f.Close()
+	if err := m.DumpAll(ctx, f); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Support bundle written to bin/support-bundle.zip")
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Ignore requests to terminate and never register, so that only a kill stops the plugin.
+func main() {
+	signal.Ignore(os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/dullgiulio/pingo"
+)
+
+// Token announced to the host
+const token = "silent"
+
+type Plugin struct{}
+
+func (p *Plugin) SayHello(name string, msg *string) error {
+	*msg = "Hello " + name
+	return nil
+}
+
+// Connection that stops reading as soon as a request for method arrives, so that it
+// and the following requests are never answered.
+type stallConn struct {
+	net.Conn
+	method []byte
+	// End of the data read so far, in case method spans two reads
+	tail []byte
+}
+
+func (c *stallConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	data := append(c.tail, p[:n]...)
+	if bytes.Contains(data, c.method) {
+		for {
+			time.Sleep(time.Hour)
+		}
+	}
+	if len(data) >= len(c.method) {
+		data = data[len(data)-len(c.method)+1:]
+	}
+	c.tail = append([]byte(nil), data...)
+	return n, err
+}
+
+// Announce itself like a plugin, then stop answering when the internal call named by
+// PINGO_SILENT_CALL ("ListObjects" by default) arrives, like a plugin stuck before
+// serving calls.
+func main() {
+	method := os.Getenv("PINGO_SILENT_CALL")
+	if method == "" {
+		method = "ListObjects"
+	}
+	flag.Parse()
+	prefix := flag.Lookup("pingo:prefix").Value.String()
+
+	pingo.Register(&Plugin{})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: auth-token: %s\n", prefix, token)
+	fmt.Printf("%s: ready: proto=tcp addr=%s\n", prefix, l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			os.Exit(1)
+		}
+		go pingo.ServeConn(&stallConn{Conn: conn, method: []byte(method)}, token)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dullgiulio/pingo"
+)
+
+type Plugin struct{}
+
+func (p *Plugin) SayHello(name string, msg *string) error {
+	*msg = fmt.Sprintf("Hello %s", name)
+	return nil
+}
+
+func main() {
+	// Take a while to start, but less than the default timeout
+	<-time.After(1 * time.Second)
+
+	plugin := &Plugin{}
+
+	pingo.Register(plugin)
+	pingo.Run()
+}
@@ -0,0 +1,39 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+)
+
+// Classify the error starting the executable at path, with a hint to fix it.
+func execError(path string, err error) error {
+	switch {
+	case errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist):
+		return ErrExecutableNotFound(errors.New("Plugin executable " + path + " not found: check the path and that the plugin is installed, and for scripts that their interpreter exists: " + err.Error()))
+	case errors.Is(err, fs.ErrPermission):
+		return ErrPermissionDenied(errors.New("Not allowed to execute plugin " + path + ": check that the file is executable (chmod +x) and not on a noexec file system: " + err.Error()))
+	case errors.Is(err, errBadExeFormat):
+		return ErrExecFormat(errors.New("Plugin " + path + " is not an executable for this system: check that it was built for this operating system and architecture (GOOS and GOARCH): " + err.Error()))
+	}
+	return err
+}
+
+// Return the error of a plugin that exited with err, nil if successfully, before
+// completing the handshake.  Errors of the process not exiting are returned as they are.
+func (c *ctrl) crashedOnStartup(err error) error {
+	msg := "Plugin exited before completing the handshake"
+	switch e := err.(type) {
+	case nil:
+		msg += " with status 0: check that it calls Run"
+	case *exec.ExitError:
+		msg += " (" + e.Error() + ")"
+	default:
+		return err
+	}
+	return ErrCrashedOnStartup(errors.New(c.withOutput(msg)))
+}
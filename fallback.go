@@ -0,0 +1,56 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+)
+
+// Caller trying a plugin before another.
+type fallback struct {
+	primary, secondary Caller
+}
+
+// Fallback returns a caller performing calls on primary, or on secondary if primary
+// cannot answer them: because it is not running, failed to start, lost its connection or
+// timed out on I/O.  Errors returned by the methods of primary, and the errors of ctx for
+// CallContext, are returned as they are.  Useful for optional plugins accelerating an
+// implementation in the host, see NewInProcess.
+//
+// A call that primary lost while running is performed again on secondary, so it may run
+// on both.
+func Fallback(primary, secondary Caller) Caller {
+	return &fallback{primary: primary, secondary: secondary}
+}
+
+func (f *fallback) Call(name string, args interface{}, resp interface{}) error {
+	err := f.primary.Call(name, args, resp)
+	if answered(err) {
+		return err
+	}
+	return f.secondary.Call(name, args, resp)
+}
+
+func (f *fallback) CallContext(ctx context.Context, name string, args interface{}, resp interface{}) error {
+	err := f.primary.CallContext(ctx, name, args, resp)
+	if answered(err) || ctx.Err() != nil {
+		return err
+	}
+	return f.secondary.CallContext(ctx, name, args, resp)
+}
+
+// Whether err is the result of a call the plugin answered, or was rejected in the host.
+func answered(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var serr rpc.ServerError
+	var verr *ValidationError
+	var uerr *UnregisteredTypeError
+	var scerr *SchemaError
+	return errors.As(err, &serr) || errors.As(err, &verr) || errors.As(err, &uerr) || errors.As(err, &scerr)
+}
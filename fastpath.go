@@ -0,0 +1,234 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Calls with strings, ints or byte slices as arguments or replies are encoded
+// and decoded directly, without going through reflection in the gob package.
+// The messages are the same gob would produce: the length, the predefined id
+// of the type, a zero byte marking a single value, and the value itself.
+
+// Predefined gob type ids
+const (
+	gobIntID    = 2
+	gobBytesID  = 5
+	gobStringID = 6
+)
+
+var errFastMessage = errors.New("Malformed message")
+
+func appendGobUint(b []byte, x uint64) []byte {
+	if x < 0x80 {
+		return append(b, byte(x))
+	}
+	var be [8]byte
+	binary.BigEndian.PutUint64(be[:], x)
+	i := 0
+	for be[i] == 0 {
+		i++
+	}
+	b = append(b, byte(-(8 - i)))
+	return append(b, be[i:]...)
+}
+
+func appendGobInt(b []byte, i int64) []byte {
+	if i < 0 {
+		return appendGobUint(b, uint64(^i<<1)|1)
+	}
+	return appendGobUint(b, uint64(i<<1))
+}
+
+func readGobUint(r io.ByteReader) (uint64, int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	if b < 0x80 {
+		return uint64(b), 1, nil
+	}
+	n := int(-int8(b))
+	if n > 8 {
+		return 0, 0, errFastMessage
+	}
+	var x uint64
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		x = x<<8 | uint64(b)
+	}
+	return x, n + 1, nil
+}
+
+func readGobInt(r io.ByteReader) (int64, int, error) {
+	x, n, err := readGobUint(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if x&1 != 0 {
+		return ^int64(x >> 1), n, nil
+	}
+	return int64(x >> 1), n, nil
+}
+
+// Write v as a gob message if it has one of the types of the fast path.
+func writeFastMessage(w *bufio.Writer, v interface{}) (bool, error) {
+	var (
+		buf     [32]byte
+		payload []byte
+		str     string
+	)
+	head := buf[:0]
+
+	switch x := v.(type) {
+	case string:
+		str = x
+		head = appendGobUint(append(head, gobStringID<<1, 0), uint64(len(x)))
+	case *string:
+		if x == nil {
+			return false, nil
+		}
+		str = *x
+		head = appendGobUint(append(head, gobStringID<<1, 0), uint64(len(*x)))
+	case []byte:
+		payload = x
+		head = appendGobUint(append(head, gobBytesID<<1, 0), uint64(len(x)))
+	case *[]byte:
+		if x == nil {
+			return false, nil
+		}
+		payload = *x
+		head = appendGobUint(append(head, gobBytesID<<1, 0), uint64(len(*x)))
+	case int:
+		head = appendGobInt(append(head, gobIntID<<1, 0), int64(x))
+	case *int:
+		if x == nil {
+			return false, nil
+		}
+		head = appendGobInt(append(head, gobIntID<<1, 0), int64(*x))
+	default:
+		return false, nil
+	}
+
+	var lbuf [9]byte
+	size := appendGobUint(lbuf[:0], uint64(len(head)+len(payload)+len(str)))
+	if _, err := w.Write(size); err != nil {
+		return true, err
+	}
+	if _, err := w.Write(head); err != nil {
+		return true, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return true, err
+	}
+	_, err := w.WriteString(str)
+	return true, err
+}
+
+// Read the next message into v if v has one of the types of the fast path
+// and the message contains a value of the same type.  Otherwise, leave the
+// message to the gob decoder.
+func readFastMessage(r *bufio.Reader, v interface{}) (bool, error) {
+	var id byte
+	switch x := v.(type) {
+	case *string:
+		if x == nil {
+			return false, nil
+		}
+		id = gobStringID
+	case *[]byte:
+		if x == nil {
+			return false, nil
+		}
+		id = gobBytesID
+	case *int:
+		if x == nil {
+			return false, nil
+		}
+		id = gobIntID
+	default:
+		return false, nil
+	}
+
+	// Peek at the length and type of the message
+	head, err := r.Peek(1)
+	if err != nil {
+		return false, nil
+	}
+	n := 1
+	if head[0] >= 0x80 {
+		n = 1 + int(-int8(head[0]))
+		if n > 9 {
+			return false, nil
+		}
+	}
+	head, err = r.Peek(n + 2)
+	if err != nil || head[n] != id<<1 || head[n+1] != 0 {
+		return false, nil
+	}
+
+	size, _, err := readGobUint(r)
+	if err != nil {
+		return true, err
+	}
+	if size < 2 {
+		return true, errFastMessage
+	}
+	r.Discard(2)
+	size -= 2
+
+	switch x := v.(type) {
+	case *int:
+		i, m, err := readGobInt(r)
+		if err != nil {
+			return true, err
+		}
+		if uint64(m) != size {
+			return true, errFastMessage
+		}
+		*x = int(i)
+	case *string:
+		data, err := readFastBytes(r, size, nil)
+		if err != nil {
+			return true, err
+		}
+		*x = string(data)
+	case *[]byte:
+		data, err := readFastBytes(r, size, *x)
+		if err != nil {
+			return true, err
+		}
+		*x = data
+	}
+	return true, nil
+}
+
+// Read a length-prefixed byte string filling a message of size bytes.
+// Reuses the memory of buf if large enough.
+func readFastBytes(r *bufio.Reader, size uint64, buf []byte) ([]byte, error) {
+	l, m, err := readGobUint(r)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(m)+l != size {
+		return nil, errFastMessage
+	}
+	if uint64(cap(buf)) >= l {
+		buf = buf[:l]
+	} else {
+		buf = make([]byte, l)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+const errorCodeFDExhausted = "err-fd-exhausted"
+
+// Bounds of the wait before retrying after running out of file descriptors
+const (
+	fdBackoffMin = 5 * time.Millisecond
+	fdBackoffMax = time.Second
+)
+
+// Whether err is caused by the process or the system running out of file descriptors.
+func isFDExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+func fdExhausted(err error) error {
+	return ErrFDExhausted(errors.New("Out of file descriptors, raise the limit (ulimit -n) or use fewer connections: " + err.Error()))
+}
+
+// Wait before retrying an operation that ran out of file descriptors, doubling each time
+// it fails again.  Waits are randomized, so that processes retrying together spread out.
+type fdBackoff struct {
+	delay time.Duration
+}
+
+func (b *fdBackoff) wait() {
+	if b.delay == 0 {
+		b.delay = fdBackoffMin
+	} else if b.delay *= 2; b.delay > fdBackoffMax {
+		b.delay = fdBackoffMax
+	}
+	time.Sleep(b.delay/2 + time.Duration(randUint64()%uint64(b.delay/2)))
+}
+
+// Whether a wait is in progress, since the last reset.
+func (b *fdBackoff) waiting() bool {
+	return b.delay != 0
+}
+
+func (b *fdBackoff) reset() {
+	b.delay = 0
+}
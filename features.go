@@ -0,0 +1,56 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"sync"
+)
+
+// SetFeatures enables the named features in the plugin, which checks them with
+// FeatureEnabled.  Use it to let code on both sides agree on optional behaviour, like
+// new kinds of calls that older hosts do not support.  The features are passed along
+// with the host info, in addition to HostInfo.Features.
+//
+// Panics if called after Start.
+func (p *Plugin) SetFeatures(names ...string) {
+	if p.running {
+		panic("Cannot call SetFeatures after Start")
+	}
+	p.features = names
+}
+
+// Return the host info passed to the plugin, including the features.
+func (p *Plugin) startInfo() *HostInfo {
+	if len(p.features) == 0 {
+		return p.hostInfo
+	}
+	info := HostInfo{Pid: os.Getpid()}
+	if p.hostInfo != nil {
+		info = *p.hostInfo
+	}
+	info.Features = append(info.Features[:len(info.Features):len(info.Features)], p.features...)
+	return &info
+}
+
+var enabledFeatures struct {
+	once sync.Once
+	set  map[string]bool
+}
+
+// FeatureEnabled reports whether the host enabled the feature called name, with
+// Plugin.SetFeatures or HostInfo.Features.  Hosts built with older versions of this
+// package, or not enabling features, enable none.
+func FeatureEnabled(name string) bool {
+	enabledFeatures.once.Do(func() {
+		enabledFeatures.set = make(map[string]bool)
+		if info, ok := Host(); ok {
+			for _, f := range info.Features {
+				enabledFeatures.set[f] = true
+			}
+		}
+	})
+	return enabledFeatures.set[name]
+}
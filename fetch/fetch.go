@@ -0,0 +1,226 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package fetch downloads plugin executables and prepares them to be run with pingo.
+//
+// Plugins are referenced either by an HTTP(S) URL or by an OCI reference of the form
+// "oci://registry/repository:tag" (or "@sha256:..." instead of the tag), as pushed by
+// tools like ORAS.  Downloads are verified against a checksum or an ed25519 signature
+// and cached by content hash under the user cache directory.
+package fetch
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/dullgiulio/pingo"
+)
+
+var (
+	errChecksum  = errors.New("Checksum mismatch")
+	errSignature = errors.New("Invalid signature")
+	errScheme    = errors.New("Unsupported plugin reference")
+	errInsecure  = errors.New("Plugin source has no checksum or public key")
+	errTooLarge  = errors.New("Download exceeds the maximum size")
+)
+
+// DefaultMaxSize is the maximum size of downloads when Fetcher.MaxSize is zero.
+const DefaultMaxSize = 512 << 20
+
+// Source describes where to get a plugin and how to verify it.
+type Source struct {
+	// URL or OCI reference of the plugin executable
+	Ref string
+	// Expected hex-encoded SHA-256 of the executable.  When set, a cached copy
+	// is used without downloading the plugin again.
+	Checksum string
+	// If PublicKey is set, Signature must be its ed25519 signature of the executable
+	PublicKey ed25519.PublicKey
+	Signature []byte
+	// Run the plugin without verifying it.  Without Insecure, either Checksum or
+	// PublicKey must be set.
+	Insecure bool
+}
+
+// Fetcher downloads and caches plugins.
+type Fetcher struct {
+	// Directory where plugins are cached
+	CacheDir string
+	// HTTP client used for downloads
+	Client *http.Client
+	// Maximum size in bytes of each download, DefaultMaxSize if zero
+	MaxSize int64
+}
+
+// NewFetcher returns a Fetcher caching plugins in the "pingo" directory under the
+// user cache directory and using the default HTTP client.
+func NewFetcher() (*Fetcher, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Fetcher{
+		CacheDir: filepath.Join(dir, "pingo"),
+		Client:   http.DefaultClient,
+	}, nil
+}
+
+// Plugin fetches the plugin described by src and returns it ready to be started.
+// See pingo.NewPlugin for proto and params.
+func (f *Fetcher) Plugin(ctx context.Context, proto string, src Source, params ...string) (*pingo.Plugin, error) {
+	path, err := f.Fetch(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := pingo.ExecutableChecksum(path)
+	if err != nil {
+		return nil, err
+	}
+	p := pingo.NewPlugin(proto, path, params...)
+	// Verify the cached copy again each time the plugin is started
+	p.SetChecksum(sum)
+	return p, nil
+}
+
+// Fetch downloads and verifies the plugin described by src, unless a verified copy is
+// already cached, and returns the path of the executable.
+//
+// Returns an error if src has neither Checksum nor PublicKey and is not marked Insecure.
+func (f *Fetcher) Fetch(ctx context.Context, src Source) (string, error) {
+	if src.Checksum == "" && src.PublicKey == nil && !src.Insecure {
+		return "", errInsecure
+	}
+	name, err := refName(src.Ref)
+	if err != nil {
+		return "", err
+	}
+
+	if src.Checksum != "" {
+		path := f.cachePath(strings.ToLower(src.Checksum), name)
+		if data, err := os.ReadFile(path); err == nil && src.verify(data) == nil {
+			return path, nil
+		}
+	}
+
+	var data []byte
+	if strings.HasPrefix(src.Ref, "oci://") {
+		data, err = f.fetchOCI(ctx, strings.TrimPrefix(src.Ref, "oci://"))
+	} else {
+		data, err = f.fetchURL(ctx, src.Ref)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := src.verify(data); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	path := f.cachePath(hex.EncodeToString(sum[:]), name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := pingo.WriteExecutable(path, data); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (s *Source) verify(data []byte) error {
+	if s.Checksum != "" {
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), s.Checksum) {
+			return errChecksum
+		}
+	}
+	if s.PublicKey != nil && !ed25519.Verify(s.PublicKey, data, s.Signature) {
+		return errSignature
+	}
+	return nil
+}
+
+func (f *Fetcher) cachePath(sum, name string) string {
+	if runtime.GOOS == "windows" && !strings.HasSuffix(strings.ToLower(name), ".exe") {
+		name += ".exe"
+	}
+	return filepath.Join(f.CacheDir, sum, name)
+}
+
+// Name of the executable referenced by ref.
+func refName(ref string) (string, error) {
+	if rest, ok := strings.CutPrefix(ref, "oci://"); ok {
+		repo, _, err := splitOCIRef(rest)
+		if err != nil {
+			return "", err
+		}
+		name := path.Base(repo)
+		if i := strings.IndexAny(name, ":@"); i > 0 {
+			name = name[:i]
+		}
+		return name, nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errScheme
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "", errScheme
+	}
+	return name, nil
+}
+
+func (f *Fetcher) get(ctx context.Context, u string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return f.Client.Do(req)
+}
+
+func (f *Fetcher) fetchURL(ctx context.Context, u string) ([]byte, error) {
+	resp, err := f.get(ctx, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Cannot download %s: %s", u, resp.Status)
+	}
+	return f.readBody(resp.Body)
+}
+
+// Read r up to the maximum size of downloads.
+func (f *Fetcher) readBody(r io.Reader) ([]byte, error) {
+	limit := f.MaxSize
+	if limit <= 0 {
+		limit = DefaultMaxSize
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
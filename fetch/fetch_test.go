@@ -0,0 +1,87 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+var executable = []byte("#!/bin/sh\necho plugin\n")
+
+func newServer(t *testing.T, data []byte) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/plugin"
+}
+
+func newTestFetcher(t *testing.T) *Fetcher {
+	return &Fetcher{CacheDir: t.TempDir(), Client: http.DefaultClient}
+}
+
+func TestFetchRequiresVerification(t *testing.T) {
+	f := newTestFetcher(t)
+	ref := newServer(t, executable)
+
+	if _, err := f.Fetch(context.Background(), Source{Ref: ref}); err != errInsecure {
+		t.Fatalf("unverified source fetched, error %v", err)
+	}
+	path, err := f.Fetch(context.Background(), Source{Ref: ref, Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, executable) {
+		t.Fatalf("unexpected executable %q, error %v", data, err)
+	}
+}
+
+func TestFetchVerified(t *testing.T) {
+	f := newTestFetcher(t)
+	ref := newServer(t, executable)
+
+	sum := sha256.Sum256(executable)
+	if _, err := f.Fetch(context.Background(), Source{Ref: ref, Checksum: hex.EncodeToString(sum[:])}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Fetch(context.Background(), Source{Ref: ref, Checksum: hex.EncodeToString(sum[1:])}); err != errChecksum {
+		t.Fatalf("checksum mismatch not detected, error %v", err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := ed25519.Sign(priv, executable)
+	if _, err := f.Fetch(context.Background(), Source{Ref: ref, PublicKey: pub, Signature: sig}); err != nil {
+		t.Fatal(err)
+	}
+	sig[0] ^= 1
+	if _, err := f.Fetch(context.Background(), Source{Ref: ref, PublicKey: pub, Signature: sig}); err != errSignature {
+		t.Fatalf("invalid signature not detected, error %v", err)
+	}
+}
+
+func TestFetchMaxSize(t *testing.T) {
+	f := newTestFetcher(t)
+	f.MaxSize = int64(len(executable))
+	src := Source{Ref: newServer(t, executable), Insecure: true}
+
+	if _, err := f.Fetch(context.Background(), src); err != nil {
+		t.Fatalf("download of the maximum size failed: %v", err)
+	}
+	f.MaxSize--
+	if _, err := f.Fetch(context.Background(), src); err != errTooLarge {
+		t.Fatalf("download over the maximum size accepted, error %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+)
+
+const (
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+var (
+	errOCIRef      = errors.New("Invalid OCI reference")
+	errNoLayer     = errors.New("OCI artifact has no layers")
+	errNoPlatform  = errors.New("OCI index has no manifest for this platform")
+	errBlobDigest  = errors.New("OCI blob digest mismatch")
+	errUnsupported = errors.New("Unsupported OCI digest algorithm")
+)
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Platform  *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform,omitempty"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// Split "registry/repository:tag" or "registry/repository@digest" into the
+// registry and repository, and the tag or digest.
+func splitOCIRef(ref string) (string, string, error) {
+	var repo, tag string
+	if i := strings.Index(ref, "@"); i > 0 {
+		repo, tag = ref[:i], ref[i+1:]
+	} else if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repo, tag = ref[:i], ref[i+1:]
+	} else {
+		repo, tag = ref, "latest"
+	}
+	if !strings.Contains(repo, "/") || tag == "" {
+		return "", "", errOCIRef
+	}
+	return repo, tag, nil
+}
+
+// Download the single layer of an OCI artifact.  For image indexes, the manifest
+// matching the current platform is used.
+func (f *Fetcher) fetchOCI(ctx context.Context, ref string) ([]byte, error) {
+	repo, tag, err := splitOCIRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	registry, name, _ := strings.Cut(repo, "/")
+	o := &ociClient{f: f, base: "https://" + registry + "/v2/" + name}
+
+	m, err := o.manifest(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	if m.MediaType == mediaTypeOCIIndex || m.MediaType == mediaTypeDockerList || len(m.Manifests) > 0 {
+		desc, err := m.platform()
+		if err != nil {
+			return nil, err
+		}
+		if m, err = o.manifest(ctx, desc.Digest); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.Layers) == 0 {
+		return nil, errNoLayer
+	}
+	return o.blob(ctx, m.Layers[0].Digest)
+}
+
+func (m *ociManifest) platform() (*ociDescriptor, error) {
+	for i := range m.Manifests {
+		p := m.Manifests[i].Platform
+		if p != nil && p.OS == runtime.GOOS && p.Architecture == runtime.GOARCH {
+			return &m.Manifests[i], nil
+		}
+	}
+	return nil, errNoPlatform
+}
+
+type ociClient struct {
+	f     *Fetcher
+	base  string
+	token string
+}
+
+func (o *ociClient) manifest(ctx context.Context, ref string) (*ociManifest, error) {
+	accept := strings.Join([]string{mediaTypeOCIManifest, mediaTypeOCIIndex, mediaTypeDockerManifest, mediaTypeDockerList}, ", ")
+	data, err := o.get(ctx, o.base+"/manifests/"+ref, accept)
+	if err != nil {
+		return nil, err
+	}
+	m := &ociManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (o *ociClient) blob(ctx context.Context, digest string) ([]byte, error) {
+	algo, want, ok := strings.Cut(digest, ":")
+	if !ok || algo != "sha256" {
+		return nil, errUnsupported
+	}
+	data, err := o.get(ctx, o.base+"/blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != want {
+		return nil, errBlobDigest
+	}
+	return data, nil
+}
+
+// Perform a GET on the registry, requesting an anonymous token if the registry asks for one.
+func (o *ociClient) get(ctx context.Context, u, accept string) ([]byte, error) {
+	for retry := true; ; retry = false {
+		header := make(http.Header)
+		if accept != "" {
+			header.Set("Accept", accept)
+		}
+		if o.token != "" {
+			header.Set("Authorization", "Bearer "+o.token)
+		}
+		resp, err := o.f.get(ctx, u, header)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && retry {
+			challenge := resp.Header.Get("Www-Authenticate")
+			resp.Body.Close()
+			if o.token, err = o.authenticate(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Cannot download %s: %s", u, resp.Status)
+		}
+		return o.f.readBody(resp.Body)
+	}
+}
+
+// Obtain a token as described by a Bearer challenge.
+func (o *ociClient) authenticate(ctx context.Context, challenge string) (string, error) {
+	params, ok := strings.CutPrefix(challenge, "Bearer ")
+	if !ok {
+		return "", fmt.Errorf("Unsupported registry authentication: %s", challenge)
+	}
+	var realm string
+	query := make(url.Values)
+	for _, param := range strings.Split(params, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(v, `"`)
+		if k == "realm" {
+			realm = v
+		} else {
+			query.Set(k, v)
+		}
+	}
+	if realm == "" {
+		return "", fmt.Errorf("Unsupported registry authentication: %s", challenge)
+	}
+
+	data, err := o.f.fetchURL(ctx, realm+"?"+query.Encode())
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", err
+	}
+	if resp.Token != "" {
+		return resp.Token, nil
+	}
+	return resp.AccessToken, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// With the "fifo" protocol, the plugin creates a pair of FIFOs and announces their
+// common name as address: the host writes requests to "<addr>.in" and reads responses
+// from "<addr>.out".  A pair carries a single connection: the plugin removes the
+// FIFOs once the host has opened them.
+
+const (
+	fifoIn  = ".in"
+	fifoOut = ".out"
+)
+
+type fifoAddr string
+
+func (a fifoAddr) Network() string {
+	return "fifo"
+}
+
+func (a fifoAddr) String() string {
+	return string(a)
+}
+
+// Connection over a pair of FIFOs.
+type fifoConn struct {
+	r, w *os.File
+	addr fifoAddr
+}
+
+func (c *fifoConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *fifoConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+func (c *fifoConn) Close() error {
+	err := c.w.Close()
+	if rerr := c.r.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (c *fifoConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *fifoConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *fifoConn) SetDeadline(t time.Time) error {
+	if err := c.r.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.w.SetWriteDeadline(t)
+}
+
+func (c *fifoConn) SetReadDeadline(t time.Time) error {
+	return c.r.SetReadDeadline(t)
+}
+
+func (c *fifoConn) SetWriteDeadline(t time.Time) error {
+	return c.w.SetWriteDeadline(t)
+}
+
+// Listener accepting the single connection of a pair of FIFOs.
+type fifoListener struct {
+	addr     fifoAddr
+	accepted bool
+	closed   chan struct{}
+}
+
+func (l *fifoListener) Accept() (net.Conn, error) {
+	if l.accepted {
+		<-l.closed
+		return nil, net.ErrClosed
+	}
+	l.accepted = true
+	return acceptFifo(l.addr)
+}
+
+func (l *fifoListener) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *fifoListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package pingo
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Create the pair of FIFOs called addr.
+func listenFifo(addr string) (net.Listener, error) {
+	if err := syscall.Mkfifo(addr+fifoIn, 0600); err != nil {
+		return nil, err
+	}
+	if err := syscall.Mkfifo(addr+fifoOut, 0600); err != nil {
+		os.Remove(addr + fifoIn)
+		return nil, err
+	}
+	return &fifoListener{addr: fifoAddr(addr), closed: make(chan struct{})}, nil
+}
+
+// Open the plugin side of the FIFOs, waiting for the host to open its side.
+func acceptFifo(addr fifoAddr) (net.Conn, error) {
+	defer os.Remove(string(addr) + fifoIn)
+	defer os.Remove(string(addr) + fifoOut)
+
+	r, err := os.OpenFile(string(addr)+fifoIn, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	w, err := os.OpenFile(string(addr)+fifoOut, os.O_WRONLY, 0)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &fifoConn{r: r, w: w, addr: addr}, nil
+}
+
+// Open the host side of the FIFOs called addr, waiting at most timeout for the plugin
+// to open its side.
+func dialFifo(addr string, timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	var w *os.File
+	for {
+		// Fails until the plugin has opened the FIFO for reading
+		f, err := os.OpenFile(addr+fifoIn, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err == nil {
+			w = f
+			break
+		}
+		if !errors.Is(err, syscall.ENXIO) || time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Opening for reading waits for the plugin to open the FIFO for writing
+	type opened struct {
+		f   *os.File
+		err error
+	}
+	ch := make(chan opened, 1)
+	go func() {
+		f, err := os.OpenFile(addr+fifoOut, os.O_RDONLY, 0)
+		ch <- opened{f, err}
+	}()
+	select {
+	case o := <-ch:
+		if o.err != nil {
+			w.Close()
+			return nil, o.err
+		}
+		return &fifoConn{r: o.f, w: w, addr: fifoAddr(addr)}, nil
+	case <-time.After(time.Until(deadline)):
+		// Unblock the open by opening the other end
+		if f, err := os.OpenFile(addr+fifoOut, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			f.Close()
+		}
+		if o := <-ch; o.f != nil {
+			o.f.Close()
+		}
+		w.Close()
+		return nil, errors.New("Timeout opening " + addr + fifoOut)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package pingo
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var errFifoUnsupported = errors.New("FIFOs are not supported on Windows")
+
+func listenFifo(addr string) (net.Listener, error) {
+	return nil, errFifoUnsupported
+}
+
+func acceptFifo(addr fifoAddr) (net.Conn, error) {
+	return nil, errFifoUnsupported
+}
+
+func dialFifo(addr string, timeout time.Duration) (net.Conn, error) {
+	return nil, errFifoUnsupported
+}
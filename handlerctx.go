@@ -0,0 +1,61 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import "time"
+
+// HandlerContext tells where an error or a line of output passed to a ContextHandler
+// comes from.
+type HandlerContext struct {
+	// Name the plugin was added with to a Manager, or the path of its executable
+	Name string
+	// Process ID of the plugin, zero if it is not running
+	Pid   int
+	State State
+	Time  time.Time
+	// Stream a line of output was printed on, StreamStdout or StreamStderr; empty for
+	// errors and other messages
+	Stream string
+	// Line of output as printed by the plugin, before ANSI escape sequences are removed
+	Line string
+}
+
+// ContextHandler is implemented by error handlers that need to know which plugin each
+// error and line of output comes from, for example to route the output of many plugins
+// sharing a handler.  When the handler of a plugin implements it, ErrorContext and
+// PrintContext are called in place of Error, Print and PrintStream.
+type ContextHandler interface {
+	ErrorHandler
+	ErrorContext(ctx HandlerContext, err error)
+	PrintContext(ctx HandlerContext, v interface{})
+}
+
+// Pass the context of the plugin to a ContextHandler.
+type contextHandler struct {
+	p *Plugin
+	h ContextHandler
+}
+
+func (c *contextHandler) Error(err error) {
+	c.h.ErrorContext(c.p.handlerContext(), err)
+}
+
+func (c *contextHandler) Print(v interface{}) {
+	c.h.PrintContext(c.p.handlerContext(), v)
+}
+
+// Context of the messages passed to the handler now.
+func (p *Plugin) handlerContext() HandlerContext {
+	ctx := HandlerContext{
+		Name:  p.exe,
+		Pid:   int(p.pid.Load()),
+		State: p.State(),
+		Time:  time.Now(),
+	}
+	if s := p.sink.Load(); s != nil {
+		ctx.Name = s.name
+	}
+	return ctx
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type throttleKey struct {
+	isError bool
+	msg     string
+}
+
+// Number of repetitions of a message, and its first occurrence if it is an error.
+type throttled struct {
+	first   error
+	repeats int
+}
+
+// ThrottledHandler is an ErrorHandler that forwards to another handler, but
+// coalesces identical errors and lines received within a time window.
+//
+// The first occurrence is forwarded immediately; repetitions are counted and
+// reported as a single summary at the end of the window.  The summary of an error
+// wraps the first occurrence, so it can still be inspected with errors.As.
+type ThrottledHandler struct {
+	h      ErrorHandler
+	window time.Duration
+	clock  Clock
+	mux    sync.Mutex
+	counts map[throttleKey]*throttled
+	timer  Timer
+}
+
+// NewThrottledHandler returns a ThrottledHandler forwarding to h and summarizing
+// repetitions every window.
+func NewThrottledHandler(h ErrorHandler, window time.Duration) *ThrottledHandler {
+	return &ThrottledHandler{
+		h:      h,
+		window: window,
+		clock:  realClock{},
+		counts: make(map[throttleKey]*throttled),
+	}
+}
+
+// SetClock sets the clock measuring the windows, usually the clock of the plugin (see
+// Plugin.SetClock).  By default, the time package is used.
+//
+// Must be called before the handler is used.
+func (t *ThrottledHandler) SetClock(c Clock) {
+	t.clock = c
+}
+
+// Return true if the message was already seen in this window.
+func (t *ThrottledHandler) suppress(k throttleKey, err error) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if th, ok := t.counts[k]; ok {
+		th.repeats++
+		return true
+	}
+	t.counts[k] = &throttled{first: err}
+	if t.timer == nil {
+		timer := t.clock.NewTimer(t.window)
+		t.timer = timer
+		go func() {
+			<-timer.C()
+			t.flush()
+		}()
+	}
+	return false
+}
+
+func (t *ThrottledHandler) flush() {
+	t.mux.Lock()
+	counts := t.counts
+	t.counts = make(map[throttleKey]*throttled)
+	t.timer = nil
+	t.mux.Unlock()
+
+	for k, th := range counts {
+		if th.repeats == 0 {
+			continue
+		}
+		if k.isError {
+			t.h.Error(fmt.Errorf("%w (repeated %d times in %s)", th.first, th.repeats, t.window))
+		} else {
+			t.h.Print(fmt.Sprintf("%s (repeated %d times in %s)", k.msg, th.repeats, t.window))
+		}
+	}
+}
+
+// Error forwards err unless the same error was already reported in the current window.
+func (t *ThrottledHandler) Error(err error) {
+	if t.suppress(throttleKey{isError: true, msg: err.Error()}, err) {
+		return
+	}
+	t.h.Error(err)
+}
+
+// Print forwards s unless the same output was already printed in the current window.
+func (t *ThrottledHandler) Print(s interface{}) {
+	if t.suppress(throttleKey{msg: fmt.Sprint(s)}, nil) {
+		return
+	}
+	t.h.Print(s)
+}
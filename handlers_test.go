@@ -0,0 +1,59 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// Sends all errors and output to a channel.
+type chanHandler chan interface{}
+
+func (h chanHandler) Error(err error)     { h <- err }
+func (h chanHandler) Print(s interface{}) { h <- s }
+
+func TestThrottledHandler(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	out := make(chanHandler, 10)
+	h := NewThrottledHandler(out, time.Minute)
+	h.SetClock(clock)
+
+	typed := &exec.ExitError{}
+	for i := 0; i < 3; i++ {
+		h.Error(typed)
+		h.Print("line")
+	}
+	if first := <-out; first != typed {
+		t.Fatalf("first error forwarded as %v", first)
+	}
+	if first := <-out; first != "line" {
+		t.Fatalf("first line forwarded as %v", first)
+	}
+	select {
+	case v := <-out:
+		t.Fatalf("repetition %v forwarded before the end of the window", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	clock.Advance(time.Minute)
+	var summaries int
+	for summaries < 2 {
+		select {
+		case v := <-out:
+			summaries++
+			if err, ok := v.(error); ok {
+				var ee *exec.ExitError
+				if !errors.As(err, &ee) || ee != typed {
+					t.Fatalf("summary %v does not wrap the original error", err)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no summary at the end of the window")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import "sync"
+
+// HealthCheck is the result of a single check registered with AddHealthCheck.
+type HealthCheck struct {
+	Name string
+	// Error message returned by the check, empty if the check passed.
+	Error string
+}
+
+// Health is the health status reported by a plugin.
+type Health struct {
+	// True if the plugin is serving calls and all checks passed.
+	Ready  bool
+	Checks []HealthCheck
+}
+
+// Healthy returns true if all checks passed.
+func (h *Health) Healthy() bool {
+	for i := range h.Checks {
+		if h.Checks[i].Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+type healthCheck struct {
+	name string
+	fn   func() error
+}
+
+var (
+	healthMux    sync.Mutex
+	healthChecks []healthCheck
+)
+
+// AddHealthCheck registers a named check that is run each time the host asks
+// for the health status of this plugin.  A check fails by returning an error.
+func AddHealthCheck(name string, check func() error) {
+	healthMux.Lock()
+	defer healthMux.Unlock()
+	healthChecks = append(healthChecks, healthCheck{name: name, fn: check})
+}
+
+func runHealthChecks() *Health {
+	healthMux.Lock()
+	checks := make([]healthCheck, len(healthChecks))
+	copy(checks, healthChecks)
+	healthMux.Unlock()
+
+	h := &Health{Checks: make([]HealthCheck, len(checks))}
+	for i, c := range checks {
+		h.Checks[i].Name = c.name
+		if err := c.fn(); err != nil {
+			h.Checks[i].Error = err.Error()
+		}
+	}
+	h.Ready = defaultServer.running && h.Healthy()
+	return h
+}
+
+// Internal RPC call returning the result of all health checks. Do not call manually.
+func (s *PingoRpc) Health(unused int, h *Health) error {
+	*h = *runHealthChecks()
+	return nil
+}
+
+// Internal object exporting the health status for hosts built with older versions
+// of this package.
+type PingoHealth struct{}
+
+// Internal RPC call returning the result of all health checks. Do not call manually.
+func (s *PingoHealth) Status(unused int, h *Health) error {
+	*h = *runHealthChecks()
+	return nil
+}
+
+// Internal RPC call returning true if the plugin is ready. Do not call manually.
+func (s *PingoHealth) Ready(unused int, ready *bool) error {
+	*ready = runHealthChecks().Ready
+	return nil
+}
+
+// Health asks the plugin for its health status, running all checks the plugin
+// registered via AddHealthCheck.
+//
+// Like Call, Health will hang until the plugin has been initialized.
+func (p *Plugin) Health() (*Health, error) {
+	h := &Health{}
+	if err := p.Call(internalObject+".Health", 0, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
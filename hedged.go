@@ -0,0 +1,91 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"time"
+)
+
+// Caller racing redundant plugins.
+type hedged struct {
+	callers []Caller
+	delay   time.Duration
+}
+
+// Hedged returns a caller performing calls on the first of callers and, each time no
+// answer arrives within delay, also on the next one.  The first answer is returned and
+// the calls still running are cancelled, see CallContext.  A call failing without an
+// answer, for example because the plugin is not running, is performed on the next
+// caller right away.  If no caller answers, the last error is returned.
+//
+// Use it with redundant instances of a plugin to cut the latency of slow calls, for
+// methods that can safely run more than once.
+//
+// Panics if callers is empty.
+func Hedged(callers []Caller, delay time.Duration) Caller {
+	if len(callers) == 0 {
+		panic("No callers passed to Hedged")
+	}
+	return &hedged{callers: append([]Caller(nil), callers...), delay: delay}
+}
+
+// Result of a call performed by a hedged caller.
+type hedgedResult struct {
+	reply interface{}
+	err   error
+}
+
+func (h *hedged) Call(name string, args interface{}, resp interface{}) error {
+	return h.CallContext(context.Background(), name, args, resp)
+}
+
+func (h *hedged) CallContext(ctx context.Context, name string, args interface{}, resp interface{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each call has its own reply, as calls answering late must not change resp
+	results := make(chan hedgedResult, len(h.callers))
+	next, running := 0, 0
+	perform := func() {
+		c, reply := h.callers[next], newReply(resp)
+		next++
+		running++
+		go func() {
+			results <- hedgedResult{reply: reply, err: c.CallContext(ctx, name, args, reply)}
+		}()
+	}
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+
+	perform()
+	var err error
+	for running > 0 {
+		var expired <-chan time.Time
+		if next < len(h.callers) {
+			expired = timer.C
+		}
+		select {
+		case res := <-results:
+			running--
+			if answered(res.err) {
+				if res.err == nil {
+					setReply(resp, res.reply)
+				}
+				return res.err
+			}
+			err = res.err
+			if next < len(h.callers) {
+				perform()
+				timer.Reset(h.delay)
+			}
+		case <-expired:
+			perform()
+			timer.Reset(h.delay)
+		}
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"os"
+)
+
+// HostInfo describes the host application to its plugins.
+type HostInfo struct {
+	// Name and version of the host application
+	Name    string
+	Version string
+	// Pid of the host process, set automatically
+	Pid int
+	// Locale selected by the host, for example "en_US"
+	Locale string
+	// Feature flags enabled in the host
+	Features []string
+}
+
+// SetHostInfo makes the host pass info to the plugin when starting it.  The plugin
+// can read it with Host.
+//
+// Panics if called after Start.
+func (p *Plugin) SetHostInfo(info HostInfo) {
+	if p.running {
+		panic("Cannot call SetHostInfo after Start")
+	}
+	info.Pid = os.Getpid()
+	p.hostInfo = &info
+}
+
+func (h *HostInfo) encode() (string, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+func decodeHostInfo(s string) (*HostInfo, error) {
+	data, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	h := &HostInfo{}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Host returns the information about the host application that started this plugin.
+// Returns false if the host has not set any information (see Plugin.SetHostInfo) or it
+// cannot be decoded.
+func Host() (HostInfo, bool) {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	if defaultServer.conf.host == "" {
+		return HostInfo{}, false
+	}
+	h, err := decodeHostInfo(defaultServer.conf.host)
+	if err != nil {
+		return HostInfo{}, false
+	}
+	return *h, true
+}
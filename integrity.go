@@ -0,0 +1,107 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// SetChecksum makes the plugin verify that the SHA-256 checksum of its executable is
+// sum, in hex, right before starting it.  If the executable has been changed, the start
+// fails with ErrIntegrity.  See ExecutableChecksum.
+//
+// Panics if called after Start.
+func (p *Plugin) SetChecksum(sum string) {
+	if p.running {
+		panic("Cannot call SetChecksum after Start")
+	}
+	p.checksum = strings.ToLower(sum)
+}
+
+// ExecutableChecksum returns the SHA-256 checksum of the file at path in hex, as
+// expected by SetChecksum.
+func ExecutableChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func verifyExecutable(path, sum string) error {
+	actual, err := ExecutableChecksum(path)
+	if err != nil {
+		return err
+	}
+	if actual != sum {
+		return ErrIntegrity(errors.New("Executable " + path + " does not match its checksum"))
+	}
+	return nil
+}
+
+// IntegrityPolicy tells how a Manager handles executables that change between
+// plugins added with the same name.
+type IntegrityPolicy int
+
+const (
+	// Executables are not checked
+	IntegrityOff IntegrityPolicy = iota
+	// Refuse to start plugins whose executable changed since the first plugin
+	// with the same name was added
+	IntegrityPin
+	// Accept a changed executable as an upgrade and pin it from then on.  Upgrades
+	// are reported to the ErrorHandler of the plugin.
+	IntegrityUpgrade
+)
+
+// SetIntegrityPolicy makes the manager remember the checksum of the executable of the
+// first plugin added under each name, and check plugins added later under the same
+// name against it, like when relaunching a plugin that stopped.  This guards against
+// executables replaced on disk while the host is running.
+//
+// Each plugin is also checked with SetChecksum when it starts, so an executable changed
+// after adding its plugin is refused whatever the policy.  Only plugins added after this
+// call are checked.
+func (m *Manager) SetIntegrityPolicy(policy IntegrityPolicy) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.integrity = policy
+}
+
+// Check the executable of p against the one pinned for name.  Must be called
+// with m.mux held.
+func (m *Manager) pinExecutable(name string, p *Plugin) {
+	if m.integrity == IntegrityOff || p.running || p.attachTo != "" {
+		return
+	}
+	sum, err := ExecutableChecksum(p.exe)
+	if err != nil {
+		// Starting the plugin fails as well
+		return
+	}
+	pinned, ok := m.pins[name]
+	switch {
+	case !ok:
+		m.pins[name] = sum
+	case pinned != sum && m.integrity == IntegrityUpgrade:
+		p.errorHandler().Print("Executable of plugin " + name + " changed, accepted as upgrade")
+		m.pins[name] = sum
+	default:
+		// Fails the start if the executable changed
+		sum = pinned
+	}
+	p.checksum = sum
+}
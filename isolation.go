@@ -0,0 +1,68 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Variables set by the host for the plugin, always kept.
+var isolationEnv = []string{"LISTEN_FDS", "LISTEN_FDNAMES", controlKeyEnv}
+
+// StrictIsolation is a Sandbox starting plugins with the minimum inherited from the
+// host: the plugin only gets its standard streams and the descriptors passed by the
+// host (see SetSocketActivation and SetSignedControl), and an empty environment
+// except for the variables explicitly kept.  This prevents plugins from inheriting
+// sockets, files or secrets of the host by accident.
+//
+// Processes started by the exec package only inherit their standard streams and the
+// descriptors in ExtraFiles, which Wrap expects to hold only those passed by the host;
+// command modifiers adding descriptors are applied after the isolation.
+type StrictIsolation struct {
+	// Names of environment variables of the host passed to the plugin, for example PATH
+	KeepEnv []string
+	// Additional environment variables, as "key=value"
+	Env []string
+	// Run the plugin in a new network namespace, with no network interface
+	// configured.  The plugin can only be reached via the unix protocol or with
+	// SetSocketActivation.  Only supported on Linux.
+	NoNetwork bool
+	// Sandbox applied after the isolation, if set
+	Sandbox Sandbox
+}
+
+// Wrap restricts what cmd inherits from the host.
+func (s *StrictIsolation) Wrap(cmd *exec.Cmd) error {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	keep := make(map[string]bool, len(s.KeepEnv)+len(isolationEnv))
+	for _, name := range isolationEnv {
+		keep[name] = true
+	}
+	for _, name := range s.KeepEnv {
+		keep[name] = true
+	}
+	cmd.Env = make([]string, 0, len(keep)+len(s.Env))
+	for _, kv := range env {
+		if name, _, _ := strings.Cut(kv, "="); keep[name] {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, s.Env...)
+
+	if s.NoNetwork {
+		if err := isolateNetwork(cmd); err != nil {
+			return err
+		}
+	}
+	if s.Sandbox != nil {
+		return s.Sandbox.Wrap(cmd)
+	}
+	return nil
+}
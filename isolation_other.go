@@ -0,0 +1,17 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package pingo
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func isolateNetwork(cmd *exec.Cmd) error {
+	return errors.New("Network isolation is only supported on Linux")
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+var errKeepAliveTimeout = ErrIOTimeout(errors.New("Keepalive ping not answered in time"))
+
+// SetKeepAlive makes the host ping the plugin every interval once it is ready.  If a
+// ping fails or is not answered before the next one is due, the plugin is considered
+// dead: calls will fail with the error and the process is killed.
+//
+// The interval is also used for TCP keepalive on both ends of the connection.
+// Zero disables pings, which is the default.
+//
+// Panics if called after Start.
+func (p *Plugin) SetKeepAlive(interval time.Duration) {
+	if p.running {
+		panic("Cannot call SetKeepAlive after Start")
+	}
+	p.keepAlive = interval
+}
+
+func (c *ctrl) startKeepAlive() {
+	if c.p.keepAlive <= 0 {
+		return
+	}
+	c.keepalive = c.p.clock.NewTicker(c.p.keepAlive)
+	c.keepaliveCh = c.keepalive.C()
+	c.pingCh = make(chan error, 1)
+}
+
+func (c *ctrl) stopKeepAlive() {
+	if c.keepalive == nil {
+		return
+	}
+	c.keepalive.Stop()
+	c.keepalive = nil
+	c.keepaliveCh = nil
+	c.pingCh = nil
+}
+
+// Called by the control loop on each tick.
+func (c *ctrl) ping() {
+	if c.pinging {
+		c.stopKeepAlive()
+		c.fatal(errKeepAliveTimeout)
+		return
+	}
+	c.pinging = true
+
+	c.wg.Add(1)
+	go func(client *rpc.Client, dc *deadlineConn, ch chan<- error) {
+		defer c.wg.Done()
+		defer dc.end(dc.begin())
+		ch <- wrapIOError(client.Call(internalObject+".Ping", 0, nil))
+	}(c.client, c.dc, c.pingCh)
+}
+
+// Called by the control loop when a ping returned.
+func (c *ctrl) pong(err error) {
+	c.pinging = false
+	if err != nil {
+		c.stopKeepAlive()
+		c.p.errorHandler().Error(err)
+		c.fatal(err)
+	}
+}
+
+// Enable TCP keepalive on conn, if it is a TCP connection.
+func setTCPKeepAlive(conn net.Conn, interval time.Duration) {
+	if tc, ok := conn.(*net.TCPConn); ok && interval > 0 {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(interval)
+	}
+}
+
+// Internal RPC call used by the host to check the plugin is alive. Do not call manually.
+func (s *PingoRpc) Ping(unused int, unused2 *int) error {
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"time"
+)
+
+// SetLease makes the host hold a lease of ttl on the plugin once it is ready, renewing
+// it every third of ttl.  If renewals stop, for example because the host vanished, the
+// plugin exits after the lease and a grace period have passed (see SetLeaseGrace).
+//
+// Leases are useful for plugins the host did not start, see Attach.  Failed renewals are
+// reported to the ErrorHandler.  Zero disables the lease, which is the default.
+//
+// Panics if called after Start.
+func (p *Plugin) SetLease(ttl time.Duration) {
+	if p.running {
+		panic("Cannot call SetLease after Start")
+	}
+	p.lease = ttl
+}
+
+func (c *ctrl) startLease() {
+	if c.p.lease <= 0 || c.leaseStop != nil {
+		return
+	}
+	c.leaseStop = make(chan struct{})
+
+	c.wg.Add(1)
+	go func(conn *conn, stop <-chan struct{}) {
+		defer c.wg.Done()
+
+		ticker := c.p.clock.NewTicker(c.p.lease / 3)
+		defer ticker.Stop()
+
+		for {
+			start := conn.dc.begin()
+			err := conn.client.Call(internalObject+".RenewLease", int64(c.p.lease), nil)
+			conn.dc.end(start)
+			if err != nil {
+				c.p.errorHandler().Error(wrapIOError(err))
+			}
+
+			select {
+			case <-ticker.C():
+			case <-stop:
+				return
+			}
+		}
+	}(&conn{client: c.client, dc: c.dc}, c.leaseStop)
+}
+
+func (c *ctrl) stopLease() {
+	if c.leaseStop == nil {
+		return
+	}
+	close(c.leaseStop)
+	c.leaseStop = nil
+}
+
+// SetLeaseGrace sets how long the plugin keeps running after a lease held by the host
+// expired.  By default, the grace period is as long as the lease.
+//
+// SetLeaseGrace will panic if called after Run.
+func SetLeaseGrace(grace time.Duration) {
+	if defaultServer.running {
+		panic("Do not call SetLeaseGrace after Run")
+	}
+	defaultServer.leaseGrace = grace
+}
+
+// Internal RPC call to renew the lease of the host. Do not call manually.
+func (s *PingoRpc) RenewLease(ttl int64, unused *int) error {
+	r := defaultServer
+	d := time.Duration(ttl)
+	if r.leaseGrace > 0 {
+		d += r.leaseGrace
+	} else {
+		d *= 2
+	}
+	if s.client != "" {
+		r.renewClientLease(s.client, d)
+		return nil
+	}
+
+	r.leaseMux.Lock()
+	defer r.leaseMux.Unlock()
+
+	if r.leaseTimer == nil {
+		r.leaseTimer = time.AfterFunc(d, func() {
+			meta(r.conf.prefix).output("error", "Lease expired, exiting")
+			os.Exit(0)
+		})
+	} else {
+		r.leaseTimer.Reset(d)
+	}
+	return nil
+}
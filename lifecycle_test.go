@@ -0,0 +1,199 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Directory containing the fixture plugins built by TestMain
+var fixtureDir string
+
+// Fixture plugins, from the examples directory
+var fixtures = []string{
+	"pingo-hello-world",
+	"pingo-slow-start",
+	"pingo-crash",
+	"pingo-garbage",
+	"pingo-sleep",
+	"pingo-never-exit",
+	"pingo-busy",
+	"pingo-delegate",
+	"pingo-silent",
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "pingo-fixtures")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	fixtureDir = dir
+
+	for _, name := range fixtures {
+		cmd := exec.Command("go", "build", "-o", fixturePath(name), "./examples/"+name)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "building fixture %s: %s\n%s", name, err, out)
+			return 1
+		}
+	}
+	return m.Run()
+}
+
+// Path of the fixture plugin with name.
+func fixturePath(name string) string {
+	exe := filepath.Join(fixtureDir, name)
+	if runtime.GOOS == "windows" {
+		exe += ".exe"
+	}
+	return exe
+}
+
+// Discards all plugin output.
+type quietHandler struct{}
+
+func (h quietHandler) Error(err error)   {}
+func (h quietHandler) Print(interface{}) {}
+
+// Returns a plugin running the fixture with name over proto, not yet started.
+func newFixture(t testing.TB, proto, name string) *Plugin {
+	p := NewPlugin(proto, fixturePath(name))
+	p.SetErrorHandler(quietHandler{})
+	p.SetTimeout(3 * time.Second)
+	return p
+}
+
+func sayHello(p *Plugin) error {
+	var resp string
+	if err := p.Call("Plugin.SayHello", "pingo", &resp); err != nil {
+		return err
+	}
+	if resp != "Hello pingo" {
+		return fmt.Errorf("unexpected response %q", resp)
+	}
+	return nil
+}
+
+var lifecycleCases = []struct {
+	name   string
+	plugin string
+	run    func(p *Plugin) error
+}{
+	{"well-behaved", "pingo-hello-world", func(p *Plugin) error {
+		objs, err := p.Objects()
+		if err != nil {
+			return err
+		}
+		if len(objs) != 1 || objs[0] != "Plugin" {
+			return fmt.Errorf("unexpected objects %v", objs)
+		}
+		for i := 0; i < 10; i++ {
+			if err := sayHello(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"slow-start", "pingo-slow-start", sayHello},
+	{"garbage-output", "pingo-garbage", sayHello},
+	{"never-ready", "pingo-sleep", func(p *Plugin) error {
+		if err := sayHello(p); err == nil {
+			return errors.New("call succeeded on a plugin that never registered")
+		}
+		if p.State() != StateFailed {
+			return fmt.Errorf("unexpected state %s", p.State())
+		}
+		return nil
+	}},
+	{"crash-on-call", "pingo-crash", func(p *Plugin) error {
+		if err := p.Call("Plugin.Crash", 3, nil); err == nil {
+			return errors.New("call to a crashing method succeeded")
+		}
+		if err := p.Call("Plugin.Crash", 3, nil); err == nil {
+			return errors.New("call to a crashed plugin succeeded")
+		}
+		return nil
+	}},
+	{"never-exit", "pingo-never-exit", func(p *Plugin) error {
+		if err := sayHello(p); err == nil {
+			return errors.New("call succeeded on a plugin that never registered")
+		}
+		return nil
+	}},
+	{"never-answers", "pingo-silent", func(p *Plugin) error {
+		if err := sayHello(p); err == nil {
+			return errors.New("call succeeded on a plugin that never answers")
+		}
+		return nil
+	}},
+	{"missing-binary", "pingo-does-not-exist", func(p *Plugin) error {
+		if err := sayHello(p); err == nil {
+			return errors.New("call to a missing plugin succeeded")
+		}
+		return nil
+	}},
+}
+
+func TestLifecycle(t *testing.T) {
+	const timeout = 10 * time.Second
+
+	for _, proto := range []string{"unix", "tcp"} {
+		for _, c := range lifecycleCases {
+			t.Run(proto+"/"+c.name, func(t *testing.T) {
+				t.Parallel()
+
+				p := newFixture(t, proto, c.plugin)
+				errCh := make(chan error, 1)
+				var pid int
+				go func() {
+					p.Start()
+					for p.pid.Load() == 0 && p.State() == StateStarting {
+						time.Sleep(10 * time.Millisecond)
+					}
+					pid = int(p.pid.Load())
+					err := c.run(p)
+					p.Stop()
+					errCh <- err
+				}()
+
+				select {
+				case err := <-errCh:
+					if err != nil {
+						t.Fatal(err)
+					}
+				case <-time.After(timeout):
+					t.Fatal("timed out")
+				}
+
+				select {
+				case <-p.Done():
+				case <-time.After(timeout):
+					t.Fatal("resources not released after Stop")
+				}
+				if p.State() != StateStopped {
+					t.Fatalf("state after Stop is %s", p.State())
+				}
+				if c.plugin != "pingo-does-not-exist" && pid == 0 {
+					t.Fatal("no process started")
+				}
+				if pid != 0 && processAlive(pid) {
+					t.Fatalf("process %d still running after Stop", pid)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errMessageTooLarge = ErrMessageTooLarge(errors.New("Received message exceeds size limit"))
+
+// SetMessageLimits sets the maximum size in bytes of encoded requests sent to the plugin
+// and of responses received from it.  Oversized requests are not sent and the call
+// returns ErrMessageTooLarge.  Oversized responses are rejected before being read,
+// and the connection is closed.  Zero means no limit, the default.
+//
+// Panics if called after Start.
+func (p *Plugin) SetMessageLimits(maxRequest, maxResponse int) {
+	if p.running {
+		panic("Cannot call SetMessageLimits after Start")
+	}
+	p.maxRequest = maxRequest
+	p.maxResponse = maxResponse
+}
+
+// SetMessageLimits sets the maximum size in bytes of encoded requests received from
+// the host and of responses sent to it.  Oversized requests are rejected before being
+// read, and the connection is closed.  Oversized responses are replaced by an error
+// that the host receives as ErrMessageTooLarge.  Zero means no limit, the default.
+//
+// SetMessageLimits will panic if called after Run.
+func SetMessageLimits(maxRequest, maxResponse int) {
+	if defaultServer.running {
+		panic("Do not call SetMessageLimits after Run")
+	}
+	defaultServer.maxRequest = maxRequest
+	defaultServer.maxResponse = maxResponse
+}
+
+func (p *Plugin) checkRequestSize(args interface{}) error {
+	if p.maxRequest <= 0 {
+		return nil
+	}
+	if n, err := encodedSize(args); err == nil && n > p.maxRequest {
+		return ErrMessageTooLarge(fmt.Errorf("Request of %d bytes exceeds limit of %d", n, p.maxRequest))
+	}
+	return nil
+}
+
+// Reader of a gob stream that fails when a message is larger than max,
+// before the decoder allocates memory for it.
+type limitReader struct {
+	r   io.Reader
+	max uint64
+	// Length prefix not yet passed to the decoder
+	head []byte
+	// Bytes left in the current message
+	left uint64
+}
+
+func newLimitReader(r io.Reader, max int) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return &limitReader{r: r, max: uint64(max)}
+}
+
+// Read the length prefix of the next message, encoded as a gob uint.
+func (l *limitReader) readHead() error {
+	var buf [9]byte
+	if _, err := io.ReadFull(l.r, buf[:1]); err != nil {
+		return err
+	}
+	size := uint64(buf[0])
+	n := 1
+	if buf[0] >= 0x80 {
+		n = 1 + int(-int8(buf[0]))
+		if n > len(buf) {
+			return errors.New("Invalid message length")
+		}
+		if _, err := io.ReadFull(l.r, buf[1:n]); err != nil {
+			return err
+		}
+		var be [8]byte
+		copy(be[8-(n-1):], buf[1:n])
+		size = binary.BigEndian.Uint64(be[:])
+	}
+	if size > l.max {
+		return errMessageTooLarge
+	}
+	l.head = append(l.head[:0], buf[:n]...)
+	l.left = size
+	return nil
+}
+
+func (l *limitReader) Read(data []byte) (int, error) {
+	if len(l.head) == 0 && l.left == 0 {
+		if err := l.readHead(); err != nil {
+			return 0, err
+		}
+	}
+	if len(l.head) > 0 {
+		n := copy(data, l.head)
+		l.head = l.head[n:]
+		return n, nil
+	}
+	if uint64(len(data)) > l.left {
+		data = data[:l.left]
+	}
+	n, err := l.r.Read(data)
+	l.left -= uint64(n)
+	return n, err
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"sync"
+	"time"
+)
+
+// Manager keeps track of a group of plugins, identified by name.
+type Manager struct {
+	mux     sync.Mutex
+	plugins map[string]*Plugin
+	// Names in order of addition
+	names  []string
+	reaped chan ReapedProcess
+	events *eventQueue
+	// Recent startup times by name
+	startups map[string][]time.Duration
+	adaptive *adaptiveTimeout
+	// Checksums of executables by name
+	pins      map[string]string
+	integrity IntegrityPolicy
+	// Creates plugins restarted through Handler
+	restartFn func(name string, old *Plugin) *Plugin
+	// Plugins used by each plugin, by name
+	deps map[string][]string
+}
+
+// NewManager creates an empty Manager.
+func NewManager() *Manager {
+	return &Manager{
+		plugins:  make(map[string]*Plugin),
+		reaped:   make(chan ReapedProcess, 64),
+		events:   &eventQueue{ch: make(chan Event, eventsBuffer)},
+		startups: make(map[string][]time.Duration),
+		pins:     make(map[string]string),
+		deps:     make(map[string][]string),
+	}
+}
+
+// Add a plugin to the manager under name.
+//
+// Panics if a plugin with the same name has already been added.
+func (m *Manager) Add(name string, p *Plugin) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, ok := m.plugins[name]; ok {
+		panic("Plugin " + name + " already added to Manager")
+	}
+	m.plugins[name] = p
+	m.names = append(m.names, name)
+	m.prepare(name, p)
+}
+
+// Set up p to be managed as name.  Must be called with m.mux held.
+func (m *Manager) prepare(name string, p *Plugin) {
+	m.adaptTimeout(name, p)
+	m.pinExecutable(name, p)
+	m.reportEvents(name, p)
+}
+
+// Remove the plugin with the given name from the manager.  The plugin is not stopped.
+func (m *Manager) Remove(name string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	p, ok := m.plugins[name]
+	if !ok {
+		return
+	}
+	m.ignoreEvents(p)
+	delete(m.plugins, name)
+	for i := range m.names {
+		if m.names[i] == name {
+			m.names = append(m.names[:i], m.names[i+1:]...)
+			break
+		}
+	}
+}
+
+// Plugin returns the plugin added with the given name or nil.
+func (m *Manager) Plugin(name string) *Plugin {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.plugins[name]
+}
+
+// Names returns the names of all plugins, in the order they were added.
+func (m *Manager) Names() []string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	names := make([]string, len(m.names))
+	copy(names, m.names)
+	return names
+}
+
+// EnableSubreaper makes the host process adopt orphaned descendants of its plugins
+// and reap them when they exit.  Use it when the host runs as PID 1 in a container.
+// The exit statuses of reaped processes are reported via Reaped.
+//
+// Descendants are found by scanning the processes of the system while their parent
+// runs: processes orphaned within a fraction of a second of being started may be
+// missed.  Children the host started itself are never reaped.
+//
+// Only supported on Linux.
+func (m *Manager) EnableSubreaper() error {
+	if err := startReaper(); err != nil {
+		return err
+	}
+	addReapListener(m.reaped)
+	return nil
+}
+
+// Reaped returns the channel on which orphaned processes reaped by the host are reported.
+// If the channel is not read, reports are dropped once its buffer is full.
+func (m *Manager) Reaped() <-chan ReapedProcess {
+	return m.reaped
+}
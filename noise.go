@@ -0,0 +1,71 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SetStartupNoise makes the host tolerate output that the plugin prints before it is
+// ready, like the banner of a third-party program calling Run.  Control messages are
+// then recognized even if preceded by other output on the same line, and lines of any
+// length are read.  The first limit bytes of other output are passed to the
+// ErrorHandler, unless forward is false; the rest is dropped.  Either way, the plugin
+// only fails to start if it does not become ready before the timeout.
+//
+// A zero limit disables this mode, which is the default.
+//
+// Panics if called after Start.
+func (p *Plugin) SetStartupNoise(limit int, forward bool) {
+	if p.running {
+		panic("Cannot call SetStartupNoise after Start")
+	}
+	p.noise = limit
+	p.noiseFwd = forward
+}
+
+// Handle output that is not a control message.
+func (c *ctrl) plainOutput(line, stream string) {
+	c.p.outputLine(line)
+	if c.p.noise <= 0 || c.p.State() != StateStarting {
+		c.p.printOutput(line, stream)
+		return
+	}
+	if c.noise > c.p.noise {
+		return
+	}
+	c.noise += len(line) + 1
+	if c.noise > c.p.noise {
+		c.p.errorHandler().Error(errors.New("Dropping startup output after " + strconv.Itoa(c.p.noise) + " bytes"))
+		return
+	}
+	if c.p.noiseFwd {
+		c.p.printOutput(line, stream)
+	}
+}
+
+// Split off output printed before a control message on the same line.
+func (c *ctrl) splitNoise(line string) []string {
+	if c.p.noise <= 0 || c.p.State() != StateStarting || c.p.meta.matches(line) {
+		return []string{line}
+	}
+	i := strings.Index(line, string(c.p.meta)+": ")
+	if i < 0 {
+		return []string{line}
+	}
+	return []string{line[:i], line[i:]}
+}
+
+// Like bufio.ScanLines, but long lines are split instead of failing.
+func scanLongLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 && err == nil && len(data) >= bufio.MaxScanTokenSize {
+		return len(data), data, nil
+	}
+	return advance, token, err
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"net/rpc"
+	"reflect"
+	"sort"
+)
+
+// ObjectInfo describes an object exported by a plugin.
+type ObjectInfo struct {
+	Name string
+	// Methods that can be called, sorted by name
+	Methods []string
+}
+
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
+// Describe the methods of t that the rpc package can call.
+func rpcMethods(t reflect.Type) []string {
+	methods := make([]string, 0)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		mt := m.Type
+		if !m.IsExported() || mt.NumIn() != 3 || mt.NumOut() != 1 {
+			continue
+		}
+		if mt.In(2).Kind() != reflect.Pointer || mt.Out(0) != typeOfError {
+			continue
+		}
+		methods = append(methods, m.Name)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// ObjectInfo returns the objects exported by the plugin with their methods.  Exported
+// objects used internally are not reported.
+//
+// Like Objects, ObjectInfo waits for the plugin to be initialized.  Plugins built with
+// older versions of this package report no methods.
+func (p *Plugin) ObjectInfo() ([]ObjectInfo, error) {
+	objects, err := p.requestObjects()
+	if err != nil {
+		return nil, err
+	}
+	return objects.info, nil
+}
+
+// Ask the objects of the plugin via the internal RPC.  The control loop keeps running
+// meanwhile and passes the answer to gotObjects.
+func (c *ctrl) requestObjects() {
+	c.handshake(internalObject+".ListObjects", &[]ObjectInfo{})
+}
+
+// Record the objects of the plugin reported by call.  Plugins built with older
+// versions only report their objects with the "objects" line, before "ready".
+func (c *ctrl) gotObjects(call *rpc.Call) bool {
+	err := call.Error
+	if err == nil {
+		info := *call.Reply.(*[]ObjectInfo)
+		c.objs = make([]ObjectInfo, 0, len(info))
+		for i := range info {
+			if !isInternalObject(info[i].Name) {
+				c.objs = append(c.objs, info[i])
+			}
+		}
+		if c.timings.Objects == 0 {
+			c.timings.Objects = c.elapsed()
+		}
+		return true
+	}
+	if c.objs != nil {
+		return true
+	}
+	c.fatal(ErrInvalidMessage(errors.New("Plugin did not report its objects: " + err.Error())))
+	return false
+}
+
+// Internal RPC call to list the exported objects. Do not call manually.
+func (s *PingoRpc) ListObjects(unused int, objs *[]ObjectInfo) error {
+	*objs = defaultServer.info
+	return nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotatePolicy specifies when a plugin output file is rotated.  Fields left
+// to zero disable the corresponding rule.
+type RotatePolicy struct {
+	// Rotate when the file would grow beyond MaxSize bytes
+	MaxSize int64
+	// Rotate when the file has been open for longer than MaxAge
+	MaxAge time.Duration
+	// Number of rotated files to keep.  Zero keeps all of them.
+	MaxBackups int
+}
+
+// Suffix appended to rotated files, sortable by time.
+const rotateSuffixFormat = "20060102-150405.000000000"
+
+type outputFile struct {
+	mux     sync.Mutex
+	path    string
+	policy  RotatePolicy
+	file    *os.File
+	size    int64
+	created time.Time
+	closed  bool
+	// Measures the age of the file
+	clock Clock
+}
+
+func newOutputFile(path string, policy RotatePolicy, clock Clock) (*outputFile, error) {
+	o := &outputFile{path: path, policy: policy, clock: clock}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *outputFile) open() error {
+	f, err := os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	o.file = f
+	o.size = info.Size()
+	o.created = o.clock.Now()
+	return nil
+}
+
+// Measure the age of the file with c from now on.
+func (o *outputFile) setClock(c Clock) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.clock = c
+	o.created = c.Now()
+}
+
+func (o *outputFile) needsRotate(n int) bool {
+	if o.policy.MaxSize > 0 && o.size > 0 && o.size+int64(n) > o.policy.MaxSize {
+		return true
+	}
+	if o.policy.MaxAge > 0 && o.clock.Now().Sub(o.created) > o.policy.MaxAge {
+		return true
+	}
+	return false
+}
+
+// Move the file aside and open a new one.  Whatever fails, output goes on to a file at
+// the original path if it can be opened.
+func (o *outputFile) rotate() error {
+	closeErr := o.file.Close()
+	o.file = nil
+	renameErr := os.Rename(o.path, o.path+"."+o.clock.Now().Format(rotateSuffixFormat))
+	if err := o.open(); err != nil {
+		return err
+	}
+	if err := errors.Join(closeErr, renameErr); err != nil {
+		// Not rotated: try again after another period, not at each write
+		o.size = 0
+		return err
+	}
+	return o.prune()
+}
+
+// Remove the oldest rotated files exceeding MaxBackups
+func (o *outputFile) prune() error {
+	if o.policy.MaxBackups <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Dir(o.path))
+	if err != nil {
+		return err
+	}
+	// Only files named by rotate, not others sharing the prefix
+	prefix := filepath.Base(o.path) + "."
+	var old []string
+	for _, e := range entries {
+		suffix, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(rotateSuffixFormat, suffix); err == nil {
+			old = append(old, e.Name())
+		}
+	}
+	if len(old) <= o.policy.MaxBackups {
+		return nil
+	}
+	sort.Strings(old)
+	for _, name := range old[:len(old)-o.policy.MaxBackups] {
+		if err := os.Remove(filepath.Join(filepath.Dir(o.path), name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *outputFile) Write(data []byte) (int, error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	if o.closed {
+		return 0, os.ErrClosed
+	}
+	// Reopen a file that could not be opened after a rotation
+	if o.file == nil {
+		if err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+	var rotateErr error
+	if o.needsRotate(len(data)) {
+		if rotateErr = o.rotate(); o.file == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := o.file.Write(data)
+	o.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (o *outputFile) writeLine(line string) error {
+	_, err := o.Write([]byte(line + "\n"))
+	return err
+}
+
+func (o *outputFile) Close() error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.closed = true
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+// Lines of output kept by each plugin, see OutputTail
+const (
+	outputTailLines = 100
+	// Longer lines are truncated
+	outputTailLen = 1024
+	// Lines buffered for each subscriber, later ones are dropped
+	outputSubBuffer = 64
+)
+
+// Last lines of output of a plugin.
+type outputTail struct {
+	mux   sync.Mutex
+	lines []string
+	next  int
+	// Receive lines as they are added
+	subs map[chan string]struct{}
+}
+
+func (t *outputTail) add(line string) {
+	line = truncate(line, outputTailLen)
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for ch := range t.subs {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+	if len(t.lines) < outputTailLines {
+		t.lines = append(t.lines, line)
+		return
+	}
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % outputTailLines
+}
+
+func (t *outputTail) get() []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.snapshot()
+}
+
+// Return the current lines and a channel receiving the following ones, until the
+// returned function is called.
+func (t *outputTail) subscribe() ([]string, <-chan string, func()) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.subs == nil {
+		t.subs = make(map[chan string]struct{})
+	}
+	ch := make(chan string, outputSubBuffer)
+	t.subs[ch] = struct{}{}
+	return t.snapshot(), ch, func() {
+		t.mux.Lock()
+		delete(t.subs, ch)
+		t.mux.Unlock()
+	}
+}
+
+// Must be called with t.mux held.
+func (t *outputTail) snapshot() []string {
+	lines := make([]string, 0, len(t.lines))
+	lines = append(lines, t.lines[t.next:]...)
+	return append(lines, t.lines[:t.next]...)
+}
+
+// Record a line printed by the plugin, other than a control message.
+func (p *Plugin) outputLine(line string) {
+	p.tail.add(line)
+	p.emit(Event{Type: EventOutputLine, Line: line})
+}
+
+// OutputTail returns the last lines printed by the plugin, other than control messages,
+// oldest first.  Output written to a file by SetOutputFile is included, except for
+// standard error.
+func (p *Plugin) OutputTail() []string {
+	return p.tail.get()
+}
+
+// Return the registration timeout error, with the output of the plugin so far telling
+// why it did not get ready, like a panic or a missing library.
+func (c *ctrl) registrationTimeout() error {
+	if len(c.p.tail.get()) == 0 {
+		return errRegistrationTimeout
+	}
+	return ErrRegistrationTimeout(errors.New(c.withOutput(errRegistrationTimeout.Error())))
+}
+
+// Append the output of the plugin so far to msg, if any.
+func (c *ctrl) withOutput(msg string) string {
+	if lines := c.p.tail.get(); len(lines) > 0 {
+		return msg + ", plugin output:\n" + strings.Join(lines, "\n")
+	}
+	return msg
+}
@@ -0,0 +1,167 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestOutputFileRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.log")
+	o, err := newOutputFile(path, RotatePolicy{MaxSize: 10}, realClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	if err := o.writeLine("first line"); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing to rename at the next rotation
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.writeLine("2nd"); err == nil {
+		t.Fatal("rotation of a removed file succeeded")
+	}
+	if err := o.writeLine("3rd"); err != nil {
+		t.Fatalf("output not resumed after a failed rotation: %v", err)
+	}
+	if got := readFile(t, path); got != "2nd\n3rd\n" {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
+
+func TestOutputFileReopenFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "plugin.log")
+	o, err := newOutputFile(path, RotatePolicy{MaxSize: 10}, realClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	if err := o.writeLine("first line"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.writeLine("lost"); err == nil {
+		t.Fatal("write to a removed directory succeeded")
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.writeLine("found"); err != nil {
+		t.Fatalf("output not resumed after the directory was restored: %v", err)
+	}
+	if got := readFile(t, path); got != "found\n" {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
+
+func TestOutputFilePruneOnlyRotated(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugin.log")
+	unrelated := []string{"plugin.log.bak", "plugin.log.1", "plugin.log.20060102"}
+	for _, name := range unrelated {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	o, err := newOutputFile(path, RotatePolicy{MaxSize: 10, MaxBackups: 1}, realClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	for i := 0; i < 4; i++ {
+		if err := o.writeLine("some line"); err != nil {
+			t.Fatal(err)
+		}
+		// Rotated files are named by time
+		time.Sleep(time.Millisecond)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated int
+	names := make(map[string]bool)
+	for _, e := range entries {
+		names[e.Name()] = true
+		if suffix := e.Name()[len("plugin.log"):]; len(suffix) == len(rotateSuffixFormat)+1 {
+			rotated++
+		}
+	}
+	for _, name := range unrelated {
+		if !names[name] {
+			all := make([]string, 0, len(names))
+			for n := range names {
+				all = append(all, n)
+			}
+			sort.Strings(all)
+			t.Fatalf("unrelated file %s removed, left %v", name, all)
+		}
+	}
+	if rotated != 1 {
+		t.Fatalf("%d rotated files kept, want 1", rotated)
+	}
+}
+
+func TestOutputTailValidUTF8(t *testing.T) {
+	var tail outputTail
+	tail.add("x" + strings.Repeat("é", outputTailLen))
+	lines := tail.get()
+	if len(lines) != 1 || !utf8.ValidString(lines[0]) {
+		t.Fatalf("truncated line is not valid UTF-8: %q", lines)
+	}
+}
+
+func TestOutputFileMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugin.log")
+	clock := NewManualClock(time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC))
+	o, err := newOutputFile(path, RotatePolicy{MaxAge: time.Hour}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	if err := o.writeLine("first"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour + time.Second)
+	if err := o.writeLine("second"); err != nil {
+		t.Fatal(err)
+	}
+	rotated := path + "." + clock.Now().Format(rotateSuffixFormat)
+	if got := readFile(t, rotated); got != "first\n" {
+		t.Fatalf("unexpected rotated output %q", got)
+	}
+	if got := readFile(t, path); got != "second\n" {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Messages exchanged between host and plugin during the handshake follow
+// this grammar:
+//
+//	line   = prefix ": " field      (plugin output, one per line)
+//	prefix = name
+//	field  = name ": " value        (also used for connection headers)
+//	name   = 1*( ALPHA / DIGIT / "-" / "_" )
+//	value  = quoted / 1*CHAR        (quoted as a Go string, see quoteValue)
+//	ready  = "proto=" proto " addr=" addr
+//	proto  = "unix" / "tcp" / "fifo"
+//	addr   = 1*CHAR
+//
+// Parsers never panic on malformed input: they report it as invalid.
+
+var errInvalidHeader = errors.New("Invalid connection header")
+
+func isFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Parse a field: name, value and whether the input was valid.
+func parseField(s string) (string, string, bool) {
+	name, val, ok := strings.Cut(s, ": ")
+	if !ok || !isFieldName(name) {
+		return "", "", false
+	}
+	return name, val, true
+}
+
+func (h meta) matches(line string) bool {
+	return strings.HasPrefix(line, string(h)+": ")
+}
+
+// Parse a line of plugin output.  Key is empty if the line is not a control message.
+func (h meta) parse(line string) (key, val string) {
+	rest, ok := strings.CutPrefix(line, string(h)+": ")
+	if !ok {
+		return "", ""
+	}
+	return parseControl(rest)
+}
+
+// Parse the field of a control message.
+func parseControl(field string) (key, val string) {
+	key, val, ok := parseField(field)
+	if !ok {
+		return "", ""
+	}
+	return key, unquoteValue(val)
+}
+
+// Values of plugin output that would span more lines are quoted, so that their
+// content cannot be taken for other control messages.  So are values starting with
+// a quote, to be read back unchanged.
+func quoteValue(val string) string {
+	if strings.ContainsAny(val, "\r\n") || strings.HasPrefix(val, `"`) {
+		return strconv.Quote(val)
+	}
+	return val
+}
+
+// Older plugins do not quote values: these are kept as they are unless
+// they are valid quoted strings.
+func unquoteValue(val string) string {
+	if strings.HasPrefix(val, `"`) {
+		if s, err := strconv.Unquote(val); err == nil {
+			return s
+		}
+	}
+	return val
+}
+
+// Parse the value of the "ready" message.
+func parseReady(str string) (proto, addr string, err error) {
+	rest, ok := strings.CutPrefix(str, "proto=")
+	if !ok {
+		return "", "", errInvalidMessage
+	}
+	proto, rest, ok = strings.Cut(rest, " ")
+	if !ok || (proto != "unix" && proto != "tcp" && proto != "fifo") {
+		return "", "", errInvalidMessage
+	}
+	addr, ok = strings.CutPrefix(rest, "addr=")
+	if !ok || addr == "" {
+		return "", "", errInvalidMessage
+	}
+	return proto, addr, nil
+}
+
+// Parse the value of the "objects" message.
+func parseObjects(str string) []string {
+	objs := make([]string, 0)
+	for _, obj := range strings.Split(str, ",") {
+		if obj = strings.TrimSpace(obj); obj != "" {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
@@ -0,0 +1,151 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzMetaParse(f *testing.F) {
+	for _, line := range []string{
+		"pingo: ready: proto=unix addr=/tmp/pingo-1",
+		"pingo: objects: Plugin, Other",
+		`pingo: error: "multi\nline"`,
+		"pingo: error: no code",
+		"pingo: ",
+		"pingo:",
+		"pingo",
+		"pingo: bogus key: value",
+		"\x00\xff\xfe not even text",
+	} {
+		f.Add("pingo", line)
+	}
+	f.Fuzz(func(t *testing.T, prefix, line string) {
+		key, val := meta(prefix).parse(line)
+		if key == "" {
+			if val != "" {
+				t.Fatalf("value %q without key", val)
+			}
+			return
+		}
+		if !isFieldName(key) {
+			t.Fatalf("invalid key %q", key)
+		}
+		if !strings.HasPrefix(line, prefix+": "+key+": ") {
+			t.Fatalf("key %q not in line %q", key, line)
+		}
+	})
+}
+
+func FuzzParseReady(f *testing.F) {
+	for _, s := range []string{
+		"proto=unix addr=/tmp/pingo-1",
+		"proto=tcp addr=127.0.0.1:1024",
+		"proto=fifo addr=/tmp/fifo",
+		"proto=",
+		"proto=unix",
+		"proto=unix addr=",
+		"proto=udp addr=x",
+		"",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		proto, addr, err := parseReady(s)
+		if err != nil {
+			if proto != "" || addr != "" {
+				t.Fatalf("values %q, %q with error %v", proto, addr, err)
+			}
+			return
+		}
+		if proto != "unix" && proto != "tcp" && proto != "fifo" {
+			t.Fatalf("invalid protocol %q", proto)
+		}
+		if addr == "" {
+			t.Fatal("empty address")
+		}
+		if s != "proto="+proto+" addr="+addr {
+			t.Fatalf("%q parsed as %q, %q", s, proto, addr)
+		}
+	})
+}
+
+func FuzzParseObjects(f *testing.F) {
+	for _, s := range []string{"Plugin", "Plugin, Other", ",,", " , A ,", ""} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		objs := parseObjects(s)
+		if objs == nil {
+			t.Fatal("nil list of objects")
+		}
+		for _, obj := range objs {
+			if obj == "" || obj != strings.TrimSpace(obj) || strings.Contains(obj, ",") {
+				t.Fatalf("invalid object %q in %q", obj, s)
+			}
+		}
+		if parsed := parseObjects(strings.Join(objs, ", ")); strings.Join(parsed, ",") != strings.Join(objs, ",") {
+			t.Fatalf("objects %q parsed back as %q", objs, parsed)
+		}
+	})
+}
+
+func FuzzQuoteValue(f *testing.F) {
+	for _, s := range []string{"", "plain", "two\nlines", "\r", `"quoted"`, `"`, "\xff\xfe", `\n`} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, val string) {
+		quoted := quoteValue(val)
+		if strings.ContainsAny(quoted, "\r\n") {
+			t.Fatalf("quoted value %q spans more lines", quoted)
+		}
+		if got := unquoteValue(quoted); got != val {
+			t.Fatalf("%q quoted as %q, unquoted as %q", val, quoted, got)
+		}
+		// As written by the plugin and read by the host
+		key, got := meta("pingo").parse("pingo: error: " + quoted)
+		if key != "error" || got != val {
+			t.Fatalf("%q read back as %q: %q", val, key, got)
+		}
+	})
+}
+
+// Connection reading from a fixed input.
+type readOnlyConn struct {
+	*strings.Reader
+}
+
+func (c readOnlyConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (c readOnlyConn) Close() error {
+	return nil
+}
+
+func FuzzParseHeaders(f *testing.F) {
+	for _, s := range []string{
+		"Auth-Token: abc\r\n\r\n",
+		"Auth-Token: abc\nCompression: gzip\n\n",
+		": empty name\n\n",
+		"no separator\n\n",
+		"\n",
+		"",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		headers := make(map[string]string)
+		if err := parseHeaders(newBufReadWriteCloser(readOnlyConn{strings.NewReader(s)}), headers); err != nil {
+			return
+		}
+		for name := range headers {
+			if !isFieldName(name) {
+				t.Fatalf("invalid header name %q", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Persistent plugins are not started by the host, but run as daemons under a
+// service manager.  They listen on a fixed address (-pingo:listen) and use a
+// token read from a file (-pingo:tokenfile); hosts connect to them with Attach.  Other
+// hosts can share the same plugin process with their own tokens, see AddClient.
+
+// Attach returns a plugin for the plugin already running at addr, authenticating with
+// token.  Like for NewPlugin, the plugin can be configured before calling Start, which
+// connects to the running plugin instead of starting a process.  Stop only closes the
+// connection.
+//
+// Errors connecting to the plugin are returned by the first call after Start.
+func Attach(proto, addr, token string) *Plugin {
+	p := NewPlugin(proto, addr)
+	p.attachTo = addr
+	p.token = token
+	return p
+}
+
+// Connect to a persistent plugin in place of starting a process.
+func (c *ctrl) attach() {
+	c.secret = c.p.token
+	c.waitCh = nil
+	c.linesCh = nil
+	if c.p.stream != nil {
+		if !c.openStream() {
+			return
+		}
+	} else if !c.ready(fmt.Sprintf("proto=%s addr=%s", c.p.proto, c.p.attachTo)) {
+		return
+	}
+	c.requestObjects()
+}
+
+// Fixed address of a persistent plugin.
+type fixed string
+
+func (f *fixed) addr() string {
+	return string(*f)
+}
+
+func (f *fixed) retries() int {
+	return 1
+}
+
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("Empty token file " + path)
+	}
+	return token, nil
+}
+
+// ExportUnits writes service definitions to run the plugins of the manager persistently:
+// systemd units on Linux and other systems, launchd property lists on macOS.  For each
+// plugin, dir receives the service definition and a "pingo-<name>.token" file to be
+// passed to Attach.  The plugins listen on "pingo-<name>.sock" in dir, or on a port chosen
+// at random between 20000 and 60000 for TCP plugins; use Attach with the same address.
+//
+// Existing files are overwritten.
+func (m *Manager) ExportUnits(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range m.Names() {
+		p := m.Plugin(name)
+		if p == nil {
+			continue
+		}
+		if err := exportUnit(dir, name, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportUnit(dir, name string, p *Plugin) error {
+	base := filepath.Join(dir, "pingo-"+name)
+
+	token := base + ".token"
+	if err := os.WriteFile(token, []byte(randstr(64)+"\n"), 0600); err != nil {
+		return err
+	}
+
+	exe, err := filepath.Abs(p.exe)
+	if err != nil {
+		return err
+	}
+	addr := base + ".sock"
+	if p.proto == "tcp" {
+		addr = fmt.Sprintf("127.0.0.1:%d", 20000+randPort())
+	}
+	args := p.withParams([]string{
+		exe,
+		"-pingo:proto=" + p.proto,
+		"-pingo:listen=" + addr,
+		"-pingo:tokenfile=" + token,
+	})
+
+	var buf bytes.Buffer
+	path := base + ".service"
+	if runtime.GOOS == "darwin" {
+		path = base + ".plist"
+		writePlist(&buf, "pingo."+name, args)
+	} else {
+		writeSystemdUnit(&buf, name, args)
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Random port offset below 40000, from the random source of the package.
+func randPort() int {
+	return int(randUint64() % 40000)
+}
+
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "%", "%%")
+	return `"` + s + `"`
+}
+
+func writeSystemdUnit(buf *bytes.Buffer, name string, args []string) {
+	quoted := make([]string, len(args))
+	for i := range args {
+		quoted[i] = systemdQuote(args[i])
+	}
+	fmt.Fprintf(buf, "[Unit]\nDescription=pingo plugin %s\n\n", name)
+	fmt.Fprintf(buf, "[Service]\nExecStart=%s\nRestart=on-failure\n\n", strings.Join(quoted, " "))
+	fmt.Fprintf(buf, "[Install]\nWantedBy=default.target\n")
+}
+
+func writePlist(buf *bytes.Buffer, label string, args []string) {
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	fmt.Fprintf(buf, "\t<key>Label</key>\n\t<string>%s</string>\n", html.EscapeString(label))
+	buf.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range args {
+		fmt.Fprintf(buf, "\t\t<string>%s</string>\n", html.EscapeString(arg))
+	}
+	buf.WriteString("\t</array>\n\t<key>RunAtLoad</key>\n\t<true/>\n\t<key>KeepAlive</key>\n\t<true/>\n</dict>\n</plist>\n")
+}
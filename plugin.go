@@ -9,6 +9,7 @@ package pingo
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -17,18 +18,23 @@ import (
 	"net/rpc"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	errInvalidMessage      = ErrInvalidMessage(errors.New("Invalid ready message"))
 	errRegistrationTimeout = ErrRegistrationTimeout(errors.New("Registration timed out"))
+	errNotStarted          = ErrNotStarted(errors.New("Plugin has not been started"))
 )
 
 // Represents a plugin. After being created the plugin is not started or ready to run.
 //
 // Additional configuration (ErrorHandler and Timeout) can be set after initialization.
+// The ErrorHandler can also be replaced while the plugin is running.
 //
 // Use Start() to make the plugin available.
 type Plugin struct {
@@ -38,30 +44,90 @@ type Plugin struct {
 	params      []string
 	initTimeout time.Duration
 	exitTimeout time.Duration
+	// I/O deadlines on the RPC connection
+	idleTimeout time.Duration
+	sendTimeout time.Duration
+	keepAlive   time.Duration
+	hostInfo    *HostInfo
+	maxRequest  int
+	maxResponse int
 	handler     ErrorHandler
+	handlerMu   sync.Mutex
 	running     bool
+	autoStart   bool
+	startOnce   sync.Once
+	slowCall    time.Duration
+	slowCallFn  func(SlowCallInfo)
+	state       int32
+	clock       Clock
+	pid         atomic.Int64
+	died        atomic.Pointer[error]
+	stats       callStats
+	sessions    sessionStats
+	readyConn   atomic.Pointer[conn]
+	failed      atomic.Pointer[error]
+	lost        chan struct{}
+	lostOnce    sync.Once
+	output      *outputFile
+	tail        outputTail
+	sink        atomic.Pointer[eventSink]
+	cleanup     func()
+	sandbox     Sandbox
+	activate    bool
+	signed      bool
+	checksum    string
+	timeouts    map[string]time.Duration
+	routes      map[string]string
+	recorder    *recorder
+	chaos       *ChaosConfig
+	outputDec   func([]byte) string
+	ansiPass    bool
+	compress    *Compression
+	template    []string
+	cmdMod      func(*exec.Cmd)
+	noise       int
+	noiseFwd    bool
+	features    []string
+	schemaPol   SchemaPolicy
+	schemas     map[string]string
+	schemaWarn  sync.Map
+	attachTo    string
+	stream      io.ReadWriteCloser
+	lease       time.Duration
+	callSeq     atomic.Uint64
+	timings     atomic.Pointer[StartupTimings]
+	readyFn     func(StartupTimings)
+	token       string
 	meta        meta
 	objsCh      chan *objects
 	connCh      chan *conn
 	killCh      chan *waiter
+	sigCh       chan *signalReq
+	failCh      chan error
 	exitCh      chan struct{}
+	done        chan struct{}
+	stopOnce    sync.Once
+	stopRes     StopResult
+	stopErr     error
 }
 
 // NewPlugin create a new plugin ready to be started, or returns an error if the initial setup fails.
 //
 // The first argument specifies the protocol. It can be either set to "unix" for communication on an
 // ephemeral local socket, or "tcp" for network communication on the local host (using a random
-// unprivileged port.)
+// unprivileged port.)  Where unix sockets cannot be used, "fifo" makes the plugin communicate
+// through a pair of named pipes in the socket directory; not supported on Windows.
 //
-// This constructor will panic if the proto argument is neither "unix" nor "tcp".
+// This constructor will panic if the proto argument is not "unix", "tcp" or "fifo".
 //
 // The path to the plugin executable should be absolute. Any path accepted by the "exec" package in the
 // standard library is accepted and the same rules for execution are applied.
 //
-// Optionally some parameters might be passed to the plugin executable.
+// Optionally some parameters might be passed to the plugin executable.  They follow the flags
+// used by this package and a "--" separator; the plugin gets them with Args.
 func NewPlugin(proto, path string, params ...string) *Plugin {
-	if proto != "unix" && proto != "tcp" {
-		panic("Invalid protocol. Specify 'unix' or 'tcp'.")
+	if proto != "unix" && proto != "tcp" && proto != "fifo" {
+		panic("Invalid protocol. Specify 'unix', 'tcp' or 'fifo'.")
 	}
 	p := &Plugin{
 		exe:         path,
@@ -70,26 +136,42 @@ func NewPlugin(proto, path string, params ...string) *Plugin {
 		initTimeout: 2 * time.Second,
 		exitTimeout: 2 * time.Second,
 		handler:     NewDefaultErrorHandler(),
-		meta:        meta("pingo" + randstr(5)),
+		clock:       realClock{},
+		meta:        meta("pingo" + randstr(22)),
 		objsCh:      make(chan *objects),
 		connCh:      make(chan *conn),
 		killCh:      make(chan *waiter),
+		sigCh:       make(chan *signalReq),
+		failCh:      make(chan error),
 		exitCh:      make(chan struct{}),
+		done:        make(chan struct{}),
+		lost:        make(chan struct{}),
 	}
+	p.callSeq.Store(randUint64())
 	return p
 }
 
 // Set the error (and output) handler implementation.  Use this to set a custom implementation.
 // By default, standard logging is used.  See ErrorHandler.
 //
-// Panics if called after Start.
+// SetErrorHandler can be called while the plugin is running; the new handler is used
+// for all errors and output received after this call returns.
 func (p *Plugin) SetErrorHandler(h ErrorHandler) {
-	if p.running {
-		panic("Cannot call SetErrorHandler after Start")
-	}
+	p.handlerMu.Lock()
+	defer p.handlerMu.Unlock()
 	p.handler = h
 }
 
+func (p *Plugin) errorHandler() ErrorHandler {
+	p.handlerMu.Lock()
+	h := p.handler
+	p.handlerMu.Unlock()
+	if ch, ok := h.(ContextHandler); ok {
+		return &contextHandler{p: p, h: ch}
+	}
+	return h
+}
+
 // Set the maximum time a plugin is allowed to start up and to shut down.  Empty timeout (zero)
 // is not allowed, default will be used.
 //
@@ -107,6 +189,57 @@ func (p *Plugin) SetTimeout(t time.Duration) {
 	p.exitTimeout = t
 }
 
+// SetMetaPrefix sets the prefix of the lines the plugin writes to its standard output to
+// communicate with the host.  Other lines are treated as output of the plugin.  The prefix
+// can only contain letters, digits, "-" and "_".
+//
+// By default the prefix is "pingo" followed by 132 random bits, so that the output of the
+// plugin cannot contain it by chance, or be crafted to pass for control messages.  Only set
+// a prefix that the plugin program cannot print otherwise.
+//
+// Panics if called after Start or if the prefix is invalid.
+func (p *Plugin) SetMetaPrefix(prefix string) {
+	if p.running {
+		panic("Cannot call SetMetaPrefix after Start")
+	}
+	if !isFieldName(prefix) {
+		panic("Invalid meta prefix " + strconv.Quote(prefix))
+	}
+	p.meta = meta(prefix)
+}
+
+// SetIOTimeouts sets deadlines on the RPC connection to the plugin: idle is the maximum
+// time to wait for data from the plugin while calls are pending, write the maximum time
+// to send a request.  Calls failing because of these deadlines return ErrIOTimeout and
+// the connection is closed: the plugin then fails, and is killed if it does not exit
+// within the exit timeout.  Zero disables a deadline; both are disabled by default.
+//
+// Panics if called after Start.
+func (p *Plugin) SetIOTimeouts(idle, write time.Duration) {
+	if p.running {
+		panic("Cannot call SetIOTimeouts after Start")
+	}
+	p.idleTimeout = idle
+	p.sendTimeout = write
+}
+
+// SetAutoStart makes the first Call or Objects start the plugin if Start has not been
+// called yet, instead of returning ErrNotStarted.  The call then waits for the plugin to
+// be ready as usual.
+//
+// Panics if called after Start.
+func (p *Plugin) SetAutoStart(auto bool) {
+	if p.running {
+		panic("Cannot call SetAutoStart after Start")
+	}
+	p.autoStart = auto
+}
+
+// SetSocketDirectory sets the directory where the sockets of the "unix" and "fifo"
+// protocols are created.  By default, unix sockets are created in the abstract namespace
+// on Linux, unless a sandbox is set, and everything else in the temporary directory.
+//
+// Panics if called after Start.
 func (p *Plugin) SetSocketDirectory(dir string) {
 	if p.running {
 		panic("Cannot call SetSocketDirectory after Start")
@@ -114,6 +247,26 @@ func (p *Plugin) SetSocketDirectory(dir string) {
 	p.unixdir = dir
 }
 
+// SetOutputFile writes all output of the plugin subprocess to the file at path instead of
+// passing it line by line to the ErrorHandler.  The file is rotated according to rotate.
+// Control messages and errors are still handled as usual.
+//
+// Returns an error if the file cannot be opened.  Panics if called after Start.
+func (p *Plugin) SetOutputFile(path string, rotate RotatePolicy) error {
+	if p.running {
+		panic("Cannot call SetOutputFile after Start")
+	}
+	o, err := newOutputFile(path, rotate, p.clock)
+	if err != nil {
+		return err
+	}
+	if p.output != nil {
+		p.output.Close()
+	}
+	p.output = o
+	return nil
+}
+
 // Default string representation
 func (p *Plugin) String() string {
 	return fmt.Sprintf("%s %s", p.exe, strings.Join(p.params, " "))
@@ -123,50 +276,284 @@ func (p *Plugin) String() string {
 // plugin will reveal eventual errors occurred at initialization.
 //
 // Calls subsequent to Start will hang until the plugin has been properly initialized.
+//
+// Calling Start more than once has no effect.
 func (p *Plugin) Start() {
-	p.running = true
-	go p.run()
+	p.startOnce.Do(func() {
+		p.running = true
+		p.setState(StateStarting)
+		go p.run()
+	})
+}
+
+// Start the plugin if allowed by SetAutoStart, or return ErrNotStarted.
+func (p *Plugin) ensureStarted() error {
+	if p.State() != StateNew {
+		return nil
+	}
+	if !p.autoStart {
+		return errNotStarted
+	}
+	p.Start()
+	return nil
 }
 
 // Stop attemps to stop cleanly or kill the running plugin, then will free all resources.
 // Stop returns when the plugin as been shut down and related routines have exited.
-func (p *Plugin) Stop() {
-	wr := newWaiter()
-	p.killCh <- wr
-	wr.wait()
-	p.exitCh <- struct{}{}
+// Output of the plugin held open by processes it started is abandoned shortly after
+// the plugin exits.
+//
+// The result tells how the plugin process ended.  The error is the unrecoverable error
+// the plugin failed with before Stop, if any.
+//
+// Calling Stop more than once has no effect and returns the same result.  A plugin
+// stopped before Start cannot be started anymore.
+func (p *Plugin) Stop() (StopResult, error) {
+	p.stopOnce.Do(func() {
+		started := true
+		p.startOnce.Do(func() { started = false })
+		if !started {
+			p.stopRes = StopResult{Reason: StopNotStarted, ExitCode: -1}
+			p.setState(StateStopped)
+			close(p.done)
+			p.release()
+			return
+		}
+
+		start := p.clock.Now()
+		wr := newWaiter()
+		p.killCh <- wr
+		wr.wait()
+		p.stopRes.Duration = p.clock.Now().Sub(start)
+		if err := p.failed.Load(); err != nil {
+			p.stopErr = *err
+		}
+		p.setState(StateStopped)
+		p.exitCh <- struct{}{}
+		<-p.done
+		p.release()
+	})
+	return p.stopRes, p.stopErr
+}
+
+// Free the resources of the plugin other than its process and control loop.
+func (p *Plugin) release() {
+	if p.output != nil {
+		p.output.Close()
+	}
+	if p.cleanup != nil {
+		p.cleanup()
+	}
+}
+
+// Done returns a channel that is closed when the plugin has been stopped and all
+// its resources (processes, connections and goroutines) have been released.
+func (p *Plugin) Done() <-chan struct{} {
+	return p.done
 }
 
 // Call performs an RPC call to the plugin. Prior to calling Call, the plugin must have been
 // initialized by calling Start.
 //
 // Call will hang until a plugin has been initialized; it will return any error that happens
-// either when performing the call or during plugin initialization via Start.
+// either when performing the call or during plugin initialization via Start.  If Start has
+// not been called, Call returns ErrNotStarted immediately, unless SetAutoStart is enabled.
 //
 // Please refer to the "rpc" package from the standard library for more information on the
 // semantics of this function.
-func (p *Plugin) Call(name string, args interface{}, resp interface{}) error {
-	conn := &conn{wr: newWaiter()}
-	p.connCh <- conn
-	conn.wr.wait()
+func (p *Plugin) Call(name string, args interface{}, resp interface{}) (err error) {
+	name = p.route(name)
+	if p.timeouts[name] > 0 {
+		return p.callWith(context.Background(), name, args, resp, CallOpts{})
+	}
 
-	if conn.err != nil {
-		return conn.err
+	start := time.Now()
+	defer func() { p.callDone(name, args, resp, start, err) }()
+
+	if err := checkEncodable(name, args, resp); err != nil {
+		return err
+	}
+	conn, err := p.connect(context.Background())
+	if err != nil {
+		return err
+	}
+	if err := p.checkSchema(name, args, resp); err != nil {
+		return err
+	}
+	if err := p.checkRequestSize(args); err != nil {
+		return err
+	}
+	if err := p.chaosCall(context.Background(), name); err != nil {
+		return err
 	}
 
-	return conn.client.Call(name, args, resp)
+	defer conn.dc.end(conn.dc.begin())
+
+	return p.callFailed(parseCallError(name, wrapIOError(conn.client.Call(name, args, resp))))
+}
+
+// Like Call, but gives up waiting for initialization or for the response when ctx is done.
+func (p *Plugin) callContext(ctx context.Context, name string, args interface{}, resp interface{}) error {
+	return p.callWith(ctx, name, args, resp, CallOpts{})
+}
+
+func (p *Plugin) callWith(ctx context.Context, name string, args interface{}, resp interface{}, opts CallOpts) (err error) {
+	name = p.route(name)
+	start := time.Now()
+	defer func() { p.callDone(name, args, resp, start, err) }()
+
+	if err := checkEncodable(name, args, resp); err != nil {
+		return err
+	}
+	conn, err := p.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if err := p.checkSchema(name, args, resp); err != nil {
+		return err
+	}
+	if err := p.checkRequestSize(args); err != nil {
+		return err
+	}
+	if err := p.chaosCall(ctx, name); err != nil {
+		return err
+	}
+
+	if t := p.timeouts[name]; t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+		if opts.id == 0 {
+			opts.id = p.nextCallID()
+		}
+	}
+	// Retries are recognized by their ID
+	if opts.Semantics == AtLeastOnce && opts.id == 0 {
+		opts.id = p.nextCallID()
+	}
+	// The plugin gets the deadline of calls it can cancel
+	if deadline, ok := ctx.Deadline(); ok && opts.id != 0 {
+		if opts.timeout = time.Until(deadline); opts.timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+
+	defer conn.dc.end(conn.dc.begin())
+
+	if opts.Semantics == AtLeastOnce && opts.Retries > 0 && opts.AttemptTimeout > 0 {
+		return p.callRetrying(ctx, conn, name, args, resp, opts)
+	}
+	// The answer can arrive after giving up on the call: decode it aside, so that resp
+	// is only set on success
+	call := conn.client.Go(opts.method(name), args, newReply(resp), make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		// The plugin may answer as soon as it sees the deadline
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if call.Error == nil {
+			setReply(resp, call.Reply)
+		}
+		return p.callFailed(parseCallError(name, wrapIOError(call.Error)))
+	case <-ctx.Done():
+		if opts.id != 0 {
+			conn.cancel(opts.id)
+		}
+		return ctx.Err()
+	}
+}
+
+// Calls interrupted by a fatal error of the plugin return that error, or
+// ErrPluginDiedMidCall if its process exited.  As the connection can break before the
+// control loop notices, wait for it a little.
+func (p *Plugin) callFailed(err error) error {
+	if !connectionLost(err) {
+		return err
+	}
+	t := p.clock.NewTimer(p.exitTimeout)
+	defer t.Stop()
+	select {
+	case <-p.lost:
+	case <-t.C():
+	}
+	if died := p.died.Load(); died != nil {
+		return *died
+	}
+	if ferr := p.failed.Load(); ferr != nil {
+		return *ferr
+	}
+	return err
+}
+
+// Called by the control loop when the plugin cannot serve calls anymore.
+func (p *Plugin) setLost() {
+	p.lostOnce.Do(func() {
+		close(p.lost)
+	})
+}
+
+// Return the connection to the plugin.  Once the plugin is ready, the connection
+// is returned directly; otherwise wait for the control loop to hand it out.
+func (p *Plugin) connect(ctx context.Context) (*conn, error) {
+	if c := p.readyConn.Load(); c != nil {
+		return c, nil
+	}
+	if err := p.ensureStarted(); err != nil {
+		return nil, err
+	}
+
+	c := &conn{wr: newWaiter()}
+	select {
+	case p.connCh <- c:
+	case <-p.done:
+		return nil, errNotRunning
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case <-c.wr.c:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return c, c.err
+}
+
+// ReleaseStartup signals the plugin that the host services are up. Plugins blocked
+// in WaitHostReady will continue once this call returns.
+//
+// Like Call, ReleaseStartup will hang until the plugin has been initialized.
+func (p *Plugin) ReleaseStartup() error {
+	return p.Call(internalObject+".ReleaseStartup", 0, nil)
 }
 
 // Objects returns a list of the exported objects from the plugin. Exported objects used
 // internally are not reported.
 //
-// Like Call, Objects returns any error happened on initialization if called after Start.
+// Like Call, Objects returns any error happened on initialization if called after Start,
+// and ErrNotStarted if called before (see SetAutoStart).
 func (p *Plugin) Objects() ([]string, error) {
+	objects, err := p.requestObjects()
+	if err != nil {
+		return nil, err
+	}
+	return objects.list, nil
+}
+
+func (p *Plugin) requestObjects() (*objects, error) {
+	if err := p.ensureStarted(); err != nil {
+		return nil, err
+	}
+
 	objects := &objects{wr: newWaiter()}
-	p.objsCh <- objects
+	select {
+	case p.objsCh <- objects:
+	case <-p.done:
+		return nil, errNotRunning
+	}
 	objects.wr.wait()
 
-	return objects.list, objects.err
+	return objects, objects.err
 }
 
 // ErrorHandler is the interface used by Plugin to report non-fatal errors and any other
@@ -176,33 +563,104 @@ func (p *Plugin) Objects() ([]string, error) {
 type ErrorHandler interface {
 	// Error is called whenever a non-fatal error occurs in the plugin subprocess.
 	Error(error)
-	// Print is called for each line of output received from the plugin subprocess.
+	// Print is called for each line of output received from the plugin subprocess.  Errors
+	// the plugin reports in its control messages are passed as error values.
 	Print(interface{})
 }
 
+// Severity of the messages passed to an ErrorHandler.
+type Severity int
+
+const (
+	// Lines printed by the plugin, passed to Print
+	SeverityOutput Severity = iota
+	// Errors reported by the plugin in its control messages, passed to Print
+	SeverityPluginError
+	// Errors detected by the host, passed to Error
+	SeverityError
+)
+
 // Default error handler implementation. Uses the default logging facility from the
-// Go standard library.
-type DefaultErrorHandler struct{}
+// Go standard library, unless set otherwise.
+type DefaultErrorHandler struct {
+	mux    sync.Mutex
+	logger *log.Logger
+	minSev Severity
+	quiet  bool
+}
 
 // Constructor for default error handler.
 func NewDefaultErrorHandler() *DefaultErrorHandler {
 	return &DefaultErrorHandler{}
 }
 
+// SetOutput makes the handler log to w, with the standard flags, instead of using the
+// standard logger.
+func (e *DefaultErrorHandler) SetOutput(w io.Writer) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.logger = log.New(w, "", log.LstdFlags)
+}
+
+// SetMinSeverity makes the handler discard the messages less severe than sev.  All are
+// logged by default.
+func (e *DefaultErrorHandler) SetMinSeverity(sev Severity) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.minSev = sev
+}
+
+// SetQuiet makes the handler discard all messages while quiet is true.
+func (e *DefaultErrorHandler) SetQuiet(quiet bool) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.quiet = quiet
+}
+
+func (e *DefaultErrorHandler) log(sev Severity, v ...interface{}) {
+	e.mux.Lock()
+	logger, discard := e.logger, e.quiet || sev < e.minSev
+	e.mux.Unlock()
+
+	if discard {
+		return
+	}
+	if logger != nil {
+		logger.Print(v...)
+		return
+	}
+	log.Print(v...)
+}
+
 // Log via default standard library facility prepending the "error: " string.
 func (e *DefaultErrorHandler) Error(err error) {
-	log.Print("error: ", err)
+	e.log(SeverityError, "error: ", err)
 }
 
-// Log via default standard library facility.
+// Log via default standard library facility.  Errors reported by the plugin are
+// prefixed with "plugin error: ".
 func (e *DefaultErrorHandler) Print(s interface{}) {
-	log.Print(s)
+	if err, ok := s.(error); ok {
+		e.log(SeverityPluginError, "plugin error: ", err)
+		return
+	}
+	e.log(SeverityOutput, s)
 }
 
-const internalObject = "PingoRpc"
+const (
+	// Object names starting with this prefix are reserved for internal use
+	reservedPrefix = "Pingo"
+	// Name of the object for plugin control, versioned with the control protocol
+	internalObject = reservedPrefix + "RpcV1"
+)
+
+func isInternalObject(name string) bool {
+	return strings.HasPrefix(name, reservedPrefix)
+}
 
 type conn struct {
 	client *rpc.Client
+	dc     *deadlineConn
 	err    error
 	wr     *waiter
 }
@@ -232,36 +690,74 @@ type client struct {
 	secret string
 }
 
-func newClient(s string, conn io.ReadWriteCloser) *client {
-	return &client{secret: s, Client: rpc.NewClient(conn)}
+func newClient(s string, codec rpc.ClientCodec) *client {
+	return &client{secret: s, Client: rpc.NewClientWithCodec(codec)}
 }
 
-func (c *client) authenticate(w io.Writer) error {
-	_, err := io.WriteString(w, "Auth-Token: "+c.secret+"\n\n")
+// Send the headers of the connection, starting with the token.
+func (c *client) authenticate(w io.Writer, headers ...string) error {
+	buf := "Auth-Token: " + c.secret + "\n"
+	for _, h := range headers {
+		buf += h + "\n"
+	}
+	_, err := io.WriteString(w, buf+"\n")
 	return err
 }
 
-func dialAuthRpc(secret, network, address string, timeout time.Duration) (*rpc.Client, error) {
-	conn, err := net.DialTimeout(network, address, timeout)
+// Connect and authenticate to the plugin, using the connection settings of p.
+func dialAuthRpc(secret, network, address string, p *Plugin) (*rpc.Client, *deadlineConn, error) {
+	var nc net.Conn
+	var err error
+	var backoff fdBackoff
+	deadline := time.Now().Add(p.initTimeout)
+	for {
+		if network == "fifo" {
+			nc, err = dialFifo(address, p.initTimeout)
+		} else {
+			dialer := &net.Dialer{Timeout: p.initTimeout, KeepAlive: p.keepAlive}
+			nc, err = dialer.Dial(network, address)
+		}
+		// Retry until descriptors are released
+		if err == nil || !isFDExhausted(err) || time.Now().After(deadline) {
+			break
+		}
+		backoff.wait()
+	}
 	if err != nil {
-		return nil, err
+		if isFDExhausted(err) {
+			err = fdExhausted(err)
+		}
+		return nil, nil, err
 	}
-	c := newClient(secret, conn)
-	if err := c.authenticate(conn); err != nil {
-		return nil, err
+	return authRpc(secret, nc, p)
+}
+
+// Authenticate over nc and return a client using it, with the connection settings of p.
+func authRpc(secret string, nc net.Conn, p *Plugin) (*rpc.Client, *deadlineConn, error) {
+	nc.SetWriteDeadline(time.Now().Add(p.initTimeout))
+	if err := (&client{secret: secret}).authenticate(nc, p.compress.headers()...); err != nil {
+		nc.Close()
+		return nil, nil, err
 	}
-	return c.Client, nil
+	nc.SetWriteDeadline(time.Time{})
+	dc := newDeadlineConn(nc, p.idleTimeout, p.sendTimeout, true)
+	codec := newClientCodec(dc, p.maxResponse, &p.stats)
+	if p.compress != nil && p.compress.Requests {
+		codec.compress = newCompression(p.compress.Compressor, p.compress.MinSize)
+	}
+	return newClient(secret, codec).Client, dc, nil
 }
 
 type objects struct {
 	list []string
+	info []ObjectInfo
 	err  error
 	wr   *waiter
 }
 
 type ctrl struct {
 	p    *Plugin
-	objs []string
+	objs []ObjectInfo
 	// Protocol and address for RPC
 	proto, addr string
 	// Secret needed to connect to server
@@ -278,26 +774,81 @@ type ctrl struct {
 	// Get notification from Wait on the subprocess
 	waitCh chan error
 	// Get output lines from subprocess
-	linesCh chan string
+	linesCh chan printed
 	// Respond to a routine waiting for this mail loop to exit.
 	over *waiter
 	// Executable
 	proc *os.Process
+	// Pid of the tracked process
+	pid int
+	// Tracked process is a delegate of the started subprocess
+	delegated bool
+	// Authenticates control messages if set
+	mac *lineMAC
+	// Tracked process is a delegate being polled
+	watched bool
+	// Process has been killed by us
+	killed bool
+	// How the process ended
+	result StopResult
 	// RPC client to subprocess
 	client *rpc.Client
+	// Connection of client
+	dc *deadlineConn
+	// Kill the subprocess if it doesn't exit in time after Stop
+	exitTimer     Timer
+	exitTimeoutCh <-chan time.Time
+	// Connection of client broken, and how long to wait for the process to exit
+	brokenCh        <-chan struct{}
+	brokenTimer     Timer
+	brokenTimeoutCh <-chan time.Time
+	// Routines started by the main loop
+	wg sync.WaitGroup
+	// Keepalive pings
+	keepalive   Ticker
+	keepaliveCh <-chan time.Time
+	pingCh      chan error
+	pinging     bool
+	// Closed to stop renewing the lease
+	leaseStop chan struct{}
+	// Bytes of output printed before the plugin is ready
+	noise int
+	// Startup of the plugin
+	started time.Time
+	timings StartupTimings
+	// Answers to the internal calls made before accepting calls
+	handshakeCh chan *rpc.Call
 }
 
 func newCtrl(p *Plugin, t time.Duration) *ctrl {
 	return &ctrl{
 		p:         p,
-		timeoutCh: time.After(t),
-		linesCh:   make(chan string),
+		timeoutCh: p.clock.NewTimer(t).C(),
+		linesCh:   make(chan printed),
 		waitCh:    make(chan error),
+		started:   p.clock.Now(),
 	}
 }
 
+// Record an unrecoverable error.  The first error is the most specific one: later
+// errors are usually consequences, like the exit status of the failed process.
 func (c *ctrl) fatal(err error) {
-	c.err = err
+	if c.err == nil {
+		c.err = err
+		c.p.failed.Store(&err)
+	}
+	c.p.setLost()
+	c.p.readyConn.Store(nil)
+	c.stopLease()
+	// Not failed because of Stop
+	if c.p.State() == StateStarting && c.over == nil {
+		c.p.emit(Event{Type: EventHandshakeFailed, Err: c.err})
+	}
+	c.p.setState(StateFailed)
+	// Calls waiting for a response fail with the error
+	if c.client != nil {
+		c.client.Close()
+	}
 	c.open()
 	c.kill()
 }
@@ -306,9 +857,14 @@ func (c *ctrl) isFatal() bool {
 	return c.err != nil
 }
 
-func (c *ctrl) close() {
-	c.connCh = nil
-	c.objsCh = nil
+// Stop accepting calls: from now on, they fail with err unless a fatal error happened.
+func (c *ctrl) refuse(err error) {
+	c.p.readyConn.Store(nil)
+	c.stopLease()
+	if c.err == nil {
+		c.err = err
+	}
+	c.open()
 }
 
 func (c *ctrl) open() {
@@ -319,59 +875,205 @@ func (c *ctrl) open() {
 func (c *ctrl) ready(val string) bool {
 	var err error
 
+	c.timings.Ready = c.elapsed()
+
+	if err := c.chaosHandshake(); err != nil {
+		c.fatal(err)
+		return false
+	}
 	if err := c.parseReady(val); err != nil {
 		c.fatal(err)
 		return false
 	}
 
-	c.client, err = dialAuthRpc(c.secret, c.proto, c.addr, c.p.initTimeout)
+	c.client, c.dc, err = dialAuthRpc(c.secret, c.proto, c.addr, c.p)
+	c.timings.Dial = c.elapsed() - c.timings.Ready
 	if err != nil {
 		c.fatal(err)
 		return false
 	}
 
 	// Remove the temp socket now that we are connected
-	if c.proto == "unix" {
+	if c.proto == "unix" && c.p.attachTo == "" && !isAbstractSocket(c.addr) {
 		if err := os.Remove(c.addr); err != nil {
-			c.p.handler.Error(errors.New("Cannot remove temporary socket: " + err.Error()))
+			c.p.errorHandler().Error(errors.New("Cannot remove temporary socket: " + err.Error()))
+		}
+	}
+
+	return true
+}
+
+// Make an internal call before accepting calls.  Its answer is passed to handshakeReply
+// by the control loop, which meanwhile still handles Stop and the registration timeout.
+func (c *ctrl) handshake(method string, reply interface{}) {
+	c.handshakeCh = make(chan *rpc.Call, 1)
+	c.client.Go(method, 0, reply, c.handshakeCh)
+}
+
+// Continue the handshake after the answer to one of its calls.
+func (c *ctrl) handshakeReply(call *rpc.Call) {
+	c.handshakeCh = nil
+	// Stopped or failed while waiting
+	if c.isFatal() || c.over != nil {
+		return
+	}
+	switch call.ServiceMethod {
+	case internalObject + ".ListObjects":
+		if !c.gotObjects(call) {
+			return
+		}
+		if c.p.schemaPol != SchemaOff {
+			c.requestSchemas()
+			return
+		}
+	case internalObject + ".Schemas":
+		if !c.gotSchemas(call) {
+			return
 		}
 	}
+	c.accept()
+}
 
-	// Defuse the timeout on ready
+// Start accepting calls, bypassing the control loop from now on.
+func (c *ctrl) accept() {
+	// Defuse the timeout on registration
 	c.timeoutCh = nil
+	c.open()
+	c.p.readyConn.Store(&conn{client: c.client, dc: c.dc})
+	c.brokenCh = c.dc.broken
+	c.startKeepAlive()
+	c.startLease()
+	c.timings.Total = c.elapsed()
+	timings := c.timings
+	c.p.timings.Store(&timings)
+	if c.p.readyFn != nil {
+		c.p.readyFn(timings)
+	}
+	c.p.setState(StateReady)
+	c.p.emit(Event{Type: EventPluginStarted})
+}
 
-	return true
+// Line printed by the plugin on stream.
+type printed struct {
+	line, stream string
 }
 
-func (c *ctrl) readOutput(r io.Reader) {
+func (c *ctrl) readOutput(r io.Reader, stream string) {
 	scanner := bufio.NewScanner(r)
+	if c.p.noise > 0 {
+		scanner.Split(scanLongLines)
+	}
 
 	for scanner.Scan() {
-		c.linesCh <- scanner.Text()
+		for _, line := range c.splitNoise(c.p.decodeOutput(scanner.Bytes())) {
+			// Plain output goes directly to file, skipping the control loop
+			if c.p.output != nil && !c.p.meta.matches(line) {
+				c.p.outputLine(line)
+				if err := c.p.output.writeLine(line); err != nil {
+					c.p.errorHandler().Error(err)
+				}
+				continue
+			}
+			c.linesCh <- printed{line: line, stream: stream}
+		}
+	}
+}
+
+func (c *ctrl) copyOutput(r io.Reader) {
+	if c.p.output == nil {
+		c.readOutput(r, StreamStderr)
+		return
+	}
+	if _, err := io.Copy(c.p.output, r); err != nil {
+		c.p.errorHandler().Error(err)
 	}
 }
 
+// Time to wait for the output of an exited plugin, held open by processes it started
+const outputWaitDelay = time.Second
+
 func (c *ctrl) waitErr(pidCh chan<- int, err error) {
 	close(pidCh)
 	c.waitCh <- err
 }
 
+// Set up cmd to start exe: activation socket, control key, checksum and sandbox.  Files
+// to be inherited are in cmd.ExtraFiles, also on error.  Returns the path of the
+// activation socket, if any.
+func (c *ctrl) prepare(cmd *exec.Cmd, argv0, exe string) (string, error) {
+	var path string
+	if c.p.activate {
+		f, p, err := activationFile(c.p.proto, c.p.unixdir)
+		if err != nil {
+			return "", err
+		}
+		path = p
+		setActivation(cmd, f)
+	}
+	if c.p.signed {
+		// Set before the pid is sent to the control loop
+		var err error
+		if c.mac, err = setControlKey(cmd, controlKeyFd); err != nil {
+			return path, err
+		}
+	}
+	if c.p.checksum != "" {
+		exePath := cmd.Path
+		var err error
+		if argv0 != exe {
+			// Started through a template
+			exePath, err = exec.LookPath(exe)
+		}
+		if err == nil {
+			err = verifyExecutable(exePath, c.p.checksum)
+		}
+		if err != nil {
+			return path, err
+		}
+	}
+	if c.p.sandbox != nil {
+		if err := c.p.sandbox.Wrap(cmd); err != nil {
+			return path, err
+		}
+	}
+	return path, nil
+}
+
 func (c *ctrl) wait(pidCh chan<- int, exe string, params ...string) {
 	defer close(c.waitCh)
 
-	cmd := exec.Command(exe, params...)
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		c.waitErr(pidCh, err)
-		return
+	argv := c.p.commandLine(exe, params)
+	cmd := exec.Command(argv[0], argv[1:]...)
+
+	// Closed once the process has exited and its output has been copied
+	stdout, stdoutW := io.Pipe()
+	stderr, stderrW := io.Pipe()
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	// Processes started by the plugin can keep its output open after it exited
+	cmd.WaitDelay = outputWaitDelay
+
+	path, err := c.prepare(cmd, argv[0], exe)
+	if path != "" {
+		// Already removed if the plugin got ready
+		defer os.Remove(path)
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		c.waitErr(pidCh, err)
-		return
+	files := cmd.ExtraFiles
+	if err == nil {
+		if c.p.cmdMod != nil {
+			c.p.cmdMod(cmd)
+		}
+		if err = startChild(cmd); err != nil {
+			err = execError(cmd.Path, err)
+		}
 	}
-	if err := cmd.Start(); err != nil {
+	// The plugin has its own copies of the inherited files, if it started at all
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
 		c.waitErr(pidCh, err)
 		return
 	}
@@ -379,10 +1081,30 @@ func (c *ctrl) wait(pidCh chan<- int, exe string, params ...string) {
 	pidCh <- cmd.Process.Pid
 	close(pidCh)
 
-	c.readOutput(stdout)
-	c.readOutput(stderr)
+	// Read stderr concurrently, a plugin writing to it must not block
+	errDone := make(chan struct{})
+	go func() {
+		c.copyOutput(stderr)
+		close(errDone)
+	}()
+	outDone := make(chan struct{})
+	go func() {
+		c.readOutput(stdout, StreamStdout)
+		close(outDone)
+	}()
+
+	err = cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The plugin itself exited successfully
+		err = nil
+	}
+	stdoutW.Close()
+	stderrW.Close()
+	<-outDone
+	<-errDone
 
-	c.waitCh <- cmd.Wait()
+	forgetChild(cmd.Process.Pid)
+	c.waitCh <- err
 }
 
 func (c *ctrl) kill() {
@@ -391,79 +1113,136 @@ func (c *ctrl) kill() {
 	}
 	// Ignore errors here because Kill might have been called after
 	// process has ended.
-	c.proc.Kill()
+	killProcess(c.proc)
+	c.killed = true
 	c.proc = nil
 }
 
 func (c *ctrl) parseReady(str string) error {
-	if !strings.HasPrefix(str, "proto=") {
-		return errInvalidMessage
+	proto, addr, err := parseReady(str)
+	if err != nil {
+		return err
 	}
-	str = str[6:]
-	s := strings.IndexByte(str, ' ')
-	if s < 0 {
+	c.proto = proto
+	c.addr = addr
+	return nil
+}
+
+func (c *ctrl) parseDelegate(str string) error {
+	if !strings.HasPrefix(str, "pid=") {
 		return errInvalidMessage
 	}
-	proto := str[0:s]
-	if proto != "unix" && proto != "tcp" {
+	pid, err := strconv.Atoi(str[4:])
+	if err != nil || pid <= 0 {
 		return errInvalidMessage
 	}
-	c.proto = proto
+	// Only processes started by the plugin can take over from it
+	if !isDescendant(pid, c.pid) {
+		return ErrInvalidMessage(fmt.Errorf("Delegate %d is not a descendant of process %d", pid, c.pid))
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	c.proc = proc
+	c.pid = pid
+	c.p.pid.Store(int64(pid))
+	c.delegated = true
+	return nil
+}
 
-	str = str[s+1:]
-	if !strings.HasPrefix(str, "addr=") {
-		return errInvalidMessage
+// Maximum number of parents looked up to find an ancestor of a process
+const maxProcessDepth = 64
+
+// Tells whether the process with pid is the process with ancestor or descends from it.
+func isDescendant(pid, ancestor int) bool {
+	for i := 0; i < maxProcessDepth; i++ {
+		if pid == ancestor {
+			return true
+		}
+		ppid, err := parentPid(pid)
+		if err != nil || ppid <= 1 || ppid == pid {
+			return false
+		}
+		pid = ppid
 	}
-	c.addr = str[5:]
+	return false
+}
 
-	return nil
+// Interval between checks that a delegate is still running
+const delegatePollInterval = 100 * time.Millisecond
+
+// Follow a delegate process that is not our child: it cannot be
+// waited for, so poll until it's gone.
+func watchDelegate(clock Clock, proc *os.Process, waitCh chan<- error) {
+	defer close(waitCh)
+
+	ticker := clock.NewTicker(delegatePollInterval)
+	defer ticker.Stop()
+
+	for delegateAlive(proc) {
+		<-ticker.C()
+	}
+	waitCh <- nil
 }
 
 // Copy the list of objects for the requestor
-func (c *ctrl) objects() []string {
-	list := make([]string, len(c.objs)-1)
-	for i, j := 0, 0; i < len(c.objs); i++ {
-		if c.objs[i] == internalObject {
-			continue
-		}
-		list[j] = c.objs[i]
-		j = j + 1
+func (c *ctrl) objects() ([]string, []ObjectInfo) {
+	list := make([]string, len(c.objs))
+	info := make([]ObjectInfo, len(c.objs))
+	for i := range c.objs {
+		list[i] = c.objs[i].Name
+		info[i] = c.objs[i]
 	}
-	return list
+	return list, info
 }
 
 func (p *Plugin) run() {
 	if p.unixdir == "" {
-		p.unixdir = os.TempDir()
+		p.unixdir = p.defaultSocketDir()
 	}
 
 	params := []string{
 		"-pingo:prefix=" + string(p.meta),
 		"-pingo:proto=" + p.proto,
 	}
-	if p.proto == "unix" && p.unixdir != "" {
+	if (p.proto == "unix" || p.proto == "fifo") && p.unixdir != "" {
 		params = append(params, "-pingo:unixdir="+p.unixdir)
 	}
-	for i := 0; i < len(p.params); i++ {
-		params = append(params, p.params[i])
+	if p.keepAlive > 0 {
+		params = append(params, "-pingo:keepalive="+p.keepAlive.String())
+	}
+	if hostInfo := p.startInfo(); hostInfo != nil {
+		if info, err := hostInfo.encode(); err == nil {
+			params = append(params, "-pingo:host="+info)
+		} else {
+			p.errorHandler().Error(err)
+		}
 	}
+	params = p.withParams(params)
 
 	c := newCtrl(p, p.initTimeout)
 
-	pidCh := make(chan int)
-	go c.wait(pidCh, p.exe, params...)
-	pid := <-pidCh
-
-	if pid != 0 {
-		if proc, err := os.FindProcess(pid); err == nil {
-			c.proc = proc
+	if p.attachTo != "" {
+		c.attach()
+	} else {
+		pidCh := make(chan int)
+		go c.wait(pidCh, p.exe, params...)
+		c.pid = <-pidCh
+		c.timings.Exec = c.elapsed()
+		p.pid.Store(int64(c.pid))
+
+		if c.pid != 0 {
+			if proc, err := os.FindProcess(c.pid); err == nil {
+				c.proc = proc
+			}
 		}
 	}
 
 	for {
 		select {
 		case <-c.timeoutCh:
-			c.fatal(errRegistrationTimeout)
+			c.fatal(c.registrationTimeout())
 		case r := <-c.connCh:
 			if c.isFatal() {
 				r.err = c.err
@@ -472,6 +1251,7 @@ func (p *Plugin) run() {
 			}
 
 			r.client = c.client
+			r.dc = c.dc
 			r.wr.done()
 		case o := <-c.objsCh:
 			if c.isFatal() {
@@ -480,10 +1260,11 @@ func (p *Plugin) run() {
 				continue
 			}
 
-			o.list = c.objects()
+			o.list, o.info = c.objects()
 			o.wr.done()
-		case line := <-c.linesCh:
-			key, val := p.meta.parse(line)
+		case out := <-c.linesCh:
+			line := out.line
+			key, val := c.parse(line)
 			switch key {
 			case "auth-token":
 				c.secret = val
@@ -495,23 +1276,65 @@ func (p *Plugin) run() {
 				}
 			case "error":
 				if err := parseError(val); err != nil {
-					p.handler.Print(err)
+					p.errorHandler().Print(err)
 				} else {
-					p.handler.Print(errors.New(val))
+					p.errorHandler().Print(errors.New(val))
 				}
 			case "objects":
-				c.objs = strings.Split(val, ", ")
+				c.timings.Objects = c.elapsed()
+				c.objs = make([]ObjectInfo, 0)
+				for _, name := range parseObjects(val) {
+					if !isInternalObject(name) {
+						c.objs = append(c.objs, ObjectInfo{Name: name})
+					}
+				}
+			case "delegate":
+				if err := c.parseDelegate(val); err != nil {
+					c.fatal(err)
+				}
 			case "ready":
-				if !c.ready(val) {
-					continue
+				if c.ready(val) {
+					c.requestObjects()
 				}
-				// Start accepting calls
-				c.open()
 			default:
-				p.handler.Print(line)
+				c.plainOutput(line, out.stream)
 			}
+		case call := <-c.handshakeCh:
+			c.handshakeReply(call)
+		case <-c.exitTimeoutCh:
+			// Still running after Exit.  The process handle is dropped as soon as
+			// the exit is notified, so we never signal a recycled pid.
+			c.exitTimeoutCh = nil
+			c.kill()
+		case <-c.brokenCh:
+			c.connBroken()
+		case <-c.brokenTimeoutCh:
+			c.brokenTimeoutCh = nil
+			if !c.isFatal() {
+				c.fatal(lostConnection(c.dc.breakErr))
+			}
+		case <-c.keepaliveCh:
+			c.ping()
+		case err := <-c.pingCh:
+			c.pong(err)
+		case s := <-p.sigCh:
+			c.signal(s)
+			s.wr.done()
+		case err := <-p.failCh:
+			c.fatal(err)
 		case wr := <-p.killCh:
 			if c.waitCh == nil {
+				// Attached plugins keep running, only disconnect
+				if p.attachTo != "" && c.client != nil {
+					c.client.Close()
+					c.refuse(errNotRunning)
+					c.stopKeepAlive()
+				}
+				if p.attachTo != "" {
+					p.stopRes = StopResult{Reason: StopDetached, ExitCode: -1}
+				} else {
+					p.stopRes = c.result
+				}
 				wr.done()
 				continue
 			}
@@ -519,45 +1342,97 @@ func (p *Plugin) run() {
 			// If we don't accept calls, kill immediately
 			if c.connCh == nil || c.client == nil {
 				c.kill()
+				if c.client != nil {
+					c.client.Close()
+				}
 			} else {
 				// Be sure to kill the process if it doesn't obey Exit.
-				go func(pid int, t time.Duration) {
-					<-time.After(t)
-
-					if proc, err := os.FindProcess(pid); err == nil {
-						proc.Kill()
-					}
-				}(pid, p.exitTimeout)
-
-				c.client.Call(internalObject+".Exit", 0, nil)
-			}
-
-			if c.client != nil {
-				c.client.Close()
+				c.exitTimer = p.clock.NewTimer(p.exitTimeout)
+				c.exitTimeoutCh = c.exitTimer.C()
+
+				// Exit can wait behind running calls: keep serving the control loop,
+				// so that the exit timeout and kill requests are handled meanwhile
+				c.wg.Add(1)
+				go func(client *rpc.Client) {
+					defer c.wg.Done()
+					client.Call(internalObject+".Exit", 0, nil)
+					client.Close()
+				}(c.client)
 			}
 
 			// Do not accept calls
-			c.close()
+			c.refuse(errNotRunning)
+			c.stopKeepAlive()
 
 			// When wait on the subprocess is exited, signal back via "over"
 			c.over = wr
 		case err := <-c.waitCh:
+			// The started process has exited, but its delegate is still running
+			if c.delegated && c.proc != nil {
+				c.delegated = false
+				c.watched = true
+				c.linesCh = nil
+				c.waitCh = make(chan error)
+				c.wg.Add(1)
+				go func(proc *os.Process, waitCh chan<- error) {
+					defer c.wg.Done()
+					watchDelegate(c.p.clock, proc, waitCh)
+				}(c.proc, c.waitCh)
+				continue
+			}
+
+			c.exited(err)
+			if p.State() == StateStarting && c.over == nil {
+				err = c.crashedOnStartup(err)
+			}
+			if p.State() == StateReady && c.over == nil && !c.isFatal() {
+				died := c.diedMidCall(err)
+				p.died.Store(&died)
+			}
 			if err != nil {
 				if _, ok := err.(*exec.ExitError); !ok {
-					p.handler.Error(err)
+					p.errorHandler().Error(err)
 				}
 				c.fatal(err)
 			}
+			p.pid.Store(0)
+
+			p.setLost()
 
 			// Signal to whoever killed us (via killCh) that we are done
 			if c.over != nil {
+				p.stopRes = c.result
 				c.over.done()
 			}
 
+			if !c.isFatal() {
+				p.setState(StateStopped)
+			}
+
+			p.readyConn.Store(nil)
+			if c.client != nil {
+				c.client.Close()
+			}
+
+			if c.exitTimer != nil {
+				c.exitTimer.Stop()
+				c.exitTimeoutCh = nil
+			}
+			if c.brokenTimer != nil {
+				c.brokenTimer.Stop()
+				c.brokenTimeoutCh = nil
+			}
+
+			c.stopKeepAlive()
+			c.refuse(errNotRunning)
 			c.proc = nil
 			c.waitCh = nil
 			c.linesCh = nil
 		case <-p.exitCh:
+			c.stopKeepAlive()
+			c.stopLease()
+			c.wg.Wait()
+			close(p.done)
 			return
 		}
 	}
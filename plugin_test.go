@@ -0,0 +1,95 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"testing"
+)
+
+// Start the hello-world fixture and wait until it is ready.
+func startBenchFixture(b *testing.B) *Plugin {
+	p := newFixture(b, "unix", "pingo-hello-world")
+	p.Start()
+	if err := sayHello(p); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { p.Stop() })
+	return p
+}
+
+// Get the connection through the control loop, as before the ready connection was
+// cached.
+func connectLoop(p *Plugin) (*conn, error) {
+	c := &conn{wr: newWaiter()}
+	p.connCh <- c
+	<-c.wr.c
+	return c, c.err
+}
+
+func BenchmarkCall(b *testing.B) {
+	p := startBenchFixture(b)
+	var resp string
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Call("Plugin.SayHello", "pingo", &resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCallParallel(b *testing.B) {
+	p := startBenchFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var resp string
+		for pb.Next() {
+			if err := p.Call("Plugin.SayHello", "pingo", &resp); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Calls getting their connection through the control loop, for comparison.
+func BenchmarkCallControlLoop(b *testing.B) {
+	p := startBenchFixture(b)
+	var resp string
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, err := connectLoop(p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := c.client.Call("Plugin.SayHello", "pingo", &resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConnect(b *testing.B) {
+	p := startBenchFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.connect(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConnectControlLoop(b *testing.B) {
+	p := startBenchFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := connectLoop(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"sync/atomic"
+)
+
+// Pool spreads calls over instances of a plugin, see NewPool.
+type Pool struct {
+	callers []Caller
+	next    atomic.Uint64
+}
+
+// NewPool returns a pool performing calls on callers, usually instances of the same
+// plugin.  Call and CallContext use the callers in turn; CallSticky sends the calls with
+// the same key to the same caller, for plugins keeping state in memory, for example by
+// session.
+//
+// Panics if callers is empty.
+func NewPool(callers ...Caller) *Pool {
+	if len(callers) == 0 {
+		panic("No callers passed to NewPool")
+	}
+	return &Pool{callers: append([]Caller(nil), callers...)}
+}
+
+// Call performs the call on the next caller of the pool.
+func (p *Pool) Call(name string, args interface{}, resp interface{}) error {
+	return p.pick().Call(name, args, resp)
+}
+
+// CallContext performs the call on the next caller of the pool.
+func (p *Pool) CallContext(ctx context.Context, name string, args interface{}, resp interface{}) error {
+	return p.pick().CallContext(ctx, name, args, resp)
+}
+
+// CallSticky performs the call on the caller of the pool chosen for key: calls with the
+// same key always go to the same caller.  If that caller fails, the call fails too: it
+// is not performed on another caller, which would not have the state for key.
+func (p *Pool) CallSticky(key string, name string, args interface{}, resp interface{}) error {
+	return p.sticky(key).Call(name, args, resp)
+}
+
+// CallStickyContext is like CallSticky, but gives up when ctx is done, see CallContext.
+func (p *Pool) CallStickyContext(ctx context.Context, key string, name string, args interface{}, resp interface{}) error {
+	return p.sticky(key).CallContext(ctx, name, args, resp)
+}
+
+// Choose the caller for a call without key.
+func (p *Pool) pick() Caller {
+	return p.callers[(p.next.Add(1)-1)%uint64(len(p.callers))]
+}
+
+// Choose the caller for the calls with key.
+func (p *Pool) sticky(key string) Caller {
+	sum := fnv.New64a()
+	io.WriteString(sum, key)
+	return p.callers[sum.Sum64()%uint64(len(p.callers))]
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// Preflight checks that the plugin can be started, without starting it: the executable
+// must exist and be executable, match the checksum set with SetChecksum, and for the
+// "unix" and "fifo" protocols the socket directory must be writable.  A wrapper set with
+// SetCommandTemplate must be found as well.  Use it to validate plugins when they are
+// installed.  See SelfCheck to also run the plugin.
+//
+// Attached plugins have no executable to check, Preflight returns nil for them.
+func (p *Plugin) Preflight() error {
+	if p.attachTo != "" {
+		return nil
+	}
+	path, err := exec.LookPath(p.exe)
+	if err != nil {
+		return errors.New("Plugin executable is not usable: " + err.Error())
+	}
+	if p.checksum != "" {
+		if err := verifyExecutable(path, p.checksum); err != nil {
+			return err
+		}
+	}
+	if argv := p.commandLine(p.exe, nil); argv[0] != p.exe {
+		if _, err := exec.LookPath(argv[0]); err != nil {
+			return errors.New("Plugin command template is not usable: " + err.Error())
+		}
+	}
+	if p.proto == "unix" || p.proto == "fifo" {
+		dir := p.unixdir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		f, err := os.CreateTemp(dir, "pingo-preflight-")
+		if err != nil {
+			return errors.New("Socket directory is not writable: " + err.Error())
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority of a call, used by plugins limiting the number of concurrent calls
+// to choose which waiting call runs next.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// Options of a single call.
+type CallOpts struct {
+	// Calls with a higher priority run before waiting calls of lower priority.
+	// Plugins built with older versions of this package only accept PriorityNormal.
+	Priority Priority
+	// Whether the call can be sent again, AtMostOnce by default.  Plugins built with
+	// older versions of this package run every attempt of AtLeastOnce calls.
+	Semantics Semantics
+	// Number of times an AtLeastOnce call is sent again when not answered within
+	// AttemptTimeout; the last attempt waits for the answer
+	Retries        int
+	AttemptTimeout time.Duration
+	// Set to allow cancelling the call
+	id CallID
+	// Time left to the plugin to answer, if set
+	timeout time.Duration
+	// Session and transaction the call is made in, if any
+	session SessionID
+	tx      TxID
+}
+
+// Options are sent to the plugin as parameters following the method name, so that
+// calls without options can be served by plugins not knowing about them.
+const (
+	priorityParam = "priority"
+	idParam       = "id"
+	timeoutParam  = "timeout"
+)
+
+// Options of a call as received by the plugin.
+type methodParams struct {
+	priority Priority
+	id       CallID
+	timeout  time.Duration
+	// Name of the compressor of the body, if compressed
+	compressor string
+	// Run the request once for all requests with the same ID
+	once    bool
+	session SessionID
+	tx      TxID
+}
+
+func (o CallOpts) method(name string) string {
+	if o.Priority != PriorityNormal {
+		name += ";" + priorityParam + "=" + strconv.Itoa(int(o.Priority))
+	}
+	if o.id != 0 {
+		name += ";" + idParam + "=" + strconv.FormatUint(uint64(o.id), 10)
+	}
+	if o.timeout > 0 {
+		name += ";" + timeoutParam + "=" + strconv.FormatInt(int64(o.timeout), 10)
+	}
+	if o.Semantics == AtLeastOnce && o.id != 0 {
+		name += ";" + onceParam
+	}
+	if o.session != 0 {
+		name += ";" + sessionParam + "=" + strconv.FormatUint(uint64(o.session), 10)
+	}
+	if o.tx != 0 {
+		name += ";" + transactionParam + "=" + strconv.FormatUint(uint64(o.tx), 10)
+	}
+	return name
+}
+
+// Split the method name and the parameters of a request.
+func parseMethod(s string) (string, methodParams) {
+	var m methodParams
+	name, params, _ := strings.Cut(s, ";")
+	for params != "" {
+		var param string
+		param, params, _ = strings.Cut(params, ";")
+		key, val, _ := strings.Cut(param, "=")
+		switch key {
+		case priorityParam:
+			n, _ := strconv.Atoi(val)
+			m.priority = Priority(n)
+			if m.priority < PriorityLow {
+				m.priority = PriorityLow
+			}
+			if m.priority > PriorityHigh {
+				m.priority = PriorityHigh
+			}
+		case idParam:
+			id, _ := strconv.ParseUint(val, 10, 64)
+			m.id = CallID(id)
+		case timeoutParam:
+			t, _ := strconv.ParseInt(val, 10, 64)
+			m.timeout = time.Duration(t)
+		case compressParam:
+			m.compressor = val
+		case onceParam:
+			m.once = true
+		case sessionParam:
+			id, _ := strconv.ParseUint(val, 10, 64)
+			m.session = SessionID(id)
+		case transactionParam:
+			id, _ := strconv.ParseUint(val, 10, 64)
+			m.tx = TxID(id)
+		}
+	}
+	return name, m
+}
+
+// CallWithOpts is like Call, with the options in opts.
+func (p *Plugin) CallWithOpts(name string, args interface{}, resp interface{}, opts CallOpts) error {
+	return p.callWith(context.Background(), name, args, resp, opts)
+}
+
+// SetMaxConcurrentCalls limits the number of calls this plugin runs at the same time.
+// Further calls wait until a running one returns, and run by priority (see CallOpts),
+// then in order of arrival.  Internal calls from the host, like health checks, lease
+// renewals and cancellations, are never held.  Zero, the default, means no limit.
+//
+// SetMaxConcurrentCalls will panic if called after Run.
+func SetMaxConcurrentCalls(n int) {
+	if defaultServer.running {
+		panic("Do not call SetMaxConcurrentCalls after Run")
+	}
+	defaultServer.calls = nil
+	if n > 0 {
+		defaultServer.calls = &callQueue{max: n}
+	}
+}
+
+// Semaphore granting slots by priority.
+type callQueue struct {
+	mux     sync.Mutex
+	active  int
+	max     int
+	waiting [PriorityHigh - PriorityLow + 1][]chan struct{}
+}
+
+func (q *callQueue) acquire(prio Priority) {
+	q.mux.Lock()
+	if q.active < q.max {
+		q.active++
+		q.mux.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	i := prio - PriorityLow
+	q.waiting[i] = append(q.waiting[i], ch)
+	q.mux.Unlock()
+	<-ch
+}
+
+// Pass the slot to the first waiting call of highest priority.
+func (q *callQueue) release() {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	for i := len(q.waiting) - 1; i >= 0; i-- {
+		if len(q.waiting[i]) > 0 {
+			ch := q.waiting[i][0]
+			q.waiting[i] = q.waiting[i][1:]
+			close(ch)
+			return
+		}
+	}
+	q.active--
+}
+
+// Codec for a single request, run by a dispatcher holding calls until they get a slot.
+type dispatchCodec struct {
+	*serverCodec
+	queue *callQueue
+	// Serializes responses, as each request is served separately
+	wmux *sync.Mutex
+	// Receives the result of reading the request, after which the next one can be read
+	readDone chan<- error
+	held     bool
+	// Internal requests, like pings, lease renewals, cancellations and waits for the
+	// values of a topic, do not take a slot
+	internal bool
+}
+
+func (c *dispatchCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.serverCodec.ReadRequestHeader(r)
+	if err != nil {
+		c.readDone <- err
+	}
+	c.internal = isInternalObject(r.ServiceMethod)
+	return err
+}
+
+func (c *dispatchCodec) ReadRequestBody(body interface{}) error {
+	prio := c.serverCodec.params.priority
+	err := c.serverCodec.ReadRequestBody(body)
+	c.readDone <- nil
+	if err == nil && body != nil && !c.internal {
+		c.queue.acquire(prio)
+		c.held = true
+	}
+	return err
+}
+
+func (c *dispatchCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.wmux.Lock()
+	err := c.serverCodec.WriteResponse(r, body)
+	c.wmux.Unlock()
+	if c.held {
+		c.held = false
+		c.queue.release()
+	}
+	return err
+}
+
+func (c *dispatchCodec) Close() error {
+	return nil
+}
+
+// Like ServeCodec, but limiting the calls running at the same time.
+func (r *rpcServer) dispatch(server *rpc.Server, codec *serverCodec) {
+	var wmux sync.Mutex
+	for {
+		readDone := make(chan error, 1)
+		go server.ServeRequest(&dispatchCodec{
+			serverCodec: codec,
+			queue:       r.calls,
+			wmux:        &wmux,
+			readDone:    readDone,
+		})
+		if err := <-readDone; err != nil {
+			break
+		}
+	}
+	codec.Close()
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInternalCallsNotHeld(t *testing.T) {
+	p := newFixture(t, "unix", "pingo-busy")
+	p.Start()
+	defer p.Stop()
+
+	// Wait for the plugin, then take its only slot
+	if _, err := p.Objects(); err != nil {
+		t.Fatal(err)
+	}
+	go p.Call("Plugin.Block", 3000, nil)
+	time.Sleep(200 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Health()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("health check held behind a running call")
+	}
+}
+
+func TestCallContextLateAnswer(t *testing.T) {
+	p := newFixture(t, "unix", "pingo-busy")
+	p.Start()
+	defer p.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var slept int
+	if err := p.CallContext(ctx, "Plugin.Block", 300, &slept); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// Give the answer time to arrive
+	time.Sleep(500 * time.Millisecond)
+	if slept != 0 {
+		t.Fatalf("response set to %d after the call returned", slept)
+	}
+
+	if err := p.Call("Plugin.Block", 10, &slept); err != nil {
+		t.Fatal(err)
+	}
+	if slept != 10 {
+		t.Fatalf("unexpected response %d", slept)
+	}
+}
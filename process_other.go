@@ -0,0 +1,43 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package pingo
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Error starting executables not for this system
+const errBadExeFormat = syscall.ENOEXEC
+
+func prepareProcess(cmd *exec.Cmd) {}
+
+func trackProcess(cmd *exec.Cmd) error {
+	return nil
+}
+
+func untrackProcess(pid int) {}
+
+func killProcess(proc *os.Process) error {
+	return proc.Kill()
+}
+
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+// Signals are sent through a descriptor of proc where supported, so that a reused
+// pid is not taken for the delegate.
+func delegateAlive(proc *os.Process) bool {
+	return proc.Signal(syscall.Signal(0)) == nil
+}
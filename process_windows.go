@@ -0,0 +1,205 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package pingo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Each plugin process is started suspended and assigned to a job object before it
+// runs, so that stopping the plugin also terminates any process it started.  Taskkill
+// is used for processes not in a job, like delegates.
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+
+	ntdll               = syscall.NewLazyDLL("ntdll.dll")
+	procNtResumeProcess = ntdll.NewProc("NtResumeProcess")
+)
+
+const (
+	jobObjectExtendedLimitInformation = 9
+	jobObjectLimitKillOnJobClose      = 0x2000
+
+	createSuspended = 0x00000004
+
+	processSetQuota                = 0x0100
+	processTerminate               = 0x0001
+	processSuspendResume           = 0x0800
+	processQueryLimitedInformation = 0x1000
+
+	stillActive = 259
+)
+
+// Error starting executables not for this system (ERROR_BAD_EXE_FORMAT)
+const errBadExeFormat syscall.Errno = 193
+
+type jobObjectBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+type jobObjectExtendedLimitInformationT struct {
+	BasicLimitInformation jobObjectBasicLimitInformation
+	IoInfo                ioCounters
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+var (
+	jobMux sync.Mutex
+	jobs   = make(map[int]syscall.Handle)
+)
+
+func newJob() (syscall.Handle, error) {
+	h, _, err := procCreateJobObjectW.Call(0, 0)
+	if h == 0 {
+		return 0, err
+	}
+	job := syscall.Handle(h)
+
+	// Terminate the processes if the host exits without stopping the plugin
+	var info jobObjectExtendedLimitInformationT
+	info.BasicLimitInformation.LimitFlags = jobObjectLimitKillOnJobClose
+	r, _, err := procSetInformationJobObject.Call(uintptr(job), jobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if r == 0 {
+		syscall.CloseHandle(job)
+		return 0, err
+	}
+	return job, nil
+}
+
+func prepareProcess(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= createSuspended
+}
+
+// Assign the suspended process to a new job and let it run.  The process runs
+// outside of a job if none can be created.
+func trackProcess(cmd *exec.Cmd) error {
+	proc, err := syscall.OpenProcess(processSetQuota|processTerminate|processSuspendResume, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		// Cannot be resumed
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	defer syscall.CloseHandle(proc)
+
+	if job, err := newJob(); err == nil {
+		if r, _, _ := procAssignProcessToJobObject.Call(uintptr(job), uintptr(proc)); r != 0 {
+			jobMux.Lock()
+			jobs[cmd.Process.Pid] = job
+			jobMux.Unlock()
+		} else {
+			syscall.CloseHandle(job)
+		}
+	}
+
+	if r, _, _ := procNtResumeProcess.Call(uintptr(proc)); r != 0 {
+		untrackProcess(cmd.Process.Pid)
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("Cannot resume plugin process: status 0x%x", r)
+	}
+	return nil
+}
+
+func untrackProcess(pid int) {
+	jobMux.Lock()
+	defer jobMux.Unlock()
+
+	if job, ok := jobs[pid]; ok {
+		syscall.CloseHandle(job)
+		delete(jobs, pid)
+	}
+}
+
+func killProcess(proc *os.Process) error {
+	jobMux.Lock()
+	job, ok := jobs[proc.Pid]
+	jobMux.Unlock()
+
+	if ok {
+		if r, _, _ := procTerminateJobObject.Call(uintptr(job), 1); r != 0 {
+			return nil
+		}
+	}
+	// Not started by us (a delegate) or not in a job: kill the whole tree
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(proc.Pid)).Run(); err == nil {
+		return nil
+	}
+	return proc.Kill()
+}
+
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// The handle held by proc keeps its pid from being reused.
+func delegateAlive(proc *os.Process) bool {
+	return processAlive(proc.Pid)
+}
+
+func parentPid(pid int) (int, error) {
+	snap, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.CloseHandle(snap)
+
+	var e syscall.ProcessEntry32
+	e.Size = uint32(unsafe.Sizeof(e))
+	for err = syscall.Process32First(snap, &e); err == nil; err = syscall.Process32Next(snap, &e) {
+		if int(e.ProcessID) == pid {
+			return int(e.ParentProcessID), nil
+		}
+	}
+	return 0, err
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// ReapedProcess reports the exit of an orphaned process reaped by the host.
+type ReapedProcess struct {
+	Pid int
+	// Exit code of the process, or -1 if it was terminated by a signal
+	ExitCode int
+}
+
+var (
+	// Processes started as plugins are waited for by their own control loop.
+	childMux  sync.Mutex
+	childPids = make(map[int]struct{})
+
+	reapMux       sync.Mutex
+	reapListeners []chan<- ReapedProcess
+	reapOnce      sync.Once
+	reapErr       error
+)
+
+// Start cmd making sure the reaper doesn't collect it.
+func startChild(cmd *exec.Cmd) error {
+	childMux.Lock()
+	defer childMux.Unlock()
+
+	prepareProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := trackProcess(cmd); err != nil {
+		return err
+	}
+	childPids[cmd.Process.Pid] = struct{}{}
+	return nil
+}
+
+func forgetChild(pid int) {
+	childMux.Lock()
+	defer childMux.Unlock()
+	delete(childPids, pid)
+	untrackProcess(pid)
+}
+
+func startReaper() error {
+	reapOnce.Do(func() {
+		reapErr = setSubreaper()
+		if reapErr == nil {
+			go watchOrphans()
+		}
+	})
+	return reapErr
+}
+
+func addReapListener(ch chan<- ReapedProcess) {
+	reapMux.Lock()
+	defer reapMux.Unlock()
+	reapListeners = append(reapListeners, ch)
+}
+
+func reportReaped(r ReapedProcess) {
+	reapMux.Lock()
+	defer reapMux.Unlock()
+
+	for _, ch := range reapListeners {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const prSetChildSubreaper = 36
+
+func setSubreaper() error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Interval between scans for the descendants of plugins
+const descendantsInterval = 200 * time.Millisecond
+
+// Processes descending from plugins, by pid, with their start time to detect reused
+// pids.  Only these are reaped once re-parented to the host: other children of the
+// host are waited for by the code that started them.  Guarded by childMux.
+var descendants = make(map[int]uint64)
+
+func watchOrphans() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGCHLD)
+
+	// Descendants must be found before their parent exits and they are re-parented
+	ticker := time.NewTicker(descendantsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ch:
+		case <-ticker.C:
+		}
+		reapOrphans()
+	}
+}
+
+// Fields of /proc/<pid>/stat
+type procStat struct {
+	state byte
+	ppid  int
+	// Start time since boot, in clock ticks
+	start uint64
+}
+
+// Return state, parent pid and start time from the contents of /proc/<pid>/stat
+func parseProcStat(stat []byte) (procStat, bool) {
+	// The command name can contain spaces and parens; skip to its end.
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 || end+2 >= len(stat) {
+		return procStat{}, false
+	}
+	// Fields from the third, state; start time is the twenty-second
+	fields := bytes.Fields(stat[end+2:])
+	if len(fields) < 20 || len(fields[0]) != 1 {
+		return procStat{}, false
+	}
+	ppid, err := strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return procStat{}, false
+	}
+	start, err := strconv.ParseUint(string(fields[19]), 10, 64)
+	if err != nil {
+		return procStat{}, false
+	}
+	return procStat{state: fields[0][0], ppid: ppid, start: start}, true
+}
+
+func parentPid(pid int) (int, error) {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	st, ok := parseProcStat(stat)
+	if !ok {
+		return 0, errors.New("Invalid stat of process " + strconv.Itoa(pid))
+	}
+	return st.ppid, nil
+}
+
+// Return the processes of the system by pid.
+func readProcStats() map[int]procStat {
+	procs := make(map[int]procStat)
+	names, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return procs
+	}
+	for _, name := range names {
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(name)))
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		if st, ok := parseProcStat(stat); ok {
+			procs[pid] = st
+		}
+	}
+	return procs
+}
+
+// Record the descendants of plugins, then wait for those re-parented to the host that
+// have exited.  Direct children of the host that are not plugins are never waited for.
+func reapOrphans() {
+	childMux.Lock()
+	defer childMux.Unlock()
+
+	if len(childPids) == 0 && len(descendants) == 0 {
+		return
+	}
+	procs := readProcStats()
+
+	// Forget descendants that are gone, or whose pid was reused
+	for pid, start := range descendants {
+		if st, ok := procs[pid]; !ok || st.start != start {
+			delete(descendants, pid)
+		}
+	}
+
+	children := make(map[int][]int)
+	for pid, st := range procs {
+		children[st.ppid] = append(children[st.ppid], pid)
+	}
+	var queue []int
+	for pid := range childPids {
+		queue = append(queue, pid)
+	}
+	for pid := range descendants {
+		queue = append(queue, pid)
+	}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			if _, ok := descendants[child]; ok {
+				continue
+			}
+			descendants[child] = procs[child].start
+			queue = append(queue, child)
+		}
+	}
+
+	self := os.Getpid()
+	for pid := range descendants {
+		if st := procs[pid]; st.state != 'Z' || st.ppid != self {
+			continue
+		}
+		var ws syscall.WaitStatus
+		if wpid, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); err != nil || wpid != pid {
+			continue
+		}
+		delete(descendants, pid)
+		reportReaped(ReapedProcess{Pid: pid, ExitCode: ws.ExitStatus()})
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestReapOnlyPluginDescendants(t *testing.T) {
+	m := NewManager()
+	if err := m.EnableSubreaper(); err != nil {
+		t.Skip(err)
+	}
+
+	// Leaves a process behind when the plugin is stopped
+	p := newFixture(t, "unix", "pingo-hello-world")
+	p.SetCmdModifier(func(cmd *exec.Cmd) {
+		cmd.Args = append([]string{"/bin/sh", "-c", `sleep 1 & exec "$0" "$@"`}, cmd.Args...)
+		cmd.Path = "/bin/sh"
+	})
+	p.Start()
+	if err := sayHello(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// A child of the host, not of a plugin, exiting while the reaper runs
+	own := exec.Command("/bin/sh", "-c", "exit 3")
+	if err := own.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * descendantsInterval)
+	if err := own.Wait(); err == nil || own.ProcessState.ExitCode() != 3 {
+		t.Fatalf("child of the host lost its exit status: %v", err)
+	}
+
+	p.Stop()
+	select {
+	case r := <-m.Reaped():
+		if r.ExitCode != 0 {
+			t.Fatalf("orphan %d exited with %d", r.Pid, r.ExitCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("orphan of the plugin not reaped")
+	}
+}
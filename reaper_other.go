@@ -0,0 +1,16 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package pingo
+
+import "errors"
+
+func setSubreaper() error {
+	return errors.New("Subreaper mode is only supported on Linux")
+}
+
+func watchOrphans() {}
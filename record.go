@@ -0,0 +1,161 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// A call and its result, as recorded.
+type callRecord struct {
+	Method string
+	// Arguments and response, encoded with gob
+	Args  []byte
+	Reply []byte
+	Err   string
+}
+
+// Writer of call records.
+type recorder struct {
+	mux sync.Mutex
+	enc *gob.Encoder
+}
+
+// SetRecorder makes the plugin write all calls to w, with their arguments and results,
+// for NewReplayPlugin to replay them.  Calls to internal objects are not recorded.
+// Errors writing to w are reported to the ErrorHandler.
+//
+// Panics if called after Start.
+func (p *Plugin) SetRecorder(w io.Writer) {
+	if p.running {
+		panic("Cannot call SetRecorder after Start")
+	}
+	p.recorder = &recorder{enc: gob.NewEncoder(w)}
+}
+
+func (r *recorder) record(p *Plugin, method string, args interface{}, resp interface{}, err error) {
+	rec := callRecord{Method: method}
+	var encErr error
+	if rec.Args, encErr = encodeValue(args); encErr == nil && err == nil {
+		rec.Reply, encErr = encodeValue(resp)
+	}
+	if err != nil {
+		rec.Err = err.Error()
+	}
+	if encErr == nil {
+		r.mux.Lock()
+		encErr = r.enc.Encode(&rec)
+		r.mux.Unlock()
+	}
+	if encErr != nil {
+		p.errorHandler().Error(errors.New("Cannot record call to " + method + ": " + encErr.Error()))
+	}
+}
+
+// Encode a value with gob, nil if v is.
+func encodeValue(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReplayPlugin answers calls with the results recorded from a plugin, see NewReplayPlugin.
+type ReplayPlugin struct {
+	mux     sync.Mutex
+	records []callRecord
+	// Whether each record has been replayed
+	used []bool
+}
+
+// NewReplayPlugin returns a caller answering calls with the results recorded by
+// SetRecorder in r, without running the plugin.  A call gets the result of the first
+// recorded call to the same method, with equal arguments, that was not replayed yet, or
+// of the last one if all were.  Calls that were not recorded return ErrNotRecorded.
+//
+// Returns an error if r cannot be read.
+func NewReplayPlugin(r io.Reader) (*ReplayPlugin, error) {
+	p := &ReplayPlugin{}
+	dec := gob.NewDecoder(r)
+	for {
+		var rec callRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		p.records = append(p.records, rec)
+	}
+	p.used = make([]bool, len(p.records))
+	return p, nil
+}
+
+func (p *ReplayPlugin) Call(name string, args interface{}, resp interface{}) error {
+	rec, ok := p.find(name, args)
+	if !ok {
+		return ErrNotRecorded(errors.New("Call to " + name + " was not recorded with these arguments"))
+	}
+	if rec.Err != "" {
+		return errors.New(rec.Err)
+	}
+	if resp != nil && rec.Reply != nil {
+		return decodeValue(rec.Reply, resp)
+	}
+	return nil
+}
+
+// CallContext is like Call, but returns the error of ctx if it is done.
+func (p *ReplayPlugin) CallContext(ctx context.Context, name string, args interface{}, resp interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.Call(name, args, resp)
+}
+
+// Find the record answering a call.
+func (p *ReplayPlugin) find(method string, args interface{}) (callRecord, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	last := -1
+	for i, rec := range p.records {
+		if rec.Method != method || !recordedArgs(rec.Args, args) {
+			continue
+		}
+		if !p.used[i] {
+			p.used[i] = true
+			return rec, true
+		}
+		last = i
+	}
+	if last < 0 {
+		return callRecord{}, false
+	}
+	return p.records[last], true
+}
+
+// Whether the recorded arguments equal args.  Encodings are not compared, as gob
+// encodes maps in random order.
+func recordedArgs(data []byte, args interface{}) bool {
+	if data == nil || args == nil {
+		return data == nil && args == nil
+	}
+	v := reflect.New(reflect.TypeOf(args))
+	if err := decodeValue(data, v.Interface()); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(v.Elem().Interface(), args)
+}
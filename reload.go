@@ -0,0 +1,89 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// Reload asks the plugin to reload its configuration by running all functions
+// registered in the plugin via OnReload.  Returns the errors returned by them.
+//
+// Like Call, Reload will wait until the plugin has been initialized, or until
+// ctx is done.
+func (p *Plugin) Reload(ctx context.Context) error {
+	return p.callContext(ctx, internalObject+".Reload", 0, nil)
+}
+
+// Reload broadcasts a reload request to all plugins added to the manager.  The
+// first error encountered is returned after all plugins have been asked to reload.
+func (m *Manager) Reload(ctx context.Context) error {
+	var err error
+	for _, name := range m.Names() {
+		p := m.Plugin(name)
+		if p == nil {
+			continue
+		}
+		if e := p.Reload(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// ReloadOn makes the manager broadcast a Reload to all its plugins each time the host
+// receives one of sigs, typically syscall.SIGHUP.  Errors are reported to each plugin's
+// ErrorHandler.
+func (m *Manager) ReloadOn(sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		for range ch {
+			for _, name := range m.Names() {
+				p := m.Plugin(name)
+				if p == nil {
+					continue
+				}
+				if err := p.Reload(context.Background()); err != nil {
+					p.errorHandler().Error(err)
+				}
+			}
+		}
+	}()
+}
+
+var (
+	reloadMux   sync.Mutex
+	reloadHooks []func() error
+)
+
+// OnReload registers fn to be called each time the host requests a reload via
+// Plugin.Reload.  Errors returned by fn are reported back to the host.
+func OnReload(fn func() error) {
+	reloadMux.Lock()
+	defer reloadMux.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+}
+
+// Internal RPC call to run the reload hooks. Do not call manually.
+func (s *PingoRpc) Reload(unused int, unused2 *int) error {
+	reloadMux.Lock()
+	hooks := make([]func() error, len(reloadHooks))
+	copy(hooks, reloadHooks)
+	reloadMux.Unlock()
+
+	var errs []error
+	for _, fn := range hooks {
+		if err := fn(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
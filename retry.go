@@ -0,0 +1,198 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Semantics tells whether a call may be sent to the plugin more than once.
+type Semantics int
+
+const (
+	// The call is sent once: if no answer arrives, the method may or may not have run
+	AtMostOnce Semantics = iota
+	// The call is sent again when not answered in time, see CallOpts.Retries.  The
+	// plugin runs the method once and answers all attempts with its result
+	AtLeastOnce
+)
+
+// Parameter marking requests that the plugin must run once for all attempts
+const onceParam = "once"
+
+// Time the plugin keeps the results of calls for their retries
+const onceResultTTL = 5 * time.Minute
+
+// Send the call again with the same ID each time an attempt is not answered within
+// opts.AttemptTimeout, up to opts.Retries times, and return the first answer.  As
+// answers to earlier attempts can arrive while waiting for later ones, each attempt
+// has its own reply, copied to resp.
+func (p *Plugin) callRetrying(ctx context.Context, conn *conn, name string, args interface{}, resp interface{}, opts CallOpts) error {
+	done := make(chan *rpc.Call, opts.Retries+1)
+	method := opts.method(name)
+	timer := p.clock.NewTimer(opts.AttemptTimeout)
+	defer timer.Stop()
+
+	for attempt := 0; ; attempt++ {
+		conn.client.Go(method, args, newReply(resp), done)
+		expired := timer.C()
+		if attempt == opts.Retries {
+			expired = nil
+		}
+		select {
+		case call := <-done:
+			// The plugin may answer as soon as it sees the deadline
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if call.Error == nil {
+				setReply(resp, call.Reply)
+			}
+			return p.callFailed(parseCallError(name, wrapIOError(call.Error)))
+		case <-expired:
+			timer.Reset(opts.AttemptTimeout)
+		case <-ctx.Done():
+			conn.cancel(opts.id)
+			return ctx.Err()
+		}
+	}
+}
+
+// Return a new value of the type pointed to by resp.
+func newReply(resp interface{}) interface{} {
+	if resp == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(resp).Elem()).Interface()
+}
+
+// Copy to resp the reply returned by newReply.
+func setReply(resp, reply interface{}) {
+	if resp != nil {
+		reflect.ValueOf(resp).Elem().Set(reflect.ValueOf(reply).Elem())
+	}
+}
+
+// Request run once for all its attempts.
+type onceRequest struct {
+	id     CallID
+	method string
+	// Retry answered with the result of the first attempt
+	replay bool
+}
+
+// Result of a call run once for all its attempts.
+type onceResult struct {
+	done    chan struct{}
+	reply   interface{}
+	err     string
+	expires time.Time
+}
+
+// Call of a client, see AddClient.  Hosts number their calls independently, so the
+// same ID can come from different clients.
+type clientCall struct {
+	client string
+	id     CallID
+}
+
+// Calls run once, by client and ID.
+var onceCalls = struct {
+	mux   sync.Mutex
+	calls map[clientCall]*onceResult
+}{calls: make(map[clientCall]*onceResult)}
+
+// Record the request being read as an attempt of a call run once.  Attempts after the
+// first are served by Replay.
+func (c *serverCodec) deduplicate(r *rpc.Request) {
+	id := clientCall{client: c.client, id: c.params.id}
+	now := defaultServer.clock.Now()
+
+	onceCalls.mux.Lock()
+	for cid, res := range onceCalls.calls {
+		if !res.expires.IsZero() && now.After(res.expires) {
+			delete(onceCalls.calls, cid)
+		}
+	}
+	_, replay := onceCalls.calls[id]
+	if !replay {
+		onceCalls.calls[id] = &onceResult{done: make(chan struct{})}
+	}
+	onceCalls.mux.Unlock()
+
+	c.pendingMux.Lock()
+	if c.onces == nil {
+		c.onces = make(map[uint64]onceRequest)
+	}
+	c.onces[r.Seq] = onceRequest{id: id.id, method: r.ServiceMethod, replay: replay}
+	c.pendingMux.Unlock()
+
+	if replay {
+		r.ServiceMethod = internalObject + ".Replay"
+		c.replay = true
+	}
+}
+
+// Discard the arguments of a retry and pass its ID to Replay instead.
+func (c *serverCodec) readReplay(body interface{}) error {
+	c.replay = false
+	if err := c.decodeMessage(nil); err != nil {
+		return err
+	}
+	if id, ok := body.(*uint64); ok {
+		*id = uint64(c.params.id)
+	}
+	return nil
+}
+
+// Record the result of the first attempt of a call run once, or return it for a retry.
+func (c *serverCodec) completeOnce(r *rpc.Response, body interface{}) interface{} {
+	c.pendingMux.Lock()
+	req, ok := c.onces[r.Seq]
+	delete(c.onces, r.Seq)
+	c.pendingMux.Unlock()
+	if !ok {
+		return body
+	}
+
+	if req.replay {
+		r.ServiceMethod = req.method
+		if reply, ok := body.(*interface{}); ok && r.Error == "" {
+			return *reply
+		}
+		return body
+	}
+
+	onceCalls.mux.Lock()
+	defer onceCalls.mux.Unlock()
+	if res := onceCalls.calls[clientCall{client: c.client, id: req.id}]; res != nil {
+		res.reply = body
+		res.err = r.Error
+		res.expires = defaultServer.clock.Now().Add(onceResultTTL)
+		close(res.done)
+	}
+	return body
+}
+
+// Internal RPC call answering a retry with the result of the first attempt. Do not call manually.
+func (s *PingoRpc) Replay(id uint64, reply *interface{}) error {
+	onceCalls.mux.Lock()
+	res := onceCalls.calls[clientCall{client: s.client, id: CallID(id)}]
+	onceCalls.mux.Unlock()
+	if res == nil {
+		return errors.New("Result of call expired before its retry")
+	}
+	<-res.done
+	if res.err != "" {
+		return errors.New(res.err)
+	}
+	*reply = res.reply
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestOnceCallsPerClient(t *testing.T) {
+	const id = CallID(42)
+	defer func() {
+		onceCalls.mux.Lock()
+		delete(onceCalls.calls, clientCall{client: "a", id: id})
+		delete(onceCalls.calls, clientCall{client: "b", id: id})
+		onceCalls.mux.Unlock()
+	}()
+
+	read := func(client, method string, seq uint64) *serverCodec {
+		c := &serverCodec{client: client, params: methodParams{once: true, id: id}}
+		c.deduplicate(&rpc.Request{ServiceMethod: method, Seq: seq})
+		return c
+	}
+	if c := read("a", "Plugin.First", 1); c.replay {
+		t.Fatal("first call of a replayed")
+	}
+	if c := read("b", "Plugin.Second", 1); c.replay {
+		t.Fatal("call of b with the same ID as a replayed")
+	}
+	if c := read("a", "Plugin.First", 2); !c.replay {
+		t.Fatal("retry of a not replayed")
+	}
+}
+
+func TestOnceResultExpires(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	defaultServer.clock = clock
+	defer func() { defaultServer.clock = realClock{} }()
+
+	const id = CallID(43)
+	key := clientCall{client: "a", id: id}
+	defer func() {
+		onceCalls.mux.Lock()
+		delete(onceCalls.calls, key)
+		onceCalls.mux.Unlock()
+	}()
+
+	run := func(seq uint64) *serverCodec {
+		c := &serverCodec{client: "a", params: methodParams{once: true, id: id}}
+		c.deduplicate(&rpc.Request{ServiceMethod: "Plugin.Method", Seq: seq})
+		if !c.replay {
+			c.completeOnce(&rpc.Response{Seq: seq}, nil)
+		}
+		return c
+	}
+	run(1)
+	clock.Advance(onceResultTTL - time.Second)
+	if c := run(2); !c.replay {
+		t.Fatal("retry within the TTL not replayed")
+	}
+	clock.Advance(2 * time.Second)
+	if c := run(3); c.replay {
+		t.Fatal("call replayed after the result expired")
+	}
+}
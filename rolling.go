@@ -0,0 +1,161 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Interval between checks of a restarted plugin
+const restartPollInterval = 100 * time.Millisecond
+
+// RestartOpts configures a rolling restart of the plugins of a Manager.
+type RestartOpts struct {
+	// New returns the plugin replacing old, the plugin added as name, configured but
+	// not started.  It can use a different configuration or executable.  Required.
+	New func(name string, old *Plugin) *Plugin
+	// Plugins to restart, in order; all plugins in the order they were added if empty
+	Names []string
+	// Number of plugins restarted at the same time, one if zero
+	Batch int
+	// Maximum time for a new plugin to become healthy, only limited by the context
+	// if zero
+	HealthTimeout time.Duration
+	// Maximum time to wait for calls running on an old plugin to complete before
+	// stopping it, not waiting if zero
+	DrainTimeout time.Duration
+}
+
+// RollingRestart replaces the plugins of the manager with new ones created by opts.New,
+// in batches of opts.Batch plugins.  Each new plugin is started while the old one still
+// serves calls, and replaces it in the manager once its health checks pass, see
+// Plugin.Health.  The old plugin is then stopped, once the calls running on it complete
+// or DrainTimeout expires.
+//
+// If a new plugin does not become healthy, it is stopped and the old one is kept; the
+// rest of the batch completes, and RollingRestart returns the errors without restarting
+// the following batches.  The same happens for all remaining plugins when ctx is done.
+func (m *Manager) RollingRestart(ctx context.Context, opts RestartOpts) error {
+	if opts.New == nil {
+		panic("RollingRestart requires RestartOpts.New")
+	}
+	names := opts.Names
+	if len(names) == 0 {
+		names = m.Names()
+	}
+	batch := opts.Batch
+	if batch <= 0 {
+		batch = 1
+	}
+
+	for len(names) > 0 {
+		n := min(batch, len(names))
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i, name := range names[:n] {
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				errs[i] = m.restart(ctx, name, &opts)
+			}(i, name)
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+		names = names[n:]
+	}
+	return nil
+}
+
+// Replace the plugin added as name with a new one, once it is healthy.
+func (m *Manager) restart(ctx context.Context, name string, opts *RestartOpts) error {
+	old := m.Plugin(name)
+	if old == nil {
+		// Removed in the meantime
+		return nil
+	}
+	p := opts.New(name, old)
+
+	m.mux.Lock()
+	m.prepare(name, p)
+	m.mux.Unlock()
+
+	p.Start()
+	if err := waitHealthy(ctx, p, opts.HealthTimeout); err != nil {
+		p.Stop()
+		return errors.New("Plugin " + name + " not restarted: " + err.Error())
+	}
+
+	m.mux.Lock()
+	replaced := m.plugins[name] == old
+	if replaced {
+		m.plugins[name] = p
+	}
+	m.mux.Unlock()
+	if !replaced {
+		// Removed or replaced in the meantime
+		m.ignoreEvents(p)
+		p.Stop()
+		return nil
+	}
+	m.ignoreEvents(old)
+	p.emit(Event{Type: EventRestarted, Replaced: old})
+
+	old.drain(ctx, opts.DrainTimeout)
+	old.Stop()
+	return nil
+}
+
+// Wait until the health checks of p pass.
+func waitHealthy(ctx context.Context, p *Plugin, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	for {
+		h := &Health{}
+		err := p.callContext(ctx, internalObject+".Health", 0, h)
+		if err == nil {
+			if h.Healthy() {
+				return nil
+			}
+			err = errors.New("Health checks failed")
+		}
+		if p.State() == StateFailed {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(restartPollInterval):
+		}
+	}
+}
+
+// Wait until no calls are running on the plugin, for at most timeout.
+func (p *Plugin) drain(ctx context.Context, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		c := p.readyConn.Load()
+		if c == nil || c.dc.calls() == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import "strings"
+
+// Route makes calls to alias go to target instead, so that the host can keep using the
+// same names when a version of the plugin renames its objects or methods.  Alias and
+// target are either both methods, like "Index.Rebuild", or both objects, like "Index":
+// the methods of an object are routed to the methods with the same name of the target
+// object.  A route for a method takes precedence over a route for its object, and
+// targets can be routed further.
+//
+// Statistics, events, errors and method timeouts refer to calls by the method they are
+// routed to.
+//
+// Panics if called after Start, if only one of alias and target is a method, or if the
+// route makes a cycle.
+func (p *Plugin) Route(alias, target string) {
+	if p.running {
+		panic("Cannot call Route after Start")
+	}
+	if strings.Contains(alias, ".") != strings.Contains(target, ".") {
+		panic("Cannot route " + alias + " to " + target + ": both must be methods or objects")
+	}
+	if p.routes == nil {
+		p.routes = make(map[string]string)
+	}
+	p.routes[alias] = target
+	if name := p.route(alias); p.route(name) != name {
+		panic("Cannot route " + alias + " to " + target + ": route makes a cycle")
+	}
+}
+
+// Return the method that calls to name go to.
+func (p *Plugin) route(name string) string {
+	// Each route is followed once at most
+	for i := 0; i <= len(p.routes); i++ {
+		if target, ok := p.routes[name]; ok {
+			name = target
+			continue
+		}
+		obj, method, ok := strings.Cut(name, ".")
+		if !ok {
+			break
+		}
+		target, ok := p.routes[obj]
+		if !ok {
+			break
+		}
+		name = target + "." + method
+	}
+	return name
+}
@@ -0,0 +1,26 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import "os/exec"
+
+// Sandbox restricts what a plugin process is allowed to do.  Implementations
+// adjust the command of the plugin before it is started, for example to run
+// it through a platform launcher.
+type Sandbox interface {
+	// Wrap is called with the command of the plugin before it is started.
+	// Returning an error fails the start of the plugin.
+	Wrap(cmd *exec.Cmd) error
+}
+
+// SetSandbox runs the plugin process inside s.
+//
+// Panics if called after Start.
+func (p *Plugin) SetSandbox(s Sandbox) {
+	if p.running {
+		panic("Cannot call SetSandbox after Start")
+	}
+	p.sandbox = s
+}
@@ -0,0 +1,54 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"os/exec"
+	"sort"
+)
+
+const sandboxExecPath = "/usr/bin/sandbox-exec"
+
+// SandboxExec is a Sandbox running plugins through sandbox-exec with a
+// Seatbelt profile.
+//
+// The profile must at least allow the plugin to execute, to create its
+// socket (in the directory set with SetSocketDirectory or the temporary
+// directory) and to accept connections on it.
+type SandboxExec struct {
+	// Profile is the text of the profile.  Ignored if ProfilePath is set.
+	Profile string
+	// ProfilePath is the path of a file containing the profile
+	ProfilePath string
+	// Parameters made available to the profile with (param "NAME")
+	Params map[string]string
+}
+
+// Wrap makes cmd run through sandbox-exec.
+func (s *SandboxExec) Wrap(cmd *exec.Cmd) error {
+	args := []string{sandboxExecPath}
+	switch {
+	case s.ProfilePath != "":
+		args = append(args, "-f", s.ProfilePath)
+	case s.Profile != "":
+		args = append(args, "-p", s.Profile)
+	default:
+		return errors.New("No sandbox profile specified")
+	}
+
+	names := make([]string, 0, len(s.Params))
+	for name := range s.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "-D", name+"="+s.Params[name])
+	}
+
+	cmd.Args = append(append(args, cmd.Path), cmd.Args[1:]...)
+	cmd.Path = sandboxExecPath
+	return nil
+}
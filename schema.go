@@ -0,0 +1,245 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/rpc"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The plugin reports a fingerprint of the schemas of the arguments and reply of each
+// method when the host connects.  The host compares them to the types used in each
+// call.  Schemas describe types as gob sees them: fields are matched by name and
+// pointers are ignored, but names of types and packages do not matter.
+
+// SchemaPolicy tells how a plugin handles calls whose arguments or reply have a
+// different schema than the ones of the method in the plugin.
+type SchemaPolicy int
+
+const (
+	// Schemas are not checked
+	SchemaOff SchemaPolicy = iota
+	// Mismatches are reported once per method to the ErrorHandler of the plugin,
+	// calls are performed anyway
+	SchemaWarn
+	// Calls with mismatching schemas fail with a SchemaError without being sent
+	SchemaStrict
+)
+
+// Fingerprint of types that can hold anything, never mismatching.
+const anySchema = "*"
+
+// SchemaError is returned by calls whose arguments or reply do not have the same
+// schema as the method of the plugin, for example when the plugin has been built
+// with an older version of the types shared with the host.
+type SchemaError struct {
+	Method string
+	// The arguments differ
+	Arguments bool
+	// The reply differs
+	Reply bool
+}
+
+func (e *SchemaError) Error() string {
+	var what string
+	switch {
+	case e.Arguments && e.Reply:
+		what = "arguments and reply"
+	case e.Arguments:
+		what = "arguments"
+	default:
+		what = "reply"
+	}
+	return "Schema of the " + what + " of " + e.Method + " differs from the plugin"
+}
+
+// SetSchemaPolicy makes the plugin check that the arguments and reply of each call have
+// the same schema as the method called in the plugin.  See SchemaPolicy.
+//
+// Checks require the plugin to be built with a version of this package reporting its
+// schemas; with older plugins, the start fails under SchemaStrict and a warning is
+// reported under SchemaWarn.
+//
+// Panics if called after Start.
+func (p *Plugin) SetSchemaPolicy(policy SchemaPolicy) {
+	if p.running {
+		panic("Cannot call SetSchemaPolicy after Start")
+	}
+	p.schemaPol = policy
+}
+
+// Check the schemas of a call to method against the ones reported by the plugin.
+func (p *Plugin) checkSchema(method string, args interface{}, resp interface{}) error {
+	if p.schemaPol == SchemaOff || p.schemas == nil {
+		return nil
+	}
+	remote, ok := p.schemas[method]
+	if !ok {
+		return nil
+	}
+	remoteArgs, remoteReply, _ := strings.Cut(remote, " ")
+	err := &SchemaError{
+		Method:    method,
+		Arguments: !sameSchema(remoteArgs, fingerprint(reflect.TypeOf(args))),
+		Reply:     !sameSchema(remoteReply, fingerprint(reflect.TypeOf(resp))),
+	}
+	if !err.Arguments && !err.Reply {
+		return nil
+	}
+	if p.schemaPol == SchemaStrict {
+		return err
+	}
+	if _, warned := p.schemaWarn.LoadOrStore(method, true); !warned {
+		p.errorHandler().Print(err)
+	}
+	return nil
+}
+
+func sameSchema(a, b string) bool {
+	return a == b || a == anySchema || b == anySchema
+}
+
+// Ask the schemas of the methods of the plugin via the internal RPC.  Like for
+// requestObjects, the answer is passed to gotSchemas by the control loop.
+func (c *ctrl) requestSchemas() {
+	c.handshake(internalObject+".Schemas", &map[string]string{})
+}
+
+// Record the schemas of the methods of the plugin reported by call.
+func (c *ctrl) gotSchemas(call *rpc.Call) bool {
+	err := call.Error
+	if err == nil {
+		c.p.schemas = *call.Reply.(*map[string]string)
+		return true
+	}
+	err = errors.New("Plugin did not report the schemas of its methods: " + err.Error())
+	if c.p.schemaPol == SchemaStrict {
+		c.fatal(err)
+		return false
+	}
+	c.p.errorHandler().Print(err)
+	return true
+}
+
+// Internal RPC call to list the schemas of the exported methods. Do not call manually.
+func (s *PingoRpc) Schemas(unused int, schemas *map[string]string) error {
+	*schemas = defaultServer.schemas
+	return nil
+}
+
+// Record the schemas of the methods of an object called name.
+func (r *rpcServer) registerSchemas(name string, t reflect.Type) {
+	if r.schemas == nil {
+		r.schemas = make(map[string]string)
+	}
+	for _, method := range rpcMethods(t) {
+		m, _ := t.MethodByName(method)
+		r.schemas[name+"."+method] = fingerprint(m.Type.In(1)) + " " + fingerprint(m.Type.In(2))
+	}
+}
+
+// Fingerprints of schemas, by type.
+var fingerprints sync.Map
+
+// Return the fingerprint of the schema of t, or anySchema if values of any type
+// can be decoded into t.
+func fingerprint(t reflect.Type) string {
+	if t == nil {
+		return anySchema
+	}
+	if fp, ok := fingerprints.Load(t); ok {
+		return fp.(string)
+	}
+	fp := anySchema
+	if t := derefType(t); t.Kind() != reflect.Interface {
+		var b strings.Builder
+		writeSchema(&b, t, make(map[reflect.Type]int))
+		sum := sha256.Sum256([]byte(b.String()))
+		fp = hex.EncodeToString(sum[:8])
+	}
+	fingerprints.Store(t, fp)
+	return fp
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// Write a description of t as encoded by gob.  Structs already being described are
+// referred to by their depth in seen.
+func writeSchema(b *strings.Builder, t reflect.Type, seen map[reflect.Type]int) {
+	t = derefType(t)
+	if implementsGobEncoding(t) {
+		b.WriteString("encoded(" + t.String() + ")")
+		return
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		b.WriteString("bool")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b.WriteString("int")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		b.WriteString("uint")
+	case reflect.Float32, reflect.Float64:
+		b.WriteString("float")
+	case reflect.Complex64, reflect.Complex128:
+		b.WriteString("complex")
+	case reflect.String:
+		b.WriteString("string")
+	case reflect.Interface:
+		b.WriteString("interface")
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			b.WriteString("bytes")
+			return
+		}
+		b.WriteString("[]")
+		writeSchema(b, t.Elem(), seen)
+	case reflect.Array:
+		b.WriteString("[" + strconv.Itoa(t.Len()) + "]")
+		writeSchema(b, t.Elem(), seen)
+	case reflect.Map:
+		b.WriteString("map[")
+		writeSchema(b, t.Key(), seen)
+		b.WriteString("]")
+		writeSchema(b, t.Elem(), seen)
+	case reflect.Struct:
+		if depth, ok := seen[t]; ok {
+			b.WriteString("^" + strconv.Itoa(depth))
+			return
+		}
+		seen[t] = len(seen)
+		defer delete(seen, t)
+
+		fields := make([]reflect.StructField, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.IsExported() && f.Type.Kind() != reflect.Chan && f.Type.Kind() != reflect.Func {
+				fields = append(fields, f)
+			}
+		}
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+
+		b.WriteString("{")
+		for _, f := range fields {
+			b.WriteString(f.Name + ":")
+			writeSchema(b, f.Type, seen)
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+	default:
+		b.WriteString(t.Kind().String())
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+)
+
+const selfCheckKey = "selfcheck"
+
+// SelfChecker is implemented by exported objects that can verify they are able to serve
+// calls, for example that their configuration is valid.  It is called by RunSelfCheck.
+type SelfChecker interface {
+	SelfCheck() error
+}
+
+// SelfCheckResult reports the self-check of an object exported by a plugin.
+type SelfCheckResult struct {
+	Object string
+	// Methods that can be called, sorted by name
+	Methods []string
+	// Why the check failed, empty if it passed
+	Error string `json:",omitempty"`
+}
+
+// RunSelfCheck checks the objects registered by the plugin, prints a report and exits.
+// Each object must export methods whose arguments and replies can be sent, and answer
+// a call made through a loopback connection, that runs its SelfCheck method if it
+// implements SelfChecker.
+//
+// A line is printed for each object, made of the prefix of the plugin ("pingo" by
+// default), ": selfcheck: " and a SelfCheckResult in JSON.  The plugin exits with
+// status 1 if any check failed.
+//
+// Run calls RunSelfCheck when the plugin is started with -pingo:selfcheck, as done by
+// the SelfCheck method of the host.  Plugin authors can run the same in their builds.
+func RunSelfCheck() {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	os.Exit(defaultServer.selfCheck())
+}
+
+// InSelfCheck reports whether the plugin has been started to run its self-check.  Run
+// does not serve the host then; plugins can skip their setup not needed by SelfCheck.
+func InSelfCheck() bool {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	return defaultServer.conf.selfcheck
+}
+
+// Check the registered objects and print the results.  Return the exit status.
+func (r *rpcServer) selfCheck() int {
+	r.running = true
+	h := meta(r.conf.prefix)
+
+	status := 0
+	report := func(res SelfCheckResult) {
+		data, _ := json.Marshal(res)
+		h.output(selfCheckKey, string(data))
+		if res.Error != "" {
+			status = 1
+		}
+	}
+
+	if len(r.info) == 0 {
+		report(SelfCheckResult{Error: "No objects registered"})
+		return status
+	}
+	client, err := r.loopback()
+	if err != nil {
+		report(SelfCheckResult{Error: "Cannot connect through loopback: " + err.Error()})
+		return status
+	}
+	defer client.Close()
+
+	for _, info := range r.info {
+		res := SelfCheckResult{Object: info.Name, Methods: info.Methods}
+		if err := r.checkObject(client, info); err != nil {
+			res.Error = err.Error()
+		}
+		report(res)
+	}
+	return status
+}
+
+// Connect to the server through an in-memory connection.
+func (r *rpcServer) loopback() (*rpc.Client, error) {
+	if r.secret == "" {
+		r.secret = randstr(64)
+	}
+	sconn, cconn := net.Pipe()
+	go r.serveConn(sconn, r.secret)
+	if err := (&client{secret: r.secret}).authenticate(cconn); err != nil {
+		cconn.Close()
+		return nil, err
+	}
+	return rpc.NewClientWithCodec(newClientCodec(cconn, 0, &callStats{})), nil
+}
+
+func (r *rpcServer) checkObject(client *rpc.Client, info ObjectInfo) error {
+	if len(info.Methods) == 0 {
+		return errors.New("No methods can be called")
+	}
+	t := reflect.TypeOf(r.impls[info.Name])
+	for _, name := range info.Methods {
+		m, _ := t.MethodByName(name)
+		if why := unencodable(m.Type.In(1)); why != "" {
+			return errors.New(name + ": arguments cannot be sent: " + why)
+		}
+		if why := unencodable(m.Type.In(2)); why != "" {
+			return errors.New(name + ": reply cannot be sent: " + why)
+		}
+	}
+	return client.Call(internalObject+".SelfCheck", info.Name, new(int))
+}
+
+// Internal RPC call to run the self-check of an object. Do not call manually.
+func (s *PingoRpc) SelfCheck(name string, unused *int) error {
+	obj, ok := defaultServer.impls[name]
+	if !ok {
+		return errors.New("Unknown object " + name)
+	}
+	if c, ok := obj.(SelfChecker); ok {
+		return c.SelfCheck()
+	}
+	return nil
+}
+
+// SelfCheck runs Preflight, then runs the plugin with -pingo:selfcheck to check the
+// objects it exports, see RunSelfCheck.  The plugin itself is not started.  The results
+// are returned for each object, with an error if any check failed.  The self-check must
+// complete within the startup timeout of the plugin.
+//
+// Requires the plugin to be built with a version of this package supporting self-checks.
+func (p *Plugin) SelfCheck() ([]SelfCheckResult, error) {
+	if err := p.Preflight(); err != nil {
+		return nil, err
+	}
+	if p.attachTo != "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.initTimeout)
+	defer cancel()
+
+	params := []string{"-pingo:prefix=" + string(p.meta), "-pingo:selfcheck"}
+	if hostInfo := p.startInfo(); hostInfo != nil {
+		info, err := hostInfo.encode()
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, "-pingo:host="+info)
+	}
+	params = p.withParams(params)
+	argv := p.commandLine(p.exe, params)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	if p.sandbox != nil {
+		if err := p.sandbox.Wrap(cmd); err != nil {
+			return nil, err
+		}
+	}
+	if p.cmdMod != nil {
+		p.cmdMod(cmd)
+	}
+	out, err := cmd.Output()
+
+	var results []SelfCheckResult
+	var failed []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		field, ok := strings.CutPrefix(scanner.Text(), string(p.meta)+": ")
+		if !ok {
+			continue
+		}
+		key, val := parseControl(field)
+		if key != selfCheckKey {
+			continue
+		}
+		var res SelfCheckResult
+		if err := json.Unmarshal([]byte(val), &res); err != nil {
+			return results, ErrInvalidMessage(errors.New("Invalid self-check report: " + err.Error()))
+		}
+		results = append(results, res)
+		if msg := res.Error; msg != "" {
+			if res.Object != "" {
+				msg = res.Object + ": " + msg
+			}
+			failed = append(failed, msg)
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		return results, errors.New("Self-check failed: " + strings.Join(failed, "; "))
+	case err != nil:
+		return results, errors.New("Plugin did not complete its self-check: " + err.Error())
+	case len(results) == 0:
+		return nil, errors.New("Plugin did not report a self-check")
+	}
+	return results, nil
+}
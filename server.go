@@ -7,10 +7,11 @@ package pingo
 import (
 	"bufio"
 	"bytes"
+	"crypto/subtle"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"math/rand"
 	"net"
 	"net/rpc"
 	"os"
@@ -18,6 +19,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,11 +28,16 @@ import (
 // an exported symbol and obey all rules an object in the standard
 // "rpc" module has to obey.
 //
-// Register will panic if called after Run.
+// Names starting with "Pingo" are reserved for internal objects.
+//
+// Register will panic if called after Run or if the name of the object is reserved.
 func Register(obj interface{}) {
 	if defaultServer.running {
 		panic("Do not call Register after Run")
 	}
+	if name := reflect.TypeOf(obj).Elem().Name(); isInternalObject(name) {
+		panic("Cannot register object " + name + ": names starting with " + reservedPrefix + " are reserved")
+	}
 	defaultServer.register(obj)
 }
 
@@ -38,11 +46,57 @@ func Run() error {
 	if !flag.Parsed() {
 		flag.Parse()
 	}
+	if defaultServer.conf.selfcheck {
+		RunSelfCheck()
+	}
 	return defaultServer.run()
 }
 
+// Delegate tells the host that this plugin is continued by the process with the given
+// pid, for example after re-executing or daemonizing.  The host will then track and
+// stop the delegate instead of the process it started.
+//
+// The delegate must call Run with the same command line arguments and while it still
+// has access to the standard output inherited from the started process.  It must be
+// the started process or descend from it, and Delegate must be called before the
+// started process exits: the host ignores other processes.
+func Delegate(pid int) {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	meta(defaultServer.conf.prefix).output("delegate", fmt.Sprintf("pid=%d", pid))
+}
+
+// SetIOTimeouts sets deadlines on the connections from the host: idle is the maximum
+// time to wait for the next request, write the maximum time to send a response.
+// Connections exceeding a deadline are closed.  Zero disables a deadline; both are
+// disabled by default.
+//
+// As the host keeps its connection open between calls, only set an idle timeout if
+// the host is known to send requests regularly.
+//
+// SetIOTimeouts will panic if called after Run.
+func SetIOTimeouts(idle, write time.Duration) {
+	if defaultServer.running {
+		panic("Do not call SetIOTimeouts after Run")
+	}
+	defaultServer.idleTimeout = idle
+	defaultServer.writeTimeout = write
+}
+
+// WaitHostReady blocks until the host has called ReleaseStartup on this plugin.
+// Use it when the plugin needs to connect back to services provided by the host.
+//
+// As Run does not return, WaitHostReady must be called from another goroutine.
+func WaitHostReady() {
+	<-defaultServer.hostReady
+}
+
 // Internal object for plugin control
-type PingoRpc struct{}
+type PingoRpc struct {
+	// Name of the client served, see AddClient
+	client string
+}
 
 // Default constructor for interal object. Do not call manually.
 func NewPingoRpc() *PingoRpc {
@@ -51,22 +105,44 @@ func NewPingoRpc() *PingoRpc {
 
 // Internal RPC call to shut down a plugin. Do not call manually.
 func (s *PingoRpc) Exit(status int, unused *int) error {
+	// Other hosts are still served
+	if s.client != "" {
+		return nil
+	}
 	os.Exit(status)
 	return nil
 }
 
+// Internal RPC call to release a plugin waiting in WaitHostReady. Do not call manually.
+func (s *PingoRpc) ReleaseStartup(unused int, unused2 *int) error {
+	defaultServer.releaseOnce.Do(func() {
+		close(defaultServer.hostReady)
+	})
+	return nil
+}
+
 type config struct {
-	proto   string
-	addr    string
-	prefix  string
-	unixdir string
+	proto     string
+	addr      string
+	prefix    string
+	unixdir   string
+	keepalive time.Duration
+	host      string
+	listen    string
+	tokenfile string
+	selfcheck bool
 }
 
 func makeConfig() *config {
 	c := &config{}
-	flag.StringVar(&c.proto, "pingo:proto", "unix", "Protocol to use: unix or tcp")
+	flag.StringVar(&c.proto, "pingo:proto", "unix", "Protocol to use: unix, tcp or fifo")
 	flag.StringVar(&c.unixdir, "pingo:unixdir", "", "Alternative directory for unix socket")
 	flag.StringVar(&c.prefix, "pingo:prefix", "pingo", "Prefix to output lines")
+	flag.DurationVar(&c.keepalive, "pingo:keepalive", 0, "TCP keepalive interval")
+	flag.StringVar(&c.host, "pingo:host", "", "Encoded information about the host")
+	flag.StringVar(&c.listen, "pingo:listen", "", "Fixed address to listen on")
+	flag.StringVar(&c.tokenfile, "pingo:tokenfile", "", "File containing the authentication token")
+	flag.BoolVar(&c.selfcheck, "pingo:selfcheck", false, "Check the exported objects and exit")
 	return c
 }
 
@@ -74,19 +150,42 @@ type rpcServer struct {
 	*rpc.Server
 	secret  string
 	objs    []string
+	info    []ObjectInfo
+	schemas map[string]string
+	impls   map[string]interface{}
 	conf    *config
 	running bool
+	// Number of open connections
+	conns int64
+	// I/O deadlines on connections
+	idleTimeout  time.Duration
+	writeTimeout time.Duration
+	// Size limits of messages
+	maxRequest  int
+	maxResponse int
+	// Closed when the host releases the startup
+	hostReady   chan struct{}
+	releaseOnce sync.Once
+	// Exit when the lease of the host expires
+	leaseGrace time.Duration
+	leaseTimer *time.Timer
+	leaseMux   sync.Mutex
+	// Limits the number of running calls if set
+	calls *callQueue
+	// Hosts connecting with their own token, by name
+	clients map[string]*hostClient
+	// Tells the time for the expiration of results of calls run once
+	clock Clock
 }
 
 func newRpcServer() *rpcServer {
-	rand.Seed(time.Now().UTC().UnixNano())
 	r := &rpcServer{
-		Server: rpc.NewServer(),
-		secret: randstr(64),
-		objs:   make([]string, 0),
-		conf:   makeConfig(), // conf remains fixed after this point
+		Server:    newInternalServer(""),
+		objs:      make([]string, 0),
+		conf:      makeConfig(), // conf remains fixed after this point
+		hostReady: make(chan struct{}),
+		clock:     realClock{},
 	}
-	r.register(&PingoRpc{})
 	return r
 }
 
@@ -109,14 +208,26 @@ func (b *bufReadWriteCloser) Close() error {
 	return b.r.Close()
 }
 
-func readHeaders(brwc *bufReadWriteCloser) ([]byte, error) {
-	var buf bytes.Buffer
+const (
+	// Maximum size of the headers sent by a connecting client
+	maxHeaderSize = 4096
+	// Time a connecting client has to send its headers
+	headerTimeout = 10 * time.Second
+)
+
+var errHeaderTooLarge = errors.New("Connection headers too large")
+
+func readHeaders(brwc *bufReadWriteCloser, buf *bytes.Buffer) error {
 	var headerEnd bool
 
 	for {
+		if buf.Len() >= maxHeaderSize {
+			return errHeaderTooLarge
+		}
+
 		b, err := brwc.ReadByte()
 		if err != nil {
-			return []byte(""), err
+			return err
 		}
 
 		buf.WriteByte(b)
@@ -131,56 +242,118 @@ func readHeaders(brwc *bufReadWriteCloser) ([]byte, error) {
 		}
 	}
 
-	return buf.Bytes(), nil
+	return nil
 }
 
 func parseHeaders(brwc *bufReadWriteCloser, m map[string]string) error {
-	headers, err := readHeaders(brwc)
-	if err != nil {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := readHeaders(brwc, buf); err != nil {
 		return err
 	}
 
-	r := bytes.NewReader(headers)
-	scanner := bufio.NewScanner(r)
+	scanner := bufio.NewScanner(buf)
 
 	for scanner.Scan() {
-		parts := strings.SplitN(scanner.Text(), ": ", 2)
-		if parts[0] == "" {
+		if scanner.Text() == "" {
 			continue
 		}
-		m[parts[0]] = parts[1]
+		name, val, ok := parseField(scanner.Text())
+		if !ok {
+			return errInvalidHeader
+		}
+		m[name] = val
 	}
 
 	return nil
 }
 
-func (r *rpcServer) authConn(token string) bool {
-	if token != "" && token == r.secret {
-		return true
+// Returns the client authenticated by token, nil for the host using secret.
+func (r *rpcServer) authConn(token, secret string) (*hostClient, bool) {
+	if token == "" {
+		return nil, false
 	}
-	return false
+	// Compare with all tokens in constant time, not to reveal how much of one matches
+	var found *hostClient
+	ok := subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	for _, cl := range r.clients {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cl.token)) == 1 {
+			found, ok = cl, true
+		}
+	}
+	return found, ok
 }
 
-func (r *rpcServer) serveConn(conn io.ReadWriteCloser, h meta) {
+func (r *rpcServer) serveConn(conn io.ReadWriteCloser, secret string) {
 	bconn := newBufReadWriteCloser(conn)
 	defer bconn.Close()
 
+	atomic.AddInt64(&r.conns, 1)
+	defer atomic.AddInt64(&r.conns, -1)
+
+	// Do not let clients hold the connection without authenticating
+	deadliner, hasDeadline := conn.(interface {
+		SetReadDeadline(time.Time) error
+	})
+	if hasDeadline {
+		deadliner.SetReadDeadline(time.Now().Add(headerTimeout))
+	}
+
 	headers := make(map[string]string)
+	// Unauthenticated clients cannot make the plugin write to the host
 	if err := parseHeaders(bconn, headers); err != nil {
-		h.output("error", err.Error())
 		return
 	}
 
-	if r.authConn(headers["Auth-Token"]) {
-		r.Server.ServeConn(bconn)
+	cl, ok := r.authConn(headers["Auth-Token"], secret)
+	if !ok {
+		return
 	}
 
-	return
+	if hasDeadline {
+		deadliner.SetReadDeadline(time.Time{})
+	}
+	if dc, ok := conn.(*deadlineConn); ok {
+		dc.activate()
+	}
+	codec := newServerCodec(bconn, r.maxRequest, r.maxResponse)
+	if val, ok := headers[compressHeader]; ok {
+		codec.compress = parseCompression(val)
+	}
+	server := r.Server
+	if cl != nil {
+		server = r.clientServer(cl)
+		codec.client = cl.name
+	}
+	if r.calls != nil {
+		r.dispatch(server, codec)
+		return
+	}
+	server.ServeCodec(codec)
+}
+
+// Listen on the first address of conn that can be used.
+func (r *rpcServer) listen(conn connection) (listener net.Listener, err error) {
+	for i := 0; i < conn.retries(); i++ {
+		r.conf.addr = conn.addr()
+		listener, err = net.Listen(r.conf.proto, r.conf.addr)
+		if err == nil {
+			break
+		}
+	}
+	return listener, err
 }
 
 func (r *rpcServer) register(obj interface{}) {
-	element := reflect.TypeOf(obj).Elem()
-	r.objs = append(r.objs, element.Name())
+	t := reflect.TypeOf(obj)
+	r.objs = append(r.objs, t.Elem().Name())
+	r.info = append(r.info, ObjectInfo{Name: t.Elem().Name(), Methods: rpcMethods(t)})
+	r.registerSchemas(t.Elem().Name(), t)
+	if r.impls == nil {
+		r.impls = make(map[string]interface{})
+	}
+	r.impls[t.Elem().Name()] = obj
 	r.Server.Register(obj)
 }
 
@@ -207,8 +380,10 @@ func (t *tcp) retries() int {
 
 type unix string
 
+// Socket names contain the pid and 132 bits of entropy, so they
+// cannot be guessed and squatted in a shared directory.
 func (u *unix) addr() string {
-	name := randstr(8)
+	name := fmt.Sprintf("pingo-%d-%s", os.Getpid(), randstr(22))
 	if *u != "" {
 		name = filepath.FromSlash(path.Join(string(*u), name))
 	}
@@ -227,38 +402,90 @@ func (r *rpcServer) run() error {
 	r.running = true
 
 	h := meta(r.conf.prefix)
-	h.output("objects", strings.Join(r.objs, ", "))
 
-	switch r.conf.proto {
-	case "tcp":
-		conn = new(tcp)
-	default:
-		r.conf.proto = "unix"
-		conn = new(unix)
-	}
-
-	for i := 0; i < conn.retries(); i++ {
-		r.conf.addr = conn.addr()
-		listener, err = net.Listen(r.conf.proto, r.conf.addr)
-		if err == nil {
-			break
+	if r.conf.tokenfile != "" {
+		if r.secret, err = readTokenFile(r.conf.tokenfile); err != nil {
+			h.output("fatal", fmt.Sprintf("%s: %s", errorCodeConnFailed, err.Error()))
+			return err
 		}
+	} else {
+		// Generated here, so that a source set with SetRandSource is used
+		r.secret = randstr(64)
 	}
 
+	h.output("objects", strings.Join(r.objs, ", "))
+
+	listener, err = activationListener()
 	if err != nil {
-		h.output("fatal", fmt.Sprintf("%s: Could not connect in %d attemps, using %s protocol", errorCodeConnFailed, conn.retries(), r.conf.proto))
+		h.output("fatal", fmt.Sprintf("%s: %s", errorCodeConnFailed, err.Error()))
 		return err
 	}
 
-	h.output("auth-token", defaultServer.secret)
+	if listener != nil {
+		r.conf.proto = listener.Addr().Network()
+		r.conf.addr = listener.Addr().String()
+	} else if r.conf.proto == "fifo" {
+		u := unix(r.conf.unixdir)
+		r.conf.addr = u.addr()
+		if listener, err = listenFifo(r.conf.addr); err != nil {
+			h.output("fatal", fmt.Sprintf("%s: %s", errorCodeConnFailed, err.Error()))
+			return err
+		}
+	} else {
+		switch r.conf.proto {
+		case "tcp":
+			conn = new(tcp)
+		default:
+			r.conf.proto = "unix"
+			u := unix(r.conf.unixdir)
+			conn = &u
+		}
+		if r.conf.listen != "" {
+			f := fixed(r.conf.listen)
+			conn = &f
+			// Left over by a previous instance
+			if r.conf.proto == "unix" {
+				os.Remove(r.conf.listen)
+			}
+		}
+
+		listener, err = r.listen(conn)
+		if err != nil && r.conf.listen == "" && r.conf.unixdir == abstractDir {
+			// No abstract namespace, fall back to files
+			u := unix(os.TempDir())
+			conn = &u
+			listener, err = r.listen(conn)
+		}
+
+		if err != nil {
+			h.output("fatal", fmt.Sprintf("%s: Could not connect in %d attemps, using %s protocol", errorCodeConnFailed, conn.retries(), r.conf.proto))
+			return err
+		}
+	}
+
+	// Persistent plugins must not log their token
+	if r.conf.tokenfile == "" {
+		h.output("auth-token", r.secret)
+	}
 	h.output("ready", fmt.Sprintf("proto=%s addr=%s", r.conf.proto, r.conf.addr))
+	var backoff fdBackoff
 	for {
 		var conn net.Conn
 		conn, err = listener.Accept()
+		if err != nil && isFDExhausted(err) {
+			// Leave connections waiting until descriptors are released
+			if !backoff.waiting() {
+				h.output("error", fmt.Sprintf("%s: %s", errorCodeFDExhausted, err.Error()))
+			}
+			backoff.wait()
+			continue
+		}
 		if err != nil {
 			h.output("fatal", fmt.Sprintf("err-http-serve: %s", err.Error()))
 			continue
 		}
-		go r.serveConn(conn, h)
+		backoff.reset()
+		setTCPKeepAlive(conn, r.conf.keepalive)
+		go r.serveConn(newDeadlineConn(conn, r.idleTimeout, r.writeTimeout, false), r.secret)
 	}
 }
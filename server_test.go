@@ -0,0 +1,65 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAuthConn(t *testing.T) {
+	r := &rpcServer{clients: map[string]*hostClient{
+		"one": {name: "one", token: "token-one"},
+		"two": {name: "two", token: "token-two"},
+	}}
+	for _, c := range []struct {
+		token  string
+		client string
+		ok     bool
+	}{
+		{"secret", "", true},
+		{"token-one", "one", true},
+		{"token-two", "two", true},
+		{"", "", false},
+		{"secre", "", false},
+		{"secrets", "", false},
+		{"token-three", "", false},
+	} {
+		cl, ok := r.authConn(c.token, "secret")
+		if ok != c.ok {
+			t.Errorf("token %q accepted: %v", c.token, ok)
+		}
+		var name string
+		if cl != nil {
+			name = cl.name
+		}
+		if name != c.client {
+			t.Errorf("token %q authenticated client %q", c.token, name)
+		}
+	}
+}
+
+func TestServeConnMalformedHeaders(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	srv := &rpcServer{Server: newInternalServer("")}
+	srv.serveConn(readOnlyConn{strings.NewReader("no separator\n\n")}, "secret")
+	os.Stdout = stdout
+	w.Close()
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) > 0 {
+		t.Fatalf("malformed headers reported to the host: %q", out)
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const errorCodeSessionClosed = "err-session-closed"
+
+// Parameter carrying the session of a call
+const sessionParam = "session"
+
+// SessionID identifies a session between the host and a plugin.
+type SessionID uint64
+
+// Session groups calls to a plugin that share state kept by the plugin, see
+// Plugin.NewSession.  Session implements Caller.
+type Session struct {
+	p       *Plugin
+	id      SessionID
+	closed  atomic.Bool
+	cleanup runtime.Cleanup
+}
+
+// Sessions opened by the host with a plugin.
+type sessionStats struct {
+	open   atomic.Int64
+	leaked atomic.Int64
+}
+
+// NewSession opens a session with the plugin: the plugin creates the state of the
+// session with the function set with OnSession, and methods called through the session
+// get it, see InSession.  Close the session when done: sessions garbage collected
+// without being closed are closed in the background and counted in Stats, and plugins
+// can close sessions left unused, see SetSessionTTL.
+//
+// Like CallContext, NewSession waits until the plugin has been initialized, or until ctx
+// is done.  Returns the error of the function creating the session, if any.
+//
+// Requires the plugin to be built with a version of this package supporting sessions.
+func (p *Plugin) NewSession(ctx context.Context) (*Session, error) {
+	id := SessionID(randUint64())
+	for id == 0 {
+		id = SessionID(randUint64())
+	}
+	if err := p.callContext(ctx, internalObject+".OpenSession", uint64(id), nil); err != nil {
+		return nil, err
+	}
+	s := &Session{p: p, id: id}
+	p.sessions.open.Add(1)
+	s.cleanup = runtime.AddCleanup(s, p.sessionLeaked, id)
+	return s, nil
+}
+
+// Close a session garbage collected without calling Close.
+func (p *Plugin) sessionLeaked(id SessionID) {
+	p.sessions.open.Add(-1)
+	p.sessions.leaked.Add(1)
+	go p.Call(internalObject+".CloseSession", uint64(id), nil)
+}
+
+// ID returns the identifier of the session, the same as seen by the plugin.
+func (s *Session) ID() SessionID {
+	return s.id
+}
+
+// Call performs a call to the plugin in the session, see Plugin.Call.  Returns
+// ErrSessionClosed if the session has been closed.
+func (s *Session) Call(name string, args interface{}, resp interface{}) error {
+	return s.CallContext(context.Background(), name, args, resp)
+}
+
+// CallContext performs a call to the plugin in the session, see Plugin.CallContext.
+// Returns ErrSessionClosed if the session has been closed.
+func (s *Session) CallContext(ctx context.Context, name string, args interface{}, resp interface{}) error {
+	if s.closed.Load() {
+		return errSessionClosed
+	}
+	return s.p.callWith(ctx, name, args, resp, CallOpts{session: s.id})
+}
+
+// Close ends the session: the plugin destroys its state with the function set with
+// OnSession.  Calling Close more than once has no effect.
+func (s *Session) Close() error {
+	if s.closed.Swap(true) {
+		return nil
+	}
+	s.cleanup.Stop()
+	s.p.sessions.open.Add(-1)
+	return s.p.Call(internalObject+".CloseSession", uint64(s.id), nil)
+}
+
+var errSessionClosed = ErrSessionClosed(errors.New("Session is closed"))
+
+// State of a session open in the plugin.
+type sessionState struct {
+	value interface{}
+	// Client that opened the session, see AddClient
+	client string
+	// Number of calls running in the session
+	calls int
+	// Closes the session once unused for the TTL
+	timer *time.Timer
+}
+
+// Sessions open in the plugin, and the functions creating and destroying their state.
+var sessions = struct {
+	mux     sync.Mutex
+	open    map[SessionID]*sessionState
+	create  func(id SessionID) (interface{}, error)
+	destroy func(id SessionID, state interface{})
+	ttl     time.Duration
+	expired int
+}{open: make(map[SessionID]*sessionState)}
+
+// SetSessionTTL makes the plugin close the sessions in which no call has run for ttl,
+// as if the host closed them.  This frees the state of sessions the host failed to
+// close, for example because it crashed.  Zero, the default, keeps sessions until the
+// host closes them.
+//
+// SetSessionTTL will panic if called after Run.
+func SetSessionTTL(ttl time.Duration) {
+	if defaultServer.running {
+		panic("Do not call SetSessionTTL after Run")
+	}
+	sessions.ttl = ttl
+}
+
+// Close the session with state st, unless calls are running in it.
+func expireSession(id SessionID, st *sessionState) {
+	sessions.mux.Lock()
+	if sessions.open[id] != st || st.calls > 0 {
+		sessions.mux.Unlock()
+		return
+	}
+	delete(sessions.open, id)
+	sessions.expired++
+	sessions.mux.Unlock()
+	if sessions.destroy != nil {
+		sessions.destroy(id, st.value)
+	}
+}
+
+// Number of sessions open in the plugin, and of sessions closed because of their TTL.
+func sessionCounts() (open, expired int) {
+	sessions.mux.Lock()
+	defer sessions.mux.Unlock()
+	return len(sessions.open), sessions.expired
+}
+
+// OnSession sets the functions creating and destroying the state of the sessions opened
+// by the host, see Plugin.NewSession.  The value returned by create is the state of the
+// session, passed to destroy when the host closes the session and available to methods
+// called in the session, see InSession.  An error returned by create is returned to the
+// host and no session is opened.  Either function can be nil.
+//
+// OnSession will panic if called after Run.
+func OnSession(create func(id SessionID) (interface{}, error), destroy func(id SessionID, state interface{})) {
+	if defaultServer.running {
+		panic("Do not call OnSession after Run")
+	}
+	sessions.create = create
+	sessions.destroy = destroy
+}
+
+// Internal RPC call to open a session. Do not call manually.
+func (s *PingoRpc) OpenSession(id uint64, unused *int) error {
+	state := sessionState{client: s.client}
+	if sessions.create != nil {
+		value, err := sessions.create(SessionID(id))
+		if err != nil {
+			return err
+		}
+		state.value = value
+	}
+	sessions.mux.Lock()
+	sessions.open[SessionID(id)] = &state
+	if sessions.ttl > 0 {
+		state.timer = time.AfterFunc(sessions.ttl, func() { expireSession(SessionID(id), &state) })
+	}
+	sessions.mux.Unlock()
+	return nil
+}
+
+// Internal RPC call to close a session. Do not call manually.
+func (s *PingoRpc) CloseSession(id uint64, unused *int) error {
+	sessions.mux.Lock()
+	state, ok := sessions.open[SessionID(id)]
+	// Sessions of other clients are left open
+	ok = ok && state.client == s.client
+	if ok {
+		delete(sessions.open, SessionID(id))
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+	}
+	sessions.mux.Unlock()
+	if ok && sessions.destroy != nil {
+		sessions.destroy(SessionID(id), state.value)
+	}
+	return nil
+}
+
+// Close the sessions opened by the client with name.
+func closeClientSessions(name string) {
+	closed := make(map[SessionID]*sessionState)
+	sessions.mux.Lock()
+	for id, state := range sessions.open {
+		if state.client == name {
+			delete(sessions.open, id)
+			if state.timer != nil {
+				state.timer.Stop()
+			}
+			closed[id] = state
+		}
+	}
+	sessions.mux.Unlock()
+	if sessions.destroy != nil {
+		for id, state := range closed {
+			sessions.destroy(id, state.value)
+		}
+	}
+}
+
+// InSession can be embedded in the arguments of a plugin method, so that the method
+// gets the session it was called in:
+//
+//	type Args struct {
+//		pingo.InSession
+//		Item string
+//	}
+//
+//	func (o *Cart) Add(args Args, resp *int) error {
+//		cart := args.State().(*cart)
+//		...
+//	}
+//
+// InSession is not transmitted, the host may or may not include it in its arguments.
+type InSession func() (SessionID, interface{})
+
+// ID returns the session of the call, zero for calls made outside sessions.
+func (s InSession) ID() SessionID {
+	if s == nil {
+		return 0
+	}
+	id, _ := s()
+	return id
+}
+
+// State returns the state of the session of the call, as created by the function set
+// with OnSession; nil for calls made outside sessions.
+func (s InSession) State() interface{} {
+	if s == nil {
+		return nil
+	}
+	_, state := s()
+	return state
+}
+
+func (s *InSession) setSession(id SessionID, state interface{}) {
+	*s = func() (SessionID, interface{}) { return id, state }
+}
+
+// Give body the session of the request being read.  Fails if the session is not open.
+func (c *serverCodec) bindSession(body interface{}) error {
+	id := c.params.session
+	if id == 0 {
+		return nil
+	}
+	sessions.mux.Lock()
+	state, ok := sessions.open[id]
+	// Sessions of other clients are not visible
+	ok = ok && state.client == c.client
+	if ok {
+		state.calls++
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+	}
+	sessions.mux.Unlock()
+	if !ok {
+		return rpc.ServerError(errorCodeSessionClosed + ": Session " + strconv.FormatUint(uint64(id), 10) + " is not open")
+	}
+
+	c.pendingMux.Lock()
+	if c.sessions == nil {
+		c.sessions = make(map[uint64]SessionID)
+	}
+	c.sessions[c.seq] = id
+	c.pendingMux.Unlock()
+
+	if b, ok := body.(interface {
+		setSession(SessionID, interface{})
+	}); ok {
+		b.setSession(id, state.value)
+	}
+	return nil
+}
+
+// Count the request with seq as done in its session, once answered.
+func (c *serverCodec) releaseSession(seq uint64) {
+	c.pendingMux.Lock()
+	id, ok := c.sessions[seq]
+	delete(c.sessions, seq)
+	c.pendingMux.Unlock()
+	if !ok {
+		return
+	}
+
+	sessions.mux.Lock()
+	defer sessions.mux.Unlock()
+	if state := sessions.open[id]; state != nil {
+		if state.calls--; state.calls == 0 && state.timer != nil {
+			state.timer.Reset(sessions.ttl)
+		}
+	}
+}
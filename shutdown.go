@@ -0,0 +1,140 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import "context"
+
+// DependsOn declares that the plugin called name uses the plugins called deps, so that
+// StopAll stops it before them.  The plugins do not need to be added to the manager yet.
+//
+// Panics if a dependency would make a cycle.
+func (m *Manager) DependsOn(name string, deps ...string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	for _, dep := range deps {
+		if dep == name || m.dependsOn(dep, name) {
+			panic("Dependency cycle between plugins " + name + " and " + dep)
+		}
+		m.deps[name] = append(m.deps[name], dep)
+	}
+}
+
+// Whether plugin a uses plugin b, directly or not.  Must be called with m.mux held.
+func (m *Manager) dependsOn(a, b string) bool {
+	for _, dep := range m.deps[a] {
+		if dep == b || m.dependsOn(dep, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// Result of stopping a plugin of a manager.
+type stopped struct {
+	name string
+	res  StopResult
+	err  error
+	// Never started
+	skipped bool
+}
+
+// StopAll stops all plugins of the manager, typically when the host shuts down.  Each
+// plugin is stopped after the plugins that depend on it, see DependsOn; the others are
+// stopped concurrently.  When ctx is done, the plugins not stopped yet are killed.
+// Plugins that were never started are skipped.
+//
+// Returns how each plugin was stopped, by name.  The error is ctx.Err() if plugins
+// were killed because of ctx, otherwise the first error a plugin failed with, in the
+// order plugins were added.
+func (m *Manager) StopAll(ctx context.Context) (map[string]StopResult, error) {
+	m.mux.Lock()
+	names := make([]string, len(m.names))
+	copy(names, m.names)
+	plugins := make(map[string]*Plugin, len(m.plugins))
+	for name, p := range m.plugins {
+		plugins[name] = p
+	}
+	dependents := make(map[string][]string)
+	for name, deps := range m.deps {
+		if plugins[name] == nil {
+			continue
+		}
+		for _, dep := range deps {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+	m.mux.Unlock()
+
+	done := make(map[string]chan struct{}, len(names))
+	for _, name := range names {
+		done[name] = make(chan struct{})
+	}
+	stoppedCh := make(chan stopped, len(names))
+	for _, name := range names {
+		go func(name string, p *Plugin) {
+			defer close(done[name])
+			for _, dep := range dependents[name] {
+				select {
+				case <-done[dep]:
+				case <-ctx.Done():
+				}
+			}
+			if p.State() == StateNew {
+				stoppedCh <- stopped{name: name, skipped: true}
+				return
+			}
+			res, err := p.Stop()
+			stoppedCh <- stopped{name: name, res: res, err: err}
+		}(name, plugins[name])
+	}
+
+	results := make(map[string]StopResult, len(names))
+	errs := make(map[string]error)
+	finished := make(map[string]bool, len(names))
+	expired := ctx.Done()
+	var killed bool
+	for len(finished) < len(names) {
+		select {
+		case s := <-stoppedCh:
+			finished[s.name] = true
+			if s.skipped {
+				continue
+			}
+			results[s.name] = s.res
+			if s.err != nil {
+				errs[s.name] = s.err
+			}
+		case <-expired:
+			expired = nil
+			for _, name := range names {
+				if !finished[name] && plugins[name].State() != StateNew {
+					killed = true
+					go plugins[name].killNow()
+				}
+			}
+		}
+	}
+
+	if killed {
+		return results, ctx.Err()
+	}
+	for _, name := range names {
+		if err := errs[name]; err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// Kill the plugin process, if still running.
+func (p *Plugin) killNow() {
+	s := &signalReq{kill: true, wr: newWaiter()}
+	select {
+	case p.sigCh <- s:
+		s.wr.wait()
+	case <-p.done:
+	}
+}
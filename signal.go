@@ -0,0 +1,144 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var errNotRunning = ErrNotRunning(errors.New("Plugin is not running"))
+
+type signalReq struct {
+	sig os.Signal
+	// Kill the process, as when it does not exit in time
+	kill   bool
+	client *rpc.Client
+	err    error
+	wr     *waiter
+}
+
+// Signal sends sig to the plugin process.  If the operating system cannot deliver
+// the signal (for example on Windows), it is forwarded to the plugin via RPC.  Either
+// way, functions registered in the plugin via OnSignal are called.
+//
+// Returns ErrNotStarted if the plugin has not been started, and ErrNotRunning if it has
+// been stopped or its process has exited.
+func (p *Plugin) Signal(sig os.Signal) error {
+	if p.State() == StateNew {
+		return errNotStarted
+	}
+	s := &signalReq{sig: sig, wr: newWaiter()}
+	select {
+	case p.sigCh <- s:
+		s.wr.wait()
+	case <-p.done:
+		return errNotRunning
+	}
+
+	if s.client == nil {
+		return s.err
+	}
+	num, ok := sig.(syscall.Signal)
+	if !ok {
+		return s.err
+	}
+	return s.client.Call(internalObject+".Signal", int(num), nil)
+}
+
+// Called by the control loop.  Sets a client if the signal must be sent via RPC.
+func (c *ctrl) signal(s *signalReq) {
+	if c.proc == nil {
+		s.err = errNotRunning
+		return
+	}
+	if s.kill {
+		c.kill()
+		return
+	}
+	s.err = c.proc.Signal(s.sig)
+	if s.err != nil && c.client != nil && c.connCh != nil {
+		s.client = c.client
+	}
+}
+
+// ForwardSignals makes the host forward the given signals, when it receives them,
+// to all plugins added to the manager.  Plugins not started or not running anymore are
+// skipped; other errors are reported to each plugin's ErrorHandler.
+func (m *Manager) ForwardSignals(sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		for sig := range ch {
+			for _, name := range m.Names() {
+				p := m.Plugin(name)
+				if p == nil {
+					continue
+				}
+				err := p.Signal(sig)
+				if err != nil && err != errNotStarted && err != errNotRunning {
+					p.errorHandler().Error(err)
+				}
+			}
+		}
+	}()
+}
+
+var (
+	signalMux   sync.Mutex
+	signalHooks = make(map[syscall.Signal][]func())
+	signalCh    = make(chan os.Signal, 1)
+)
+
+// OnSignal registers fn to be called when the plugin receives sig.  Signals sent by the
+// host via Plugin.Signal always trigger fn, also on platforms where they cannot be
+// delivered by the operating system.
+func OnSignal(sig os.Signal, fn func()) {
+	num, ok := sig.(syscall.Signal)
+	if !ok {
+		panic("OnSignal: unsupported signal type")
+	}
+
+	signalMux.Lock()
+	defer signalMux.Unlock()
+
+	if len(signalHooks) == 0 {
+		go watchSignals()
+	}
+	if _, ok := signalHooks[num]; !ok {
+		signal.Notify(signalCh, sig)
+	}
+	signalHooks[num] = append(signalHooks[num], fn)
+}
+
+func watchSignals() {
+	for sig := range signalCh {
+		if num, ok := sig.(syscall.Signal); ok {
+			runSignalHooks(num)
+		}
+	}
+}
+
+func runSignalHooks(sig syscall.Signal) {
+	signalMux.Lock()
+	hooks := make([]func(), len(signalHooks[sig]))
+	copy(hooks, signalHooks[sig])
+	signalMux.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// Internal RPC call to deliver a signal sent by the host. Do not call manually.
+func (s *PingoRpc) Signal(sig int, unused *int) error {
+	runSignalHooks(syscall.Signal(sig))
+	return nil
+}
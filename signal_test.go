@@ -0,0 +1,45 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// Send a signal to p, failing if it blocks.
+func signalWithin(t *testing.T, p *Plugin) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Signal(os.Interrupt)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Signal blocked")
+		return nil
+	}
+}
+
+func TestSignalNotStarted(t *testing.T) {
+	p := newFixture(t, "unix", "pingo-hello-world")
+	if err := signalWithin(t, p); err != errNotStarted {
+		t.Fatalf("expected ErrNotStarted, got %v", err)
+	}
+}
+
+func TestSignalStopped(t *testing.T) {
+	p := newFixture(t, "unix", "pingo-hello-world")
+	p.Start()
+	if err := sayHello(p); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+	if err := signalWithin(t, p); err != errNotRunning {
+		t.Fatalf("expected ErrNotRunning, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Control messages can be signed with a key the host passes to the plugin on an
+// inherited pipe, whose descriptor is in PINGO_KEY_FD.  The plugin reads and closes
+// the pipe when writing its first control message.  Each message then ends with
+// " mac=" and the hex HMAC-SHA256 of its field and of its sequence number, starting
+// from zero, so that messages cannot be forged nor replayed without the key.
+
+const controlKeyEnv = "PINGO_KEY_FD"
+
+var errUnauthenticated = ErrInvalidMessage(errors.New("Control message not authenticated"))
+
+// SetSignedControl makes the host require that all control messages written by the
+// plugin are authenticated with a key only the plugin process receives.  Code in the
+// plugin that writes to the standard output, like a misbehaving library, cannot then
+// pass for the plugin in the handshake.  Messages that fail authentication are
+// reported to the ErrorHandler and treated as output of the plugin.
+//
+// The plugin must be built with a version of this package that supports it, and must
+// not use Delegate.  Not supported on Windows.  Panics if called after Start.
+func (p *Plugin) SetSignedControl(enabled bool) {
+	if p.running {
+		panic("Cannot call SetSignedControl after Start")
+	}
+	p.signed = enabled
+}
+
+type lineMAC struct {
+	key []byte
+	seq uint64
+	mux sync.Mutex
+}
+
+func newLineMAC(key []byte) *lineMAC {
+	return &lineMAC{key: key}
+}
+
+func (m *lineMAC) sum(field string) []byte {
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], m.seq)
+	h := hmac.New(sha256.New, m.key)
+	h.Write(seq[:])
+	h.Write([]byte(field))
+	return h.Sum(nil)
+}
+
+// Append the MAC of the next message to field.
+func (m *lineMAC) sign(field string) string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	field = field + " mac=" + hex.EncodeToString(m.sum(field))
+	m.seq++
+	return field
+}
+
+// Return the field without its MAC if it is the next expected message.
+func (m *lineMAC) verify(signed string) (string, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	i := strings.LastIndex(signed, " mac=")
+	if i < 0 {
+		return "", false
+	}
+	field := signed[:i]
+	mac, err := hex.DecodeString(signed[i+len(" mac="):])
+	if err != nil || !hmac.Equal(mac, m.sum(field)) {
+		return "", false
+	}
+	m.seq++
+	return field, true
+}
+
+// Descriptor of the key pipe in the plugin, after the activation listener.
+const controlKeyFd = listenFdsStart + 1
+
+// Pass a new key to cmd on a pipe, as its descriptor fd, and return the MAC to verify
+// its messages.  Descriptors before fd that are not passed are closed in the plugin.
+func setControlKey(cmd *exec.Cmd, fd int) (*lineMAC, error) {
+	i := fd - listenFdsStart
+	if i < len(cmd.ExtraFiles) {
+		return nil, fmt.Errorf("Descriptor %d of the control key already in use", fd)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	key := randstr(64)
+	_, err = w.WriteString(key)
+	w.Close()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	// Files appended later, for example by a command modifier, do not move the pipe
+	for len(cmd.ExtraFiles) < i {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, nil)
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, r)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", controlKeyEnv, fd))
+	return newLineMAC([]byte(key)), nil
+}
+
+var (
+	controlOnce sync.Once
+	controlMAC  *lineMAC
+)
+
+// Return the MAC to sign the messages of this plugin, or nil if the host did not
+// pass a key.
+func pluginMAC() *lineMAC {
+	controlOnce.Do(func() {
+		fds := os.Getenv(controlKeyEnv)
+		if fds == "" {
+			return
+		}
+		// Not for processes started by the plugin
+		os.Unsetenv(controlKeyEnv)
+
+		fd, err := strconv.Atoi(fds)
+		if err != nil || fd < listenFdsStart {
+			return
+		}
+		f := os.NewFile(uintptr(fd), "pingo-key")
+		defer f.Close()
+		key, err := io.ReadAll(io.LimitReader(f, 1024))
+		if err != nil || len(key) == 0 {
+			return
+		}
+		controlMAC = newLineMAC(key)
+	})
+	return controlMAC
+}
+
+// Parse a line of plugin output, authenticating it if control messages are signed.
+func (c *ctrl) parse(line string) (key, val string) {
+	if c.mac == nil {
+		return c.p.meta.parse(line)
+	}
+	rest, ok := strings.CutPrefix(line, string(c.p.meta)+": ")
+	if !ok {
+		return "", ""
+	}
+	field, ok := c.mac.verify(rest)
+	if !ok {
+		c.p.errorHandler().Error(errUnauthenticated)
+		return "", ""
+	}
+	return parseControl(field)
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSignedOutputInOrder(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	controlOnce.Do(func() {})
+	controlMAC = newLineMAC(key)
+	defer func() { controlMAC = nil }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	const writers, lines = 16, 200
+	go func() {
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < lines; j++ {
+					meta("pingo").output("error", "concurrent output")
+				}
+			}()
+		}
+		wg.Wait()
+		w.Close()
+	}()
+
+	host := newLineMAC(key)
+	n := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rest, _ := strings.CutPrefix(scanner.Text(), "pingo: ")
+		if _, ok := host.verify(rest); !ok {
+			t.Fatalf("line %d failed authentication: %s", n, scanner.Text())
+		}
+		n++
+	}
+	if n != writers*lines {
+		t.Fatalf("read %d lines, expected %d", n, writers*lines)
+	}
+}
+
+func TestSignedControlWithExtraFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signed control messages are not supported on Windows")
+	}
+	devnull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devnull.Close()
+
+	p := newFixture(t, "unix", "pingo-hello-world")
+	p.SetSignedControl(true)
+	p.SetErrorHandler(failHandler{t})
+	p.SetCmdModifier(func(cmd *exec.Cmd) {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, devnull)
+	})
+	p.Start()
+	defer p.Stop()
+
+	if err := sayHello(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Fails the test on any error reported by the plugin.
+type failHandler struct {
+	t *testing.T
+}
+
+func (h failHandler) Error(err error) {
+	h.t.Error(err)
+}
+
+func (h failHandler) Print(interface{}) {}
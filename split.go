@@ -0,0 +1,78 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// BranchStats are the statistics of the calls a Splitter routed to one of its plugins.
+type BranchStats struct {
+	// Number of calls performed
+	Calls int64
+	// Number of calls that returned an error
+	Errors int64
+	// Total time spent in calls
+	Duration time.Duration
+}
+
+// Splitter routes calls between a stable plugin and a canary, see Split.
+type Splitter struct {
+	stable, canary Caller
+	percent        atomic.Int32
+	stats          [2]callStats
+}
+
+// Split returns a caller routing percent of the calls to canary, for example a new build
+// of a plugin, and the others to stable.  Compare the statistics of both with Stats
+// before routing more calls to canary with SetPercent.
+func Split(stable, canary Caller, percent int) *Splitter {
+	s := &Splitter{stable: stable, canary: canary}
+	s.SetPercent(percent)
+	return s
+}
+
+// SetPercent changes the percentage of calls routed to the canary.  Values are clamped
+// between 0 and 100.
+func (s *Splitter) SetPercent(percent int) {
+	s.percent.Store(int32(min(max(percent, 0), 100)))
+}
+
+// Stats returns the statistics of the calls routed to the stable plugin and to the canary.
+func (s *Splitter) Stats() (stable, canary BranchStats) {
+	return s.stats[0].branchStats(), s.stats[1].branchStats()
+}
+
+func (s *Splitter) Call(name string, args interface{}, resp interface{}) (err error) {
+	c, stats := s.pick()
+	start := time.Now()
+	defer func() { stats.record(start, err) }()
+	return c.Call(name, args, resp)
+}
+
+func (s *Splitter) CallContext(ctx context.Context, name string, args interface{}, resp interface{}) (err error) {
+	c, stats := s.pick()
+	start := time.Now()
+	defer func() { stats.record(start, err) }()
+	return c.CallContext(ctx, name, args, resp)
+}
+
+// Choose the plugin for a call.
+func (s *Splitter) pick() (Caller, *callStats) {
+	if randUint64()%100 < uint64(s.percent.Load()) {
+		return s.canary, &s.stats[1]
+	}
+	return s.stable, &s.stats[0]
+}
+
+func (s *callStats) branchStats() BranchStats {
+	return BranchStats{
+		Calls:    atomic.LoadInt64(&s.calls),
+		Errors:   atomic.LoadInt64(&s.errors),
+		Duration: time.Duration(atomic.LoadInt64(&s.duration)),
+	}
+}
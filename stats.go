@@ -0,0 +1,229 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// State of a plugin in its lifecycle.
+type State int32
+
+const (
+	// Created, but not started yet
+	StateNew State = iota
+	// Started, waiting for the plugin to register
+	StateStarting
+	// Registered and accepting calls
+	StateReady
+	// Stopped because of an unrecoverable error
+	StateFailed
+	// The plugin process has exited
+	StateStopped
+)
+
+var stateNames = [...]string{"new", "starting", "ready", "failed", "stopped"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return "unknown"
+	}
+	return stateNames[s]
+}
+
+// MarshalText represents the state by its name.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// State returns the current state of the plugin.
+func (p *Plugin) State() State {
+	return State(atomic.LoadInt32(&p.state))
+}
+
+func (p *Plugin) setState(s State) {
+	atomic.StoreInt32(&p.state, int32(s))
+}
+
+// Stats are the call statistics of a plugin.
+type Stats struct {
+	State State
+	// Number of calls performed
+	Calls int64
+	// Number of calls that returned an error
+	Errors int64
+	// Total time spent in calls
+	Duration time.Duration
+	// Number of sessions open, see NewSession
+	Sessions int64
+	// Number of sessions closed because they were garbage collected without Close
+	SessionsLeaked int64
+	// Statistics by method name
+	Methods map[string]MethodStats
+}
+
+// MethodStats are the statistics of the calls to a single method.  Sizes include
+// the headers of requests and responses, as encoded on the connection.
+type MethodStats struct {
+	// Number of requests sent
+	Calls int64
+	// Bytes of requests sent
+	RequestBytes int64
+	// Bytes of responses received
+	ResponseBytes int64
+}
+
+type callStats struct {
+	calls    int64
+	errors   int64
+	duration int64
+	// Statistics by method name
+	methodMux sync.Mutex
+	methods   map[string]*MethodStats
+}
+
+func (s *callStats) method(name string) *MethodStats {
+	if s.methods == nil {
+		s.methods = make(map[string]*MethodStats)
+	}
+	m, ok := s.methods[name]
+	if !ok {
+		m = &MethodStats{}
+		s.methods[name] = m
+	}
+	return m
+}
+
+// Record the size of a request sent on the connection.
+func (s *callStats) recordRequest(method string, n int64) {
+	name, _, _ := strings.Cut(method, ";")
+	if obj, _, _ := strings.Cut(name, "."); isInternalObject(obj) {
+		return
+	}
+	s.methodMux.Lock()
+	defer s.methodMux.Unlock()
+	m := s.method(name)
+	m.Calls++
+	m.RequestBytes += n
+}
+
+// Record the size of a response received on the connection.
+func (s *callStats) recordResponse(method string, n int64) {
+	if obj, _, _ := strings.Cut(method, "."); isInternalObject(obj) {
+		return
+	}
+	s.methodMux.Lock()
+	defer s.methodMux.Unlock()
+	s.method(method).ResponseBytes += n
+}
+
+func (s *callStats) methodStats() map[string]MethodStats {
+	s.methodMux.Lock()
+	defer s.methodMux.Unlock()
+	methods := make(map[string]MethodStats, len(s.methods))
+	for name, m := range s.methods {
+		methods[name] = *m
+	}
+	return methods
+}
+
+func (s *callStats) record(start time.Time, err error) {
+	atomic.AddInt64(&s.calls, 1)
+	atomic.AddInt64(&s.duration, int64(time.Since(start)))
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+	}
+}
+
+// Maximum length of the arguments summary in SlowCallInfo
+const slowCallArgsLen = 256
+
+// SlowCallInfo describes a call that took longer than the threshold set
+// with SetSlowCallThreshold.
+type SlowCallInfo struct {
+	Method   string
+	Duration time.Duration
+	// Arguments formatted with "%v", truncated if too long
+	Args string
+	Err  error
+}
+
+// SetSlowCallThreshold makes the plugin call fn after each call that took longer than d.
+// A zero duration disables reporting.
+//
+// Panics if called after Start.
+func (p *Plugin) SetSlowCallThreshold(d time.Duration, fn func(SlowCallInfo)) {
+	if p.running {
+		panic("Cannot call SetSlowCallThreshold after Start")
+	}
+	p.slowCall = d
+	p.slowCallFn = fn
+}
+
+func (p *Plugin) callDone(method string, args interface{}, resp interface{}, start time.Time, err error) {
+	p.stats.record(start, err)
+	if obj, _, _ := strings.Cut(method, "."); !isInternalObject(obj) {
+		if err != nil {
+			p.emit(Event{Type: EventCallErrored, Method: method, Err: err})
+		}
+		if p.recorder != nil {
+			p.recorder.record(p, method, args, resp, err)
+		}
+	}
+	p.chaosKill(method)
+
+	if p.slowCall <= 0 || p.slowCallFn == nil {
+		return
+	}
+	if d := time.Since(start); d > p.slowCall {
+		summary := truncate(fmt.Sprintf("%v", args), slowCallArgsLen)
+		p.slowCallFn(SlowCallInfo{Method: method, Duration: d, Args: summary, Err: err})
+	}
+}
+
+// Stats returns the current state and call statistics of the plugin.
+func (p *Plugin) Stats() Stats {
+	return Stats{
+		State:          p.State(),
+		Calls:          atomic.LoadInt64(&p.stats.calls),
+		Errors:         atomic.LoadInt64(&p.stats.errors),
+		Duration:       time.Duration(atomic.LoadInt64(&p.stats.duration)),
+		Sessions:       p.sessions.open.Load(),
+		SessionsLeaked: p.sessions.leaked.Load(),
+		Methods:        p.stats.methodStats(),
+	}
+}
+
+func (m *Manager) stats() map[string]Stats {
+	stats := make(map[string]Stats)
+	for _, name := range m.Names() {
+		if p := m.Plugin(name); p != nil {
+			stats[name] = p.Stats()
+		}
+	}
+	return stats
+}
+
+// StatsJSON returns the statistics of all plugins in the manager as a JSON object
+// keyed by plugin name.
+func (m *Manager) StatsJSON() ([]byte, error) {
+	return json.Marshal(m.stats())
+}
+
+// PublishExpvar publishes the statistics of all plugins in the manager under the
+// given expvar name, making them available on /debug/vars.
+//
+// Like expvar.Publish, it panics if the name is already in use.
+func (m *Manager) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.stats()
+	}))
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// StopReason tells how a plugin process ended.
+type StopReason int
+
+const (
+	// The process exited by itself, usually after the host called Exit
+	StopExited StopReason = iota
+	// The process was terminated by a signal not sent by the host
+	StopSignaled
+	// The process was killed by the host, for example because it did not
+	// exit in time after Stop or after an unrecoverable error
+	StopKilled
+	// The plugin was attached to and keeps running
+	StopDetached
+	// The plugin was stopped before being started
+	StopNotStarted
+)
+
+var stopReasonNames = [...]string{"exited", "signaled", "killed", "detached", "not-started"}
+
+func (r StopReason) String() string {
+	if r < 0 || int(r) >= len(stopReasonNames) {
+		return "unknown"
+	}
+	return stopReasonNames[r]
+}
+
+// MarshalText represents the reason by its name.
+func (r StopReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// StopResult describes how a plugin was shut down by Stop.
+type StopResult struct {
+	Reason StopReason
+	// Exit code of the process, -1 if it was terminated by a signal or the
+	// code is not known, for example for delegates or attached plugins.
+	ExitCode int
+	// Time Stop took to shut down the plugin
+	Duration time.Duration
+}
+
+// Clean returns true if the process exited by itself with code zero.
+func (r StopResult) Clean() bool {
+	return r.Reason == StopExited && r.ExitCode == 0
+}
+
+func (r StopResult) String() string {
+	return fmt.Sprintf("%s with code %d in %s", r.Reason, r.ExitCode, r.Duration)
+}
+
+// Record how the process ended from the error returned when waiting for it.
+func (c *ctrl) exited(err error) {
+	r := StopResult{Reason: StopExited, ExitCode: -1}
+	switch e := err.(type) {
+	case nil:
+		// Delegates are not our children, their exit code is unknown
+		if !c.watched {
+			r.ExitCode = 0
+		}
+	case *exec.ExitError:
+		r.ExitCode = e.ExitCode()
+		if r.ExitCode < 0 {
+			r.Reason = StopSignaled
+		}
+	}
+	if c.killed {
+		r.Reason = StopKilled
+	}
+	c.result = r
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Start and stop a plugin, waiting until its resources are released.
+func startStop(t *testing.T, p *Plugin) {
+	p.Start()
+	p.Objects()
+	done := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stop blocked")
+	}
+	select {
+	case <-p.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("resources not released after Stop")
+	}
+}
+
+// Wait until no more goroutines than before are running.
+func checkGoroutines(t *testing.T, before int) {
+	deadline := time.Now().Add(5 * time.Second)
+	n := runtime.NumGoroutine()
+	for n > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		n = runtime.NumGoroutine()
+	}
+	if n > before {
+		buf := make([]byte, 1<<20)
+		t.Fatalf("%d goroutines leaked:\n%s", n-before, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestStopReleasesGoroutines(t *testing.T) {
+	for _, name := range []string{"pingo-hello-world", "pingo-crash", "pingo-never-exit"} {
+		t.Run(name, func(t *testing.T) {
+			// Goroutines started once per process are running after the first cycle
+			startStop(t, newFixture(t, "unix", name))
+			before := runtime.NumGoroutine()
+
+			for i := 0; i < 3; i++ {
+				p := newFixture(t, "unix", name)
+				if name == "pingo-crash" {
+					p.Start()
+					p.Call("Plugin.Crash", 1, nil)
+				}
+				startStop(t, p)
+			}
+			checkGoroutines(t, before)
+		})
+	}
+}
+
+func TestStopWithInheritedOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	before := runtime.NumGoroutine()
+
+	// The output of the plugin stays open in a process it started
+	p := newFixture(t, "unix", "pingo-hello-world")
+	p.SetCmdModifier(func(cmd *exec.Cmd) {
+		cmd.Args = append([]string{"/bin/sh", "-c", `sleep 5 & exec "$0" "$@"`}, cmd.Args...)
+		cmd.Path = "/bin/sh"
+	})
+	if err := func() error { p.Start(); return sayHello(p) }(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	startStop(t, p)
+	if d := time.Since(start); d > 4*time.Second {
+		t.Fatalf("Stop waited %s for the output to be closed", d)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestStopDuringHandshake(t *testing.T) {
+	// Internal calls left unanswered by the plugin
+	for _, call := range []string{"ListObjects", "Schemas"} {
+		t.Run(call, func(t *testing.T) {
+			p := newFixture(t, "tcp", "pingo-silent")
+			p.SetTimeout(time.Minute)
+			p.SetSchemaPolicy(SchemaStrict)
+			p.SetCmdModifier(func(cmd *exec.Cmd) {
+				cmd.Env = append(os.Environ(), "PINGO_SILENT_CALL="+call)
+			})
+			p.Start()
+			// Let the plugin announce itself and the host connect
+			time.Sleep(500 * time.Millisecond)
+
+			done := make(chan struct{})
+			go func() {
+				p.Stop()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Stop blocked behind the handshake")
+			}
+		})
+	}
+}
+
+func TestSilentFixtureAnswers(t *testing.T) {
+	p := newFixture(t, "tcp", "pingo-silent")
+	p.SetSchemaPolicy(SchemaStrict)
+	p.SetCmdModifier(func(cmd *exec.Cmd) {
+		cmd.Env = append(os.Environ(), "PINGO_SILENT_CALL=NotCalled")
+	})
+	p.Start()
+	defer p.Stop()
+	if err := sayHello(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopNotStarted(t *testing.T) {
+	f, err := os.Open(fixturePath("pingo-hello-world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := NewEmbeddedPlugin("unix", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetErrorHandler(quietHandler{})
+
+	done := make(chan StopResult, 1)
+	go func() {
+		res, _ := p.Stop()
+		done <- res
+	}()
+	select {
+	case res := <-done:
+		if res.Reason != StopNotStarted {
+			t.Fatalf("unexpected stop reason %s", res.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on a plugin never started")
+	}
+	select {
+	case <-p.Done():
+	default:
+		t.Fatal("resources not released after Stop")
+	}
+	if _, err := os.Stat(p.exe); !os.IsNotExist(err) {
+		t.Fatalf("extracted executable not removed: %v", err)
+	}
+
+	// Stopped for good
+	p.Start()
+	if err := sayHello(p); err == nil {
+		t.Fatal("call succeeded after Stop")
+	}
+	if p.State() != StateStopped {
+		t.Fatalf("state after Stop is %s", p.State())
+	}
+}
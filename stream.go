@@ -0,0 +1,118 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"time"
+)
+
+// Address reported for connections over a stream.
+const streamAddr = "stream"
+
+// AttachConn returns a plugin using the protocol over rwc, for example a serial port, a
+// tunnel or a pipe, with a plugin served on the other end by ServeConn.  It authenticates
+// with token and waits for the plugin to report its objects, returning an error if it
+// fails to.  Like for plugins returned by Attach, no process is started and Stop only
+// closes rwc.
+//
+// The returned plugin has no I/O timeouts.
+func AttachConn(rwc io.ReadWriteCloser, token string) (*Plugin, error) {
+	p := NewPlugin("unix", streamAddr)
+	p.attachTo = streamAddr
+	p.token = token
+	p.stream = rwc
+	p.Start()
+	if _, err := p.Objects(); err != nil {
+		p.Stop()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Connect over the stream of an attached plugin.
+func (c *ctrl) openStream() bool {
+	var err error
+
+	c.timings.Ready = c.elapsed()
+	c.client, c.dc, err = authRpc(c.secret, &streamConn{c.p.stream}, c.p)
+	c.timings.Dial = c.elapsed() - c.timings.Ready
+	if err != nil {
+		c.fatal(err)
+		return false
+	}
+	return true
+}
+
+// ServeConn serves the objects registered with Register over rwc, to a host connected
+// with AttachConn using the same token.  ServeConn returns when rwc is closed, and can be
+// used in place of Run or together with it.  Timeouts set with SetIOTimeouts only apply
+// if rwc has SetReadDeadline and SetWriteDeadline methods, like net.Conn and os.File.
+//
+// ServeConn will panic if token is empty.
+func ServeConn(rwc io.ReadWriteCloser, token string) {
+	if token == "" {
+		panic("ServeConn requires a token")
+	}
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	defaultServer.running = true
+	defaultServer.serveConn(rwc, token)
+}
+
+// Connection over a stream, with deadlines if supported.
+type streamConn struct {
+	io.ReadWriteCloser
+}
+
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+var errNoDeadline = errors.New("Stream does not support deadlines")
+
+func (s *streamConn) LocalAddr() net.Addr {
+	return streamConnAddr{}
+}
+
+func (s *streamConn) RemoteAddr() net.Addr {
+	return streamConnAddr{}
+}
+
+func (s *streamConn) SetDeadline(t time.Time) error {
+	if err := s.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.SetWriteDeadline(t)
+}
+
+func (s *streamConn) SetReadDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return errNoDeadline
+}
+
+func (s *streamConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := s.ReadWriteCloser.(deadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return errNoDeadline
+}
+
+type streamConnAddr struct{}
+
+func (streamConnAddr) Network() string {
+	return streamAddr
+}
+
+func (streamConnAddr) String() string {
+	return streamAddr
+}
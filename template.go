@@ -0,0 +1,70 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"os"
+	"strings"
+)
+
+// Command template used for plugins without one, as fields separated by spaces.
+const commandTemplateEnv = "PINGO_COMMAND_TEMPLATE"
+
+const (
+	templateExe  = "{exe}"
+	templateArgs = "{args}"
+)
+
+// SetCommandTemplate starts the plugin through a command line built from template, to
+// run it under wrappers like nice, time, strace or a script.  In the template, "{exe}"
+// is replaced by the path of the plugin and "{args}" by its arguments.  If "{exe}" is
+// missing, the path and arguments are appended to the template.  For example:
+//
+//	p.SetCommandTemplate("nice", "-n", "10", "{exe}", "{args}")
+//
+// Without a template, the one in the PINGO_COMMAND_TEMPLATE environment variable of the
+// host is used if set, with fields separated by spaces.  This allows running plugins
+// under a debugger without changing the host.
+//
+// The process started is the wrapper: it must run the plugin with the standard output
+// it inherited, and signals and Stop are sent to it.  A checksum set with SetChecksum
+// still applies to the executable of the plugin.
+//
+// Panics if called after Start.
+func (p *Plugin) SetCommandTemplate(template ...string) {
+	if p.running {
+		panic("Cannot call SetCommandTemplate after Start")
+	}
+	p.template = template
+}
+
+// Return the command line starting exe with params, following the template if any.
+func (p *Plugin) commandLine(exe string, params []string) []string {
+	template := p.template
+	if len(template) == 0 {
+		template = strings.Fields(os.Getenv(commandTemplateEnv))
+	}
+	if len(template) == 0 {
+		return append([]string{exe}, params...)
+	}
+
+	argv := make([]string, 0, len(template)+len(params)+1)
+	var hasExe bool
+	for _, field := range template {
+		switch field {
+		case templateExe:
+			argv = append(argv, exe)
+			hasExe = true
+		case templateArgs:
+			argv = append(argv, params...)
+		default:
+			argv = append(argv, field)
+		}
+	}
+	if !hasExe {
+		argv = append(append(argv, exe), params...)
+	}
+	return argv
+}
@@ -0,0 +1,39 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import "time"
+
+// StartupTimings break down the time a plugin took to start accepting calls.  All
+// durations are measured from Start.
+type StartupTimings struct {
+	// Until the plugin process was started; zero for attached plugins
+	Exec time.Duration
+	// Until the plugin reported the objects it exports
+	Objects time.Duration
+	// Until the plugin reported it was listening for connections
+	Ready time.Duration
+	// Time spent connecting and authenticating to the plugin, after Ready
+	Dial time.Duration
+	// Until calls were accepted
+	Total time.Duration
+}
+
+// StartupTimings returns how long the plugin took in each step of its startup.  Use it
+// to find slow steps and to choose a realistic timeout with SetTimeout.
+//
+// The second value is false until the plugin has become ready.
+func (p *Plugin) StartupTimings() (StartupTimings, bool) {
+	t := p.timings.Load()
+	if t == nil {
+		return StartupTimings{}, false
+	}
+	return *t, true
+}
+
+// Time elapsed since the plugin was started.
+func (c *ctrl) elapsed() time.Duration {
+	return c.p.clock.Now().Sub(c.started)
+}
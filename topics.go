@@ -0,0 +1,138 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Number of values kept for each topic until the host receives them
+const topicBuffer = 256
+
+// Maximum time the plugin holds a request for values of a topic
+const topicWait = time.Second
+
+// Values published on a topic, not yet received by the host.
+type topicQueue struct {
+	values [][]byte
+	// Closed when a value is published
+	ready chan struct{}
+}
+
+var topics = struct {
+	mux    sync.Mutex
+	queues map[string]*topicQueue
+}{queues: make(map[string]*topicQueue)}
+
+// Queue of topic.  Must be called with topics.mux held.
+func topicQueueOf(topic string) *topicQueue {
+	q := topics.queues[topic]
+	if q == nil {
+		q = &topicQueue{ready: make(chan struct{})}
+		topics.queues[topic] = q
+	}
+	return q
+}
+
+// Publish sends v to the host on topic, see Subscribe.  Values wait in the plugin until
+// the host receives them; once more than a few hundred are waiting on a topic, the oldest
+// are dropped.  Returns an error if v cannot be encoded with gob.
+func Publish(topic string, v interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+
+	topics.mux.Lock()
+	defer topics.mux.Unlock()
+
+	q := topicQueueOf(topic)
+	if len(q.values) == topicBuffer {
+		q.values = q.values[1:]
+	}
+	q.values = append(q.values, buf.Bytes())
+	close(q.ready)
+	q.ready = make(chan struct{})
+	return nil
+}
+
+// Take the values waiting on topic, as many as fit in a response.
+func takeValues(topic string) ([][]byte, <-chan struct{}) {
+	topics.mux.Lock()
+	defer topics.mux.Unlock()
+
+	q := topicQueueOf(topic)
+	n, size := 0, 0
+	for ; n < len(q.values); n++ {
+		size += len(q.values[n])
+		if n > 0 && defaultServer.maxResponse > 0 && size > defaultServer.maxResponse/2 {
+			break
+		}
+	}
+	values := q.values[:n:n]
+	q.values = q.values[n:]
+	return values, q.ready
+}
+
+// Internal RPC call to receive the values published on a topic, waiting a little if
+// there are none. Do not call manually.
+func (s *PingoRpc) Receive(topic string, values *[][]byte) error {
+	vals, ready := takeValues(topic)
+	if len(vals) == 0 {
+		select {
+		case <-ready:
+			vals, _ = takeValues(topic)
+		case <-time.After(topicWait):
+		}
+	}
+	*values = vals
+	return nil
+}
+
+// Call fn with each value published by the plugin on topic, until ctx is done or the
+// plugin is stopped.  Requests for values are not counted as calls and are retried
+// after errors; errors returned by the plugin are reported to the ErrorHandler.
+func (p *Plugin) receive(ctx context.Context, topic string, fn func(data []byte)) {
+	for {
+		conn, err := p.connect(ctx)
+		if err != nil {
+			return
+		}
+		var values [][]byte
+		call := conn.client.Go(internalObject+".Receive", topic, &values, make(chan *rpc.Call, 1))
+		select {
+		case <-call.Done:
+		case <-ctx.Done():
+			return
+		}
+		if call.Error != nil {
+			// Connection errors are reported when the plugin fails
+			if _, ok := call.Error.(rpc.ServerError); ok {
+				p.errorHandler().Error(call.Error)
+			}
+			select {
+			case <-p.done:
+				return
+			case <-ctx.Done():
+				return
+			case <-time.After(topicWait):
+			}
+			continue
+		}
+		for _, data := range values {
+			fn(data)
+		}
+	}
+}
+
+// Decode a value published on a topic.
+func decodeValue(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
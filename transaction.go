@@ -0,0 +1,243 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const errorCodeTransactionDone = "err-transaction-done"
+
+// Parameter carrying the transaction of a call
+const transactionParam = "tx"
+
+// TxID identifies a transaction between the host and a plugin.
+type TxID uint64
+
+// Tx performs calls in a transaction, see Plugin.Transaction.  Tx implements Caller.
+type Tx struct {
+	p    *Plugin
+	id   TxID
+	ctx  context.Context
+	done atomic.Bool
+}
+
+// Transaction runs fn in a transaction with the plugin: the plugin begins the
+// transaction with the functions set with OnTransaction, and the calls made through tx
+// get its state, see InTransaction.  If fn returns nil, the plugin commits the
+// transaction; if fn returns an error or panics, or ctx is done, the plugin rolls it
+// back.  Calls through tx fail with ErrTransactionDone once fn has returned.
+//
+// Returns the error of fn, or of the plugin beginning or committing the transaction.
+// Like CallContext, Transaction waits until the plugin has been initialized, or until
+// ctx is done.
+//
+// Requires the plugin to be built with a version of this package supporting transactions.
+func (p *Plugin) Transaction(ctx context.Context, fn func(tx *Tx) error) error {
+	id := TxID(randUint64())
+	for id == 0 {
+		id = TxID(randUint64())
+	}
+	if err := p.callContext(ctx, internalObject+".BeginTx", uint64(id), nil); err != nil {
+		return err
+	}
+
+	tx := &Tx{p: p, id: id, ctx: ctx}
+	committed := false
+	defer func() {
+		tx.done.Store(true)
+		if !committed {
+			// Roll back even if ctx is done, not to leave the transaction open
+			p.Call(internalObject+".RollbackTx", uint64(id), nil)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx.done.Store(true)
+	committed = true
+	return p.Call(internalObject+".CommitTx", uint64(id), nil)
+}
+
+// ID returns the identifier of the transaction, the same as seen by the plugin.
+func (tx *Tx) ID() TxID {
+	return tx.id
+}
+
+// Call performs a call to the plugin in the transaction, giving up when the context
+// passed to Transaction is done.
+func (tx *Tx) Call(name string, args interface{}, resp interface{}) error {
+	return tx.CallContext(tx.ctx, name, args, resp)
+}
+
+// CallContext performs a call to the plugin in the transaction, see Plugin.CallContext.
+func (tx *Tx) CallContext(ctx context.Context, name string, args interface{}, resp interface{}) error {
+	if tx.done.Load() {
+		return errTransactionDone
+	}
+	return tx.p.callWith(ctx, name, args, resp, CallOpts{tx: tx.id})
+}
+
+var errTransactionDone = ErrTransactionDone(errors.New("Transaction is already committed or rolled back"))
+
+// Transaction running in the plugin.
+type runningTx struct {
+	state interface{}
+	// Client that began the transaction, see AddClient
+	client string
+}
+
+// Transactions running in the plugin by ID, and the functions handling them.
+var transactions = struct {
+	mux      sync.Mutex
+	running  map[TxID]runningTx
+	begin    func(id TxID) (interface{}, error)
+	commit   func(id TxID, state interface{}) error
+	rollback func(id TxID, state interface{})
+}{running: make(map[TxID]runningTx)}
+
+// OnTransaction sets the functions handling the transactions of the host, see
+// Plugin.Transaction.  The value returned by begin is the state of the transaction,
+// available to methods called in the transaction (see InTransaction) and passed to
+// commit or rollback when the host ends the transaction.  An error returned by begin is
+// returned to the host and no transaction is started; an error returned by commit is
+// returned to the host too, and commit must then undo what it can.  Any function can be
+// nil.
+//
+// OnTransaction will panic if called after Run.
+func OnTransaction(begin func(id TxID) (interface{}, error), commit func(id TxID, state interface{}) error, rollback func(id TxID, state interface{})) {
+	if defaultServer.running {
+		panic("Do not call OnTransaction after Run")
+	}
+	transactions.begin = begin
+	transactions.commit = commit
+	transactions.rollback = rollback
+}
+
+// End the transaction with id begun by client, returning its state.
+func endTransaction(id TxID, client string) (interface{}, bool) {
+	transactions.mux.Lock()
+	defer transactions.mux.Unlock()
+	tx, ok := transactions.running[id]
+	// Transactions of other clients are left running
+	if !ok || tx.client != client {
+		return nil, false
+	}
+	delete(transactions.running, id)
+	return tx.state, true
+}
+
+// Roll back the transactions begun by the client with name.
+func rollbackClientTransactions(name string) {
+	ended := make(map[TxID]interface{})
+	transactions.mux.Lock()
+	for id, tx := range transactions.running {
+		if tx.client == name {
+			delete(transactions.running, id)
+			ended[id] = tx.state
+		}
+	}
+	transactions.mux.Unlock()
+	if transactions.rollback != nil {
+		for id, state := range ended {
+			transactions.rollback(id, state)
+		}
+	}
+}
+
+// Internal RPC call to begin a transaction. Do not call manually.
+func (s *PingoRpc) BeginTx(id uint64, unused *int) error {
+	var state interface{}
+	if transactions.begin != nil {
+		var err error
+		if state, err = transactions.begin(TxID(id)); err != nil {
+			return err
+		}
+	}
+	transactions.mux.Lock()
+	transactions.running[TxID(id)] = runningTx{state: state, client: s.client}
+	transactions.mux.Unlock()
+	return nil
+}
+
+// Internal RPC call to commit a transaction. Do not call manually.
+func (s *PingoRpc) CommitTx(id uint64, unused *int) error {
+	state, ok := endTransaction(TxID(id), s.client)
+	if !ok {
+		return rpc.ServerError(errorCodeTransactionDone + ": Transaction " + strconv.FormatUint(id, 10) + " is not running")
+	}
+	if transactions.commit != nil {
+		return transactions.commit(TxID(id), state)
+	}
+	return nil
+}
+
+// Internal RPC call to roll back a transaction. Do not call manually.
+func (s *PingoRpc) RollbackTx(id uint64, unused *int) error {
+	state, ok := endTransaction(TxID(id), s.client)
+	if ok && transactions.rollback != nil {
+		transactions.rollback(TxID(id), state)
+	}
+	return nil
+}
+
+// InTransaction can be embedded in the arguments of a plugin method, so that the method
+// gets the transaction it was called in, like InSession.  InTransaction is not
+// transmitted, the host may or may not include it in its arguments.
+type InTransaction func() (TxID, interface{})
+
+// ID returns the transaction of the call, zero for calls made outside transactions.
+func (t InTransaction) ID() TxID {
+	if t == nil {
+		return 0
+	}
+	id, _ := t()
+	return id
+}
+
+// State returns the state of the transaction of the call, as returned by the begin
+// function set with OnTransaction; nil for calls made outside transactions.
+func (t InTransaction) State() interface{} {
+	if t == nil {
+		return nil
+	}
+	_, state := t()
+	return state
+}
+
+func (t *InTransaction) setTransaction(id TxID, state interface{}) {
+	*t = func() (TxID, interface{}) { return id, state }
+}
+
+// Give body the transaction of the request being read.  Fails if the transaction is
+// not running.
+func (c *serverCodec) bindTransaction(body interface{}) error {
+	id := c.params.tx
+	if id == 0 {
+		return nil
+	}
+	transactions.mux.Lock()
+	tx, ok := transactions.running[id]
+	transactions.mux.Unlock()
+	// Transactions of other clients are not visible
+	if !ok || tx.client != c.client {
+		return rpc.ServerError(errorCodeTransactionDone + ": Transaction " + strconv.FormatUint(uint64(id), 10) + " is not running")
+	}
+	if b, ok := body.(interface {
+		setTransaction(TxID, interface{})
+	}); ok {
+		b.setTransaction(id, tx.state)
+	}
+	return nil
+}
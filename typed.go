@@ -0,0 +1,147 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// Call is like Plugin.Call, with the types of the request and of the response checked
+// at compile time.
+//
+//	resp, err := pingo.Call[string, string](p, "MyPlugin.SayHello", "Go developer")
+func Call[Req, Resp any](c Caller, name string, req Req) (Resp, error) {
+	var resp Resp
+	err := c.Call(name, req, &resp)
+	return resp, err
+}
+
+// CallContext is like Plugin.CallContext, with the types of the request and of the
+// response checked at compile time.
+func CallContext[Req, Resp any](ctx context.Context, c Caller, name string, req Req) (Resp, error) {
+	var resp Resp
+	err := c.CallContext(ctx, name, req, &resp)
+	return resp, err
+}
+
+// Subscribe returns a channel receiving the values the plugin publishes on topic with
+// Publish, decoded as T, and a function to cancel the subscription.  The channel is
+// closed once the subscription is cancelled or the plugin is stopped.
+//
+// Like Call, Subscribe waits for the plugin to be initialized.  Each value is received
+// by one subscription to the topic.  Values that cannot be decoded as T are reported to
+// the ErrorHandler and skipped.
+func Subscribe[T any](p *Plugin, topic string) (<-chan T, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		p.receive(ctx, topic, func(data []byte) {
+			var v T
+			if err := decodeValue(data, &v); err != nil {
+				p.errorHandler().Error(err)
+				return
+			}
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return ch, cancel
+}
+
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// NewTypedClient returns a client of type I calling the methods of the plugin, or of
+// another Caller.  As Go
+// cannot implement interfaces at run time, I must be a struct type with function
+// fields: each exported field is set to a function calling the method with the same
+// name of the object named like I, or the method in the "pingo" tag of the field.
+// Functions take the request, optionally preceded by a context, and return the response
+// and an error, or only an error if the method has no useful response:
+//
+//	type MyPlugin struct {
+//		SayHello func(name string) (string, error)
+//		Shutdown func(ctx context.Context, code int) error `pingo:"Admin.Shutdown"`
+//	}
+//
+//	client, err := pingo.NewTypedClient[MyPlugin](p)
+//	resp, err := client.SayHello("Go developer")
+//
+// Returns an error if I is not such a struct.  The plugin is not contacted until a
+// function is called.
+func NewTypedClient[I any](c Caller) (I, error) {
+	var client I
+	v := reflect.ValueOf(&client).Elem()
+	t := v.Type()
+	if t.Kind() != reflect.Struct {
+		return client, errors.New("Typed client " + t.String() + " is not a struct of functions")
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Tag.Get("pingo")
+		if name == "" {
+			if t.Name() == "" {
+				return client, errors.New("Field " + f.Name + " of anonymous typed client needs a method in its tag")
+			}
+			name = t.Name() + "." + f.Name
+		}
+		fn, err := typedMethod(c, name, f.Type)
+		if err != nil {
+			return client, errors.New("Field " + f.Name + " of typed client " + t.String() + ": " + err.Error())
+		}
+		v.Field(i).Set(fn)
+	}
+	return client, nil
+}
+
+// Make a function of type ft calling the method name with c.
+func typedMethod(c Caller, name string, ft reflect.Type) (reflect.Value, error) {
+	if ft.Kind() != reflect.Func || ft.IsVariadic() {
+		return reflect.Value{}, errors.New("not a function")
+	}
+	withCtx := ft.NumIn() == 2 && ft.In(0) == typeOfContext
+	if ft.NumIn() != 1 && !withCtx {
+		return reflect.Value{}, errors.New("function must take a request, optionally preceded by a context")
+	}
+	if ft.NumOut() < 1 || ft.NumOut() > 2 || ft.Out(ft.NumOut()-1) != typeOfError {
+		return reflect.Value{}, errors.New("function must return an error, optionally preceded by a response")
+	}
+	withResp := ft.NumOut() == 2
+
+	return reflect.MakeFunc(ft, func(in []reflect.Value) []reflect.Value {
+		var resp reflect.Value
+		var respPtr interface{}
+		if withResp {
+			resp = reflect.New(ft.Out(0))
+			respPtr = resp.Interface()
+		}
+		var err error
+		if withCtx {
+			ctx, _ := in[0].Interface().(context.Context)
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			err = c.CallContext(ctx, name, in[1].Interface(), respPtr)
+		} else {
+			err = c.Call(name, in[0].Interface(), respPtr)
+		}
+
+		errVal := reflect.Zero(typeOfError)
+		if err != nil {
+			errVal = reflect.ValueOf(&err).Elem()
+		}
+		if withResp {
+			return []reflect.Value{resp.Elem(), errVal}
+		}
+		return []reflect.Value{errVal}
+	}), nil
+}
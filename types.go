@@ -0,0 +1,204 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const errorCodeUnregisteredType = "err-unregistered-type"
+
+// RegisterType records the concrete type of value, so that values of this type can be
+// sent in fields of interface type of call arguments and results.  Types are matched by
+// name: register the same types in the host and in the plugin, for example in an init
+// function of a package shared by both.
+//
+// RegisterType is the same as gob.Register, and panics in the same cases.
+func RegisterType(value interface{}) {
+	gob.Register(value)
+}
+
+// UnregisteredTypeError is returned by calls whose arguments or results hold, in a field
+// of interface type, a value of a type not registered with RegisterType.
+//
+// Arguments and results are checked for types not registered by their sender before
+// being sent.  Types registered by the sender but not by the receiver are only detected
+// when decoding: the call fails and so do later calls, as the connection to the plugin
+// cannot be used anymore.
+type UnregisteredTypeError struct {
+	Method string
+	// Name of the type, as registered
+	Type string
+	// The type is not registered in the plugin, or else in the host
+	InPlugin bool
+}
+
+func (e *UnregisteredTypeError) Error() string {
+	side := "host"
+	if e.InPlugin {
+		side = "plugin"
+	}
+	return e.Method + ": type " + e.Type + " is not registered in the " + side + ", see pingo.RegisterType"
+}
+
+// Types whose values can hold interfaces, by type.
+var interfaceTypes sync.Map
+
+// Whether values of t can hold interfaces, whose concrete types must be registered.
+func hasInterface(t reflect.Type) bool {
+	if has, ok := interfaceTypes.Load(t); ok {
+		return has.(bool)
+	}
+	has := walkInterfaces(t, make(map[reflect.Type]bool))
+	interfaceTypes.Store(t, has)
+	return has
+}
+
+var typeOfGobEncoder = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+
+func walkInterfaces(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] || t.Implements(typeOfGobEncoder) {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return walkInterfaces(t.Elem(), seen)
+	case reflect.Map:
+		return walkInterfaces(t.Key(), seen) || walkInterfaces(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() && walkInterfaces(f.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Return the name of a type held by v that cannot be encoded because it is not
+// registered.  Only values that can hold interfaces are checked, by encoding them
+// apart so that a failure does not break the connection.
+func unregisteredValue(v interface{}) (string, bool) {
+	t := reflect.TypeOf(v)
+	if t == nil || !hasInterface(t) {
+		return "", false
+	}
+	if _, err := encodedSize(v); err != nil {
+		return unregisteredType(err)
+	}
+	return "", false
+}
+
+// Return the name of the type from an error of gob about an unregistered type.
+func unregisteredType(err error) (string, bool) {
+	msg := err.Error()
+	for _, prefix := range []string{
+		"gob: type not registered for interface: ",
+		"gob: name not registered for interface: ",
+	} {
+		if i := strings.Index(msg, prefix); i >= 0 {
+			name := msg[i+len(prefix):]
+			if s, err := strconv.Unquote(name); err == nil {
+				name = s
+			}
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Why values of types are not encodable, by type.
+var unencodableTypes sync.Map
+
+// Return an ErrUnencodableArgument if args cannot be sent to method, or resp cannot
+// hold its result, because of the types used.
+func checkEncodable(method string, args interface{}, resp interface{}) error {
+	if why := unencodable(reflect.TypeOf(args)); why != "" {
+		return ErrUnencodableArgument(fmt.Errorf("%s: argument of type %T cannot be sent: %s", method, args, why))
+	}
+	if why := unencodable(reflect.TypeOf(resp)); why != "" {
+		return ErrUnencodableArgument(fmt.Errorf("%s: reply of type %T cannot be received: %s", method, resp, why))
+	}
+	return nil
+}
+
+// Return why values of t cannot be encoded by gob, or an empty string.  Values held
+// by interfaces are checked when the call is sent.
+func unencodable(t reflect.Type) string {
+	if t == nil {
+		return ""
+	}
+	if why, ok := unencodableTypes.Load(t); ok {
+		return why.(string)
+	}
+	why := walkUnencodable(t, make(map[reflect.Type]bool))
+	unencodableTypes.Store(t, why)
+	return why
+}
+
+func walkUnencodable(t reflect.Type, seen map[reflect.Type]bool) string {
+	if seen[t] || implementsGobEncoding(t) {
+		return ""
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Chan:
+		return t.String() + " is a channel"
+	case reflect.Func:
+		return t.String() + " is a function"
+	case reflect.UnsafePointer:
+		return t.String() + " is an unsafe pointer"
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return walkUnencodable(t.Elem(), seen)
+	case reflect.Map:
+		if why := walkUnencodable(t.Key(), seen); why != "" {
+			return why
+		}
+		return walkUnencodable(t.Elem(), seen)
+	case reflect.Struct:
+		// Fields of chan and func types are ignored like unexported ones
+		var fields int
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() || f.Type.Kind() == reflect.Chan || f.Type.Kind() == reflect.Func {
+				continue
+			}
+			fields++
+			if why := walkUnencodable(f.Type, seen); why != "" {
+				return why
+			}
+		}
+		if fields == 0 && t.NumField() > 0 {
+			return t.String() + " has no exported fields"
+		}
+	}
+	return ""
+}
+
+var (
+	typeOfBinaryMarshaler = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	typeOfTextMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Whether values of t, or pointers to them, encode themselves.
+func implementsGobEncoding(t reflect.Type) bool {
+	for _, it := range []reflect.Type{typeOfGobEncoder, typeOfBinaryMarshaler, typeOfTextMarshaler} {
+		if t.Implements(it) || (t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(it)) {
+			return true
+		}
+	}
+	return false
+}
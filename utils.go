@@ -5,48 +5,92 @@
 package pingo
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
-	"math/rand"
-	"strings"
+	"io"
+	"sync"
+	"unicode/utf8"
 )
 
 type meta string
 
+// Serializes control messages, so that signed ones are written in the order of their
+// sequence numbers
+var outputMux sync.Mutex
+
 func (h meta) output(key, val string) {
-	fmt.Printf("%s: %s: %s\n", string(h), key, val)
-}
+	field := key + ": " + quoteValue(val)
 
-func (h meta) parse(line string) (key, val string) {
-	if line == "" {
-		return
-	}
+	outputMux.Lock()
+	defer outputMux.Unlock()
 
-	if len(line) < len(string(h)) {
-		return
+	if mac := pluginMAC(); mac != nil {
+		field = mac.sign(field)
 	}
+	fmt.Printf("%s: %s\n", string(h), field)
+}
 
-	if line[0:len(string(h))] != string(h) {
-		return
-	}
+// Exactly 64 letters: each random byte maps to a letter without bias.
+var _letters = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-")
 
-	line = line[len(string(h))+2:]
-	end := strings.IndexByte(line, ':')
-	if end < 0 {
-		return
-	}
+var (
+	randMux    sync.Mutex
+	randSource io.Reader = rand.Reader
+)
 
-	return line[0:end], line[end+2:]
-}
+// SetRandSource replaces the source of randomness used for prefixes, authentication
+// tokens and socket names.  Only use it in tests, to make these values reproducible:
+// predictable tokens and socket names are a security risk.
+//
+// Passing nil restores the default source, crypto/rand.
+func SetRandSource(r io.Reader) {
+	randMux.Lock()
+	defer randMux.Unlock()
 
-var _letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-")
+	if r == nil {
+		r = rand.Reader
+	}
+	randSource = r
+}
 
+// Return a random string of n letters, each carrying six bits of entropy
+// from the random source.
 func randstr(n int) string {
-	b := make([]rune, n)
-	l := len(_letters)
+	randMux.Lock()
+	defer randMux.Unlock()
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(randSource, b); err != nil {
+		panic("Cannot read random bytes: " + err.Error())
+	}
 
 	for i := range b {
-		b[i] = _letters[rand.Intn(l)]
+		b[i] = _letters[b[i]&63]
 	}
 
 	return string(b)
 }
+
+// Return a random number from the random source.
+func randUint64() uint64 {
+	randMux.Lock()
+	defer randMux.Unlock()
+
+	var b [8]byte
+	if _, err := io.ReadFull(randSource, b[:]); err != nil {
+		panic("Cannot read random bytes: " + err.Error())
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// Cut s to at most n bytes followed by "...", without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,49 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long", 3, "too..."},
+		{"añb", 2, "a..."},
+		{"日本語", 4, "日..."},
+		{"日本語", 6, "日本..."},
+		{"日本語", 2, "..."},
+		{"", 0, ""},
+	} {
+		if got := truncate(c.s, c.n); got != c.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", c.s, c.n, got, c.want)
+		}
+	}
+}
+
+func TestSlowCallArgsValidUTF8(t *testing.T) {
+	p := NewPlugin("unix", "unused")
+	var info SlowCallInfo
+	p.SetSlowCallThreshold(time.Nanosecond, func(i SlowCallInfo) { info = i })
+
+	// The limit falls in the middle of a rune
+	args := "x" + strings.Repeat("é", slowCallArgsLen)
+	p.callDone("Plugin.Method", args, nil, time.Now().Add(-time.Second), nil)
+	if !utf8.ValidString(info.Args) {
+		t.Fatalf("arguments summary %q is not valid UTF-8", info.Args)
+	}
+	if !strings.HasSuffix(info.Args, "...") || len(info.Args) > slowCallArgsLen+len("...") {
+		t.Fatalf("arguments summary of %d bytes not truncated", len(info.Args))
+	}
+}
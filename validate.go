@@ -0,0 +1,58 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+const errorCodeValidation = "err-validation"
+
+// ValidationError is returned by Call when the plugin rejected the arguments
+// of a call via the function set with SetValidator.
+type ValidationError struct {
+	Method  string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return "Invalid arguments for " + e.Method + ": " + e.Message
+}
+
+var (
+	validatorMux sync.Mutex
+	validator    func(method string, args interface{}) error
+)
+
+// SetValidator sets a function called with the arguments of each call before it is
+// dispatched to the registered object.  Args is a pointer to the decoded arguments.
+// If fn returns an error, the call is not performed and the host receives a
+// ValidationError containing the error message.
+//
+// Calls to internal objects are not validated.
+func SetValidator(fn func(method string, args interface{}) error) {
+	validatorMux.Lock()
+	defer validatorMux.Unlock()
+	validator = fn
+}
+
+func validate(method string, args interface{}) error {
+	validatorMux.Lock()
+	fn := validator
+	validatorMux.Unlock()
+
+	if fn == nil {
+		return nil
+	}
+	if dot := strings.IndexByte(method, '.'); dot < 0 || isInternalObject(method[:dot]) {
+		return nil
+	}
+	if err := fn(method, args); err != nil {
+		return rpc.ServerError(errorCodeValidation + ": " + method + ": " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WatchdogOpts configures the hang detection started by Manager.Watch.
+type WatchdogOpts struct {
+	// New returns the plugin replacing a hung one, the plugin added as name, configured
+	// but not started.  Required.
+	New func(name string, old *Plugin) *Plugin
+	// Interval between heartbeats, one second if zero
+	Interval time.Duration
+	// Maximum time for a heartbeat to be answered, Interval if zero
+	Timeout time.Duration
+	// Number of consecutive failed heartbeats after which a plugin is hung, three if zero
+	Failures int
+	// A plugin with a call running for longer is hung as soon as a heartbeat fails,
+	// disabled if zero
+	CallCeiling time.Duration
+	// Called with the report of each hung plugin, before it is killed
+	OnHang func(HangReport)
+	// Called when a hung plugin has been killed, with StateFailed, and when its
+	// replacement is started and becomes ready or fails
+	OnStateChange func(name string, p *Plugin, state State)
+	// Clock timing heartbeats, the time package if nil
+	Clock Clock
+}
+
+// HangReport describes a plugin declared hung by a watchdog.
+type HangReport struct {
+	Name   string
+	Plugin *Plugin
+	// Why the plugin is considered hung
+	Reason string
+	// State of the plugin process, collected with DebugDump
+	Dump DebugInfo
+	// Why the dump could not be collected, Dump is empty then
+	DumpErr error
+}
+
+// Watch starts a watchdog that sends heartbeats to the ready plugins of the manager,
+// every opts.Interval.  A plugin that fails opts.Failures consecutive heartbeats, or
+// fails one while a call has been running for longer than opts.CallCeiling, is
+// considered hung: a debug dump is collected and passed to opts.OnHang, then the
+// plugin is killed and replaced by a new one created by opts.New.  Calls still
+// running on the hung plugin return ErrHung.
+//
+// The watchdog runs in the background until ctx is done.  Plugins not ready yet, or
+// that failed for other reasons, are not checked.
+func (m *Manager) Watch(ctx context.Context, opts WatchdogOpts) {
+	if opts.New == nil {
+		panic("Watch requires WatchdogOpts.New")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = opts.Interval
+	}
+	if opts.Failures <= 0 {
+		opts.Failures = 3
+	}
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+	w := &watchdog{
+		m:       m,
+		opts:    &opts,
+		plugins: make(map[string]*watched),
+	}
+	go w.run(ctx)
+}
+
+// Heartbeat state of a plugin.
+type watched struct {
+	p        *Plugin
+	failures int
+	// Being checked or replaced
+	busy bool
+}
+
+type watchdog struct {
+	m       *Manager
+	opts    *WatchdogOpts
+	mux     sync.Mutex
+	plugins map[string]*watched
+}
+
+func (w *watchdog) run(ctx context.Context) {
+	ticker := w.opts.Clock.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		names := w.m.Names()
+		w.mux.Lock()
+		current := make(map[string]bool, len(names))
+		for _, name := range names {
+			current[name] = true
+			p := w.m.Plugin(name)
+			if p == nil {
+				continue
+			}
+			s, ok := w.plugins[name]
+			if !ok || s.p != p {
+				if ok && s.busy {
+					continue
+				}
+				s = &watched{p: p}
+				w.plugins[name] = s
+			}
+			if s.busy || p.State() != StateReady {
+				continue
+			}
+			s.busy = true
+			go w.check(ctx, name, s)
+		}
+		for name, s := range w.plugins {
+			if !current[name] && !s.busy {
+				delete(w.plugins, name)
+			}
+		}
+		w.mux.Unlock()
+	}
+}
+
+// Send a heartbeat to the plugin and replace it if it is hung.
+func (w *watchdog) check(ctx context.Context, name string, s *watched) {
+	defer func() {
+		w.mux.Lock()
+		s.busy = false
+		w.mux.Unlock()
+	}()
+
+	c := s.p.readyConn.Load()
+	if c == nil {
+		return
+	}
+	err := heartbeat(ctx, w.opts.Clock, c, w.opts.Timeout)
+	if ctx.Err() != nil || s.p.State() != StateReady {
+		return
+	}
+	if err == nil {
+		s.failures = 0
+		return
+	}
+	s.failures++
+
+	var reason string
+	if d := c.dc.longest(); w.opts.CallCeiling > 0 && d > w.opts.CallCeiling {
+		reason = "Call running for " + d.String() + " and heartbeat failed: " + err.Error()
+	} else if s.failures >= w.opts.Failures {
+		reason = strconv.Itoa(s.failures) + " consecutive heartbeats failed: " + err.Error()
+	} else {
+		return
+	}
+	w.replace(ctx, name, s.p, reason)
+}
+
+// Call Ping on the connection, failing if not answered within timeout.  The call
+// is not counted in the statistics of the plugin nor as a running call.
+func heartbeat(ctx context.Context, clock Clock, c *conn, timeout time.Duration) error {
+	t := clock.NewTimer(timeout)
+	defer t.Stop()
+
+	call := c.client.Go(internalObject+".Ping", 0, nil, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return wrapIOError(call.Error)
+	case <-t.C():
+		return errors.New("Heartbeat not answered within " + timeout.String())
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Kill the hung plugin added as name and replace it with a new one.
+func (w *watchdog) replace(ctx context.Context, name string, p *Plugin, reason string) {
+	report := HangReport{Name: name, Plugin: p, Reason: reason}
+	dctx, cancel := context.WithTimeout(ctx, w.opts.Timeout)
+	report.Dump, report.DumpErr = p.DebugDump(dctx)
+	cancel()
+	if w.opts.OnHang != nil {
+		w.opts.OnHang(report)
+	}
+
+	p.errorHandler().Error(errors.New("Plugin " + name + " is hung: " + reason))
+	p.fail(ErrHung(errors.New("Plugin killed by watchdog: " + reason)))
+	w.notify(name, p, StateFailed)
+
+	np := w.opts.New(name, p)
+	w.m.mux.Lock()
+	replaced := w.m.plugins[name] == p
+	if replaced {
+		w.m.prepare(name, np)
+		w.m.plugins[name] = np
+		w.m.ignoreEvents(p)
+	}
+	w.m.mux.Unlock()
+	p.Stop()
+	if !replaced {
+		// Removed or replaced in the meantime
+		return
+	}
+	np.emit(Event{Type: EventRestarted, Replaced: p})
+
+	np.Start()
+	w.notify(name, np, StateStarting)
+	poll := w.opts.Clock.NewTicker(restartPollInterval)
+	defer poll.Stop()
+	for np.State() == StateStarting {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C():
+		}
+	}
+	w.notify(name, np, np.State())
+}
+
+func (w *watchdog) notify(name string, p *Plugin, state State) {
+	if w.opts.OnStateChange != nil {
+		w.opts.OnStateChange(name, p, state)
+	}
+}
+
+// Make the plugin fail with err, killing its process.
+func (p *Plugin) fail(err error) {
+	select {
+	case p.failCh <- err:
+	case <-p.done:
+	}
+}
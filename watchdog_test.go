@@ -0,0 +1,66 @@
+// Copyright 2015 Giulio Iotti. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package pingo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestWatchdogManualClock(t *testing.T) {
+	// Answers calls, but not heartbeats
+	p := newFixture(t, "tcp", "pingo-silent")
+	p.SetCmdModifier(func(cmd *exec.Cmd) {
+		cmd.Env = append(os.Environ(), "PINGO_SILENT_CALL=RpcV1.Ping")
+	})
+	m := NewManager()
+	m.Add("silent", p)
+	p.Start()
+	if err := sayHello(p); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := NewManualClock(time.Now())
+	hung := make(chan HangReport, 1)
+	m.Watch(ctx, WatchdogOpts{
+		New: func(name string, old *Plugin) *Plugin {
+			return newFixture(t, "unix", "pingo-hello-world")
+		},
+		// Never elapsed in real time
+		Interval: time.Hour,
+		// Bounds the debug dump of the hung plugin too
+		Timeout:  time.Second,
+		Failures: 1,
+		Clock:    clock,
+		OnHang:   func(r HangReport) { hung <- r },
+	})
+
+	deadline := time.After(10 * time.Second)
+	for {
+		clock.Advance(time.Hour)
+		select {
+		case r := <-hung:
+			if r.Plugin != p {
+				t.Fatalf("plugin %s reported hung", r.Name)
+			}
+			cancel()
+			// Wait for the replacement to be started
+			for m.Plugin("silent") == p {
+				time.Sleep(10 * time.Millisecond)
+			}
+			p.Stop()
+			m.Plugin("silent").Stop()
+			return
+		case <-deadline:
+			t.Fatal("hung plugin not detected")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}